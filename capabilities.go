@@ -0,0 +1,248 @@
+package ipvs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CapabilitiesClient is implemented by Clients which can detect the IPVS
+// features supported by the kernel. Use a type assertion to check for
+// support.
+type CapabilitiesClient interface {
+	Capabilities() (Capabilities, error)
+}
+
+// Capabilities describes the IPVS features supported by the running kernel.
+//
+// IPVS has reported the same Info.Version since Linux 2.6, and older kernels
+// silently ignore attributes they do not know about, so capabilities are
+// derived from the kernel release, the generic netlink registration of the
+// IPVS family, and the IPVS modules which are installed or loaded.
+type Capabilities struct {
+	// Kernel is the release of the running kernel, such as {6, 1, 0}.
+	Kernel [3]int `json:"kernel"`
+
+	// Version is the version of IPVS, as reported by Info.
+	Version [3]int `json:"version"`
+
+	// FamilyVersion, MaxAttr, and Commands are reported by generic
+	// netlink for the IPVS family. Commands are numbered as in the
+	// kernel's linux/ip_vs.h. The client refuses commands, and top-level
+	// attributes above MaxAttr, which the family did not register.
+	FamilyVersion uint8  `json:"familyVersion"`
+	MaxAttr       uint32 `json:"maxAttr"`
+	Commands      []int  `json:"commands"`
+
+	// MixedFamily is set when a Destination may use a different address
+	// family from its Service, such as IPv6 real servers behind an IPv4
+	// virtual service. Older kernels ignore the Destination's Family.
+	MixedFamily bool `json:"mixedFamily"`
+
+	// Stats64 is set when statistics are reported with 64-bit counters.
+	Stats64 bool `json:"stats64"`
+
+	// TunnelTypes lists the encapsulations supported for the Tunnel
+	// forwarding method. TunnelFlags is set when checksums can be
+	// configured with the TunnelFlags of a Destination.
+	TunnelTypes []TunnelType `json:"tunnelTypes"`
+	TunnelFlags bool         `json:"tunnelFlags"`
+
+	// PersistenceEngines lists the available persistence engines, such as "sip".
+	PersistenceEngines []string `json:"persistenceEngines"`
+
+	// Schedulers lists the available schedulers included with Linux.
+	Schedulers []string `json:"schedulers"`
+}
+
+// SupportsTunnel reports whether the Tunnel forwarding method can use t.
+func (c Capabilities) SupportsTunnel(t TunnelType) bool {
+	return slices.Contains(c.TunnelTypes, t)
+}
+
+// SupportsScheduler reports whether the named scheduler is available.
+// Schedulers which are not included with Linux cannot be detected, and are
+// assumed to be available.
+func (c Capabilities) SupportsScheduler(name string) bool {
+	return !Scheduler(name).Known() || slices.Contains(c.Schedulers, name)
+}
+
+// check returns a *FieldError wrapping errors.ErrUnsupported for each
+// feature used by svc, or dest if it is not nil, which c does not include.
+// Families must be resolved.
+func (c Capabilities) check(svc Service, dest *Destination) error {
+	var errs fieldErrors
+	unsupported := func(field, format string, args ...any) {
+		errs.add(field, "%s is not supported by Linux %d.%d: %w",
+			fmt.Sprintf(format, args...), c.Kernel[0], c.Kernel[1], errors.ErrUnsupported)
+	}
+
+	if dest == nil {
+		if !c.SupportsScheduler(svc.Scheduler) {
+			unsupported("Scheduler", "scheduler %q", svc.Scheduler)
+		}
+		return errs.err()
+	}
+
+	if !c.MixedFamily && dest.Family != svc.Family {
+		unsupported("Family", "%s destination of %s service", dest.Family, svc.Family)
+	}
+	if dest.FwdMethod == Tunnel && !c.SupportsTunnel(dest.TunnelType) {
+		unsupported("TunnelType", "tunnel type %s", dest.TunnelType)
+	}
+	if !c.TunnelFlags && dest.TunnelFlags != 0 {
+		unsupported("TunnelFlags", "tunnel flags %s", dest.TunnelFlags)
+	}
+
+	return errs.err()
+}
+
+// kernelFeatures lists the first kernel release supporting each feature.
+// Older kernels silently ignore the attributes of features they lack, so
+// the client refuses requests using features missing from the running
+// release rather than having them dropped.
+var kernelFeatures = struct {
+	mixedFamily, stats64, tunnelFlags [3]int
+	tunnels                           map[TunnelType][3]int
+	persistenceEngines                map[string][3]int
+	schedulers                        map[string][3]int
+}{
+	mixedFamily: [3]int{3, 18, 0},
+	stats64:     [3]int{4, 1, 0},
+	tunnelFlags: [3]int{5, 3, 0},
+	tunnels: map[TunnelType][3]int{
+		GUE: {5, 2, 0},
+		GRE: {5, 3, 0},
+	},
+	persistenceEngines: map[string][3]int{
+		"sip": {2, 6, 37},
+	},
+	schedulers: map[string][3]int{
+		WeightedFailover: {4, 3, 0},
+		WeightedOverflow: {4, 18, 0},
+		MaglevHashing:    {4, 18, 0},
+		TwoRandomChoices: {6, 1, 0},
+	},
+}
+
+// capabilitiesFor returns the capabilities of a kernel release.
+func capabilitiesFor(kernel [3]int) Capabilities {
+	since := func(release [3]int) bool {
+		return slices.Compare(kernel[:], release[:]) >= 0
+	}
+
+	f := kernelFeatures
+	c := Capabilities{
+		Kernel:      kernel,
+		MixedFamily: since(f.mixedFamily),
+		Stats64:     since(f.stats64),
+		TunnelTypes: []TunnelType{IPIP},
+		TunnelFlags: since(f.tunnelFlags),
+	}
+
+	for _, t := range []TunnelType{GUE, GRE} {
+		if since(f.tunnels[t]) {
+			c.TunnelTypes = append(c.TunnelTypes, t)
+		}
+	}
+
+	for pe, release := range f.persistenceEngines {
+		if since(release) {
+			c.PersistenceEngines = append(c.PersistenceEngines, pe)
+		}
+	}
+	slices.Sort(c.PersistenceEngines)
+
+	for _, s := range Schedulers {
+		if release, ok := f.schedulers[s]; !ok || since(release) {
+			c.Schedulers = append(c.Schedulers, s)
+		}
+	}
+
+	return c
+}
+
+// withModules restricts the schedulers and persistence engines of c to
+// those provided by the IPVS modules, as returned by parseModules.
+func (c *Capabilities) withModules(modules []string) {
+	c.Schedulers = c.Schedulers[:0]
+	for _, s := range Schedulers {
+		if slices.Contains(modules, string(s)) {
+			c.Schedulers = append(c.Schedulers, s)
+		}
+	}
+
+	c.PersistenceEngines = c.PersistenceEngines[:0]
+	for _, m := range modules {
+		if pe, ok := strings.CutPrefix(m, "pe_"); ok && !slices.Contains(c.PersistenceEngines, pe) {
+			c.PersistenceEngines = append(c.PersistenceEngines, pe)
+		}
+	}
+	slices.Sort(c.PersistenceEngines)
+}
+
+// parseRelease parses the version of a kernel release, such as "6.1.0-18-amd64".
+func parseRelease(release string) ([3]int, error) {
+	var version [3]int
+
+	s := release
+	for i := range version {
+		end := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+		if end < 0 {
+			end = len(s)
+		}
+
+		n, err := strconv.Atoi(s[:end])
+		if err != nil {
+			if i > 0 {
+				break
+			}
+			return [3]int{}, fmt.Errorf("ipvs: invalid kernel release %q", release)
+		}
+		version[i] = n
+
+		if end == len(s) || s[end] != '.' {
+			break
+		}
+		s = s[end+1:]
+	}
+
+	return version, nil
+}
+
+// parseModules returns the names of the IPVS modules, without their
+// "ip_vs_" prefix, listed in /proc/modules, or the modules.dep and
+// modules.builtin files of a kernel.
+func parseModules(r io.Reader) ([]string, error) {
+	var modules []string
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		module, _, _ := strings.Cut(s.Text(), " ")
+		module = path.Base(strings.TrimSuffix(module, ":"))
+		module, _, _ = strings.Cut(module, ".ko")
+
+		if name, ok := strings.CutPrefix(module, "ip_vs_"); ok {
+			modules = append(modules, name)
+		}
+	}
+
+	return modules, s.Err()
+}
+
+// readModules reads the IPVS modules listed in the file name.
+func readModules(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseModules(f)
+}
@@ -0,0 +1,205 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/cloudflare/ipvs/internal/cipvs"
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// modulesRoot contains the modules installed for each kernel release.
+var modulesRoot = "/lib/modules"
+
+var _ CapabilitiesClient = (*client)(nil)
+
+// Capabilities returns the IPVS features supported by the running kernel,
+// as detected when the client was created.
+func (c *client) Capabilities() (Capabilities, error) {
+	switch {
+	case c.capsErr != nil:
+		return Capabilities{}, c.capsErr
+	case c.caps != nil:
+		return *c.caps, nil
+	}
+
+	return c.detectCapabilities()
+}
+
+// execute sends msg to the IPVS family. Commands and attributes which the
+// family did not register with generic netlink are refused with
+// errors.ErrUnsupported, rather than sent.
+func (c *client) execute(msg genetlink.Message, flags netlink.HeaderFlags) ([]genetlink.Message, error) {
+	if c.caps != nil {
+		if err := c.caps.registered(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.c.Execute(msg, c.family.ID, flags)
+}
+
+// registered reports whether the command of msg, and its attributes, are
+// within the generic netlink registration of the IPVS family.
+func (c Capabilities) registered(msg genetlink.Message) error {
+	cmd := msg.Header.Command
+	if c.Commands != nil && !slices.Contains(c.Commands, int(cmd)) {
+		return fmt.Errorf("ipvs: command %d: %w", cmd, errors.ErrUnsupported)
+	}
+
+	if c.MaxAttr == 0 {
+		return nil
+	}
+
+	attrs, err := netlink.UnmarshalAttributes(msg.Data)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range attrs {
+		if t := a.Type &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER); uint32(t) > c.MaxAttr {
+			return fmt.Errorf("ipvs: command %d: attribute %d: %w", cmd, t, errors.ErrUnsupported)
+		}
+	}
+
+	return nil
+}
+
+// detectCapabilities detects the IPVS features supported by the running
+// kernel.
+//
+// Features are first derived from the kernel release. When the kernel's
+// modules are installed, the available schedulers and persistence engines
+// are then narrowed to the IPVS modules which are built in, installed, or
+// loaded.
+func (c *client) detectCapabilities() (Capabilities, error) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return Capabilities{}, err
+	}
+
+	release := unix.ByteSliceToString(uts.Release[:])
+	kernel, err := parseRelease(release)
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	caps := capabilitiesFor(kernel)
+
+	info, err := c.Info()
+	if err != nil {
+		return Capabilities{}, err
+	}
+	caps.Version = info.Version
+
+	if err := c.familyCapabilities(&caps); err != nil {
+		return Capabilities{}, err
+	}
+
+	modules, err := probeModules(filepath.Join(modulesRoot, release))
+	switch {
+	case err == nil:
+		caps.withModules(modules)
+	case !errors.Is(err, fs.ErrNotExist):
+		return Capabilities{}, err
+	}
+
+	return caps, nil
+}
+
+// familyCapabilities requests the generic netlink registration of the
+// IPVS family, which includes attributes not exposed by genetlink.Family.
+func (c *client) familyCapabilities(caps *Capabilities) error {
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.CTRL_ATTR_FAMILY_NAME, cipvs.GenlName)
+	b, err := ae.Encode()
+
+	if err != nil {
+		return err
+	}
+
+	msg := genetlink.Message{
+		Header: genetlink.Header{
+			Command: unix.CTRL_CMD_GETFAMILY,
+			Version: 1,
+		},
+		Data: b,
+	}
+	flags := netlink.Request
+
+	msgs, err := c.c.Execute(msg, unix.GENL_ID_CTRL, flags)
+	if err != nil {
+		return err
+	}
+
+	if len(msgs) == 0 {
+		return os.ErrNotExist
+	}
+
+	ad, err := netlink.NewAttributeDecoder(msgs[0].Data)
+	if err != nil {
+		return err
+	}
+
+	for ad.Next() {
+		switch ad.Type() {
+		case unix.CTRL_ATTR_VERSION:
+			caps.FamilyVersion = uint8(ad.Uint32())
+		case unix.CTRL_ATTR_MAXATTR:
+			caps.MaxAttr = ad.Uint32()
+		case unix.CTRL_ATTR_OPS:
+			ad.Nested(unpackOps(&caps.Commands))
+		}
+	}
+
+	return ad.Err()
+}
+
+// unpackOps unpacks the commands of a generic netlink family.
+func unpackOps(commands *[]int) func(*netlink.AttributeDecoder) error {
+	return func(ad *netlink.AttributeDecoder) error {
+		for ad.Next() {
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				for nad.Next() {
+					if nad.Type() == unix.CTRL_ATTR_OP_ID {
+						*commands = append(*commands, int(nad.Uint32()))
+					}
+				}
+
+				return nad.Err()
+			})
+		}
+
+		return ad.Err()
+	}
+}
+
+// probeModules returns the IPVS modules built into, or installed for,
+// the kernel whose modules are in dir, and those currently loaded.
+// If the kernel's modules are not installed, fs.ErrNotExist is returned.
+func probeModules(dir string) ([]string, error) {
+	modules, err := readModules(filepath.Join(dir, "modules.dep"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{filepath.Join(dir, "modules.builtin"), ModulesPath} {
+		m, err := readModules(name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		modules = append(modules, m...)
+	}
+
+	return modules, nil
+}
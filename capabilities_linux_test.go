@@ -0,0 +1,258 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/cloudflare/ipvs/internal/cipvs"
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/genetlink/genltest"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/mdlayher/netlink/nltest"
+	"golang.org/x/sys/unix"
+	"gotest.tools/v3/assert"
+)
+
+func TestFamilyCapabilities(t *testing.T) {
+	op := func(cmd uint32) netlink.Attribute {
+		return netlink.Attribute{
+			Type: uint16(cmd),
+			Data: nltest.MustMarshalAttributes([]netlink.Attribute{
+				{Type: unix.CTRL_ATTR_OP_ID, Data: nlenc.Uint32Bytes(cmd)},
+				{Type: unix.CTRL_ATTR_OP_FLAGS, Data: nlenc.Uint32Bytes(unix.GENL_ADMIN_PERM)},
+			}),
+		}
+	}
+
+	fn := func(greq genetlink.Message, nreq netlink.Message) ([]genetlink.Message, error) {
+		assert.Equal(t, nreq.Header.Type, netlink.HeaderType(unix.GENL_ID_CTRL))
+		assert.Equal(t, greq.Header.Command, uint8(unix.CTRL_CMD_GETFAMILY))
+
+		return []genetlink.Message{{
+			Data: nltest.MustMarshalAttributes([]netlink.Attribute{
+				{Type: unix.CTRL_ATTR_FAMILY_ID, Data: nlenc.Uint16Bytes(familyID)},
+				{Type: unix.CTRL_ATTR_FAMILY_NAME, Data: nlenc.Bytes(cipvs.GenlName)},
+				{Type: unix.CTRL_ATTR_VERSION, Data: nlenc.Uint32Bytes(cipvs.GenlVersion)},
+				{Type: unix.CTRL_ATTR_MAXATTR, Data: nlenc.Uint32Bytes(cipvs.CmdAttrMax)},
+				{
+					Type: unix.CTRL_ATTR_OPS,
+					Data: nltest.MustMarshalAttributes([]netlink.Attribute{
+						op(cipvs.CmdNewService),
+						op(cipvs.CmdGetService),
+						op(cipvs.CmdGetInfo),
+					}),
+				},
+			}),
+		}}, nil
+	}
+
+	client, err := initClient(genltest.Dial(fn))
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	var caps Capabilities
+	assert.NilError(t, client.familyCapabilities(&caps))
+	assert.Equal(t, caps.FamilyVersion, uint8(cipvs.GenlVersion))
+	assert.Equal(t, caps.MaxAttr, uint32(cipvs.CmdAttrMax))
+	assert.DeepEqual(t, caps.Commands, []int{cipvs.CmdNewService, cipvs.CmdGetService, cipvs.CmdGetInfo})
+}
+
+func TestCreateDestination_Capabilities(t *testing.T) {
+	type testCase struct {
+		name        string
+		kernel      [3]int
+		destination Destination
+		expected    []uint16
+		err         string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		var types []uint16
+		fn := func(greq genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+			attrs, err := netlink.UnmarshalAttributes(greq.Data)
+			assert.NilError(t, err)
+
+			for _, attr := range attrs {
+				if attr.Type != cipvs.CmdAttrDest {
+					continue
+				}
+
+				dest, err := netlink.UnmarshalAttributes(attr.Data)
+				assert.NilError(t, err)
+				for _, a := range dest {
+					types = append(types, a.Type)
+				}
+			}
+
+			return []genetlink.Message{{}}, nil
+		}
+
+		client := testClient(t, genltest.CheckRequest(familyID, cipvs.CmdNewDest, netlink.Request|netlink.Acknowledge, fn))
+		caps := capabilitiesFor(tc.kernel)
+		client.caps = &caps
+
+		err := client.CreateDestination(Service{
+			Address:   netip.MustParseAddr("127.0.1.1"),
+			Scheduler: "wlc",
+			Port:      8080,
+			Family:    INET,
+			Protocol:  TCP,
+		}, tc.destination)
+		if tc.err != "" {
+			// Refused features are not sent to the kernel.
+			assert.ErrorIs(t, err, errors.ErrUnsupported)
+			assert.Error(t, err, tc.err)
+			assert.Equal(t, len(FieldErrors(err)), 1)
+			assert.Assert(t, types == nil)
+			return
+		}
+		assert.NilError(t, err)
+		assert.DeepEqual(t, types, tc.expected)
+	}
+
+	dest := Destination{
+		Address: netip.MustParseAddr("127.0.2.1"),
+		Port:    80,
+		Family:  INET,
+	}
+
+	testCases := []testCase{
+		{
+			name:        "supported",
+			kernel:      [3]int{6, 1, 0},
+			destination: dest,
+			expected: []uint16{
+				cipvs.DestAttrAddrFamily,
+				cipvs.DestAttrAddr,
+				cipvs.DestAttrPort,
+				cipvs.DestAttrFwdMethod,
+				cipvs.DestAttrWeight,
+				cipvs.DestAttrUThresh,
+				cipvs.DestAttrLThresh,
+				cipvs.DestAttrTunType,
+				cipvs.DestAttrTunPort,
+				cipvs.DestAttrTunFlags,
+			},
+		},
+		{
+			name:        "without tunnel flags",
+			kernel:      [3]int{5, 2, 0},
+			destination: dest,
+			expected: []uint16{
+				cipvs.DestAttrAddrFamily,
+				cipvs.DestAttrAddr,
+				cipvs.DestAttrPort,
+				cipvs.DestAttrFwdMethod,
+				cipvs.DestAttrWeight,
+				cipvs.DestAttrUThresh,
+				cipvs.DestAttrLThresh,
+				cipvs.DestAttrTunType,
+				cipvs.DestAttrTunPort,
+			},
+		},
+		{
+			name:        "without mixed family or tunnels",
+			kernel:      [3]int{3, 10, 0},
+			destination: dest,
+			expected: []uint16{
+				cipvs.DestAttrAddr,
+				cipvs.DestAttrPort,
+				cipvs.DestAttrFwdMethod,
+				cipvs.DestAttrWeight,
+				cipvs.DestAttrUThresh,
+				cipvs.DestAttrLThresh,
+			},
+		},
+		{
+			name:   "mixed family",
+			kernel: [3]int{3, 10, 0},
+			destination: Destination{
+				Address: netip.MustParseAddr("2001:db8::1"),
+				Port:    80,
+				Family:  INET6,
+			},
+			err: "ipvs: Family: INET6 destination of INET service is not supported by Linux 3.10: unsupported operation",
+		},
+		{
+			name:   "gue",
+			kernel: [3]int{4, 19, 0},
+			destination: Destination{
+				Address:    netip.MustParseAddr("127.0.2.1"),
+				FwdMethod:  Tunnel,
+				Port:       80,
+				Family:     INET,
+				TunnelType: GUE,
+				TunnelPort: 6080,
+			},
+			err: "ipvs: TunnelType: tunnel type GUE is not supported by Linux 4.19: unsupported operation",
+		},
+		{
+			name:   "tunnel flags",
+			kernel: [3]int{5, 2, 0},
+			destination: Destination{
+				Address:     netip.MustParseAddr("127.0.2.1"),
+				FwdMethod:   Tunnel,
+				Port:        80,
+				Family:      INET,
+				TunnelType:  GUE,
+				TunnelPort:  6080,
+				TunnelFlags: TunnelEncapChecksum,
+			},
+			err: "ipvs: TunnelFlags: tunnel flags TunnelEncapChecksum is not supported by Linux 5.2: unsupported operation",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestCreateService_Capabilities(t *testing.T) {
+	client := testClient(t, func(genetlink.Message, netlink.Message) ([]genetlink.Message, error) {
+		t.Fatal("unsupported scheduler sent to the kernel")
+		return nil, nil
+	})
+	caps := capabilitiesFor([3]int{4, 9, 0})
+	client.caps = &caps
+
+	err := client.CreateService(Service{
+		Address:   netip.MustParseAddr("127.0.1.1"),
+		Scheduler: MaglevHashing,
+		Port:      8080,
+		Family:    INET,
+		Protocol:  TCP,
+	})
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+	assert.Error(t, err, `ipvs: Scheduler: scheduler "mh" is not supported by Linux 4.9: unsupported operation`)
+}
+
+func TestExecute_Registered(t *testing.T) {
+	client := testClient(t, func(genetlink.Message, netlink.Message) ([]genetlink.Message, error) {
+		t.Fatal("unregistered request sent to the kernel")
+		return nil, nil
+	})
+	client.caps = &Capabilities{
+		MaxAttr:  cipvs.CmdAttrDaemon,
+		Commands: []int{cipvs.CmdNewService, cipvs.CmdGetService, cipvs.CmdGetInfo},
+	}
+
+	err := client.CreateDaemon(Daemon{State: DaemonMaster, Interface: "eth0"})
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+	assert.Error(t, err, fmt.Sprintf("ipvs: command %d: unsupported operation", cipvs.CmdNewDaemon))
+
+	client.caps.Commands = append(client.caps.Commands, cipvs.CmdSetConfig)
+	err = client.SetConfig(Config{TCPTimeout: 60})
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+	assert.Error(t, err, fmt.Sprintf("ipvs: command %d: attribute %d: unsupported operation",
+		cipvs.CmdSetConfig, cipvs.CmdAttrTimeoutTcp))
+}
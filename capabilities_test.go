@@ -0,0 +1,79 @@
+package ipvs
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParseRelease(t *testing.T) {
+	type testCase struct {
+		release  string
+		expected [3]int
+		err      string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		version, err := parseRelease(tc.release)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err)
+			return
+		}
+		assert.NilError(t, err)
+		assert.Equal(t, version, tc.expected)
+	}
+
+	testCases := []testCase{
+		{release: "6.1.0-18-amd64", expected: [3]int{6, 1, 0}},
+		{release: "5.15.153.1-microsoft-standard-WSL2", expected: [3]int{5, 15, 153}},
+		{release: "4.19", expected: [3]int{4, 19, 0}},
+		{release: "6.8-rc1", expected: [3]int{6, 8, 0}},
+		{release: "linux", err: "invalid kernel release"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.release, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestCapabilitiesFor(t *testing.T) {
+	old := capabilitiesFor([3]int{3, 10, 0})
+	assert.Assert(t, !old.MixedFamily)
+	assert.Assert(t, !old.Stats64)
+	assert.Assert(t, !old.TunnelFlags)
+	assert.DeepEqual(t, old.TunnelTypes, []TunnelType{IPIP})
+	assert.DeepEqual(t, old.PersistenceEngines, []string{"sip"})
+	assert.Assert(t, old.SupportsScheduler(WeightedLeastConnection))
+	assert.Assert(t, !old.SupportsScheduler(MaglevHashing))
+	assert.Assert(t, old.SupportsScheduler("custom"))
+
+	current := capabilitiesFor([3]int{6, 1, 0})
+	assert.Assert(t, current.MixedFamily)
+	assert.Assert(t, current.Stats64)
+	assert.Assert(t, current.TunnelFlags)
+	assert.DeepEqual(t, current.TunnelTypes, []TunnelType{IPIP, GUE, GRE})
+	assert.DeepEqual(t, current.Schedulers, Schedulers)
+}
+
+func TestCapabilitiesWithModules(t *testing.T) {
+	const modules = `kernel/net/netfilter/ipvs/ip_vs.ko.zst: kernel/net/netfilter/nf_conntrack.ko.zst
+kernel/net/netfilter/ipvs/ip_vs_rr.ko.zst: kernel/net/netfilter/ipvs/ip_vs.ko.zst
+kernel/net/netfilter/ipvs/ip_vs_mh.ko.zst: kernel/net/netfilter/ipvs/ip_vs.ko.zst
+kernel/net/netfilter/ipvs/ip_vs_pe_sip.ko.zst: kernel/net/netfilter/ipvs/ip_vs.ko.zst
+kernel/net/netfilter/ipvs/ip_vs_ftp.ko.zst: kernel/net/netfilter/ipvs/ip_vs.ko.zst
+kernel/net/ipv4/ip_tunnel.ko.zst:
+ip_vs_wlc 16384 0 - Live 0x0000000000000000
+`
+
+	m, err := parseModules(strings.NewReader(modules))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, m, []string{"rr", "mh", "pe_sip", "ftp", "wlc"})
+
+	caps := capabilitiesFor([3]int{6, 1, 0})
+	caps.withModules(m)
+	assert.DeepEqual(t, caps.Schedulers, []string{RoundRobin, WeightedLeastConnection, MaglevHashing})
+	assert.DeepEqual(t, caps.PersistenceEngines, []string{"sip"})
+}
@@ -3,7 +3,8 @@
 package ipvs
 
 import (
-	"fmt"
+	"errors"
+	"io/fs"
 	"net/netip"
 	"strings"
 
@@ -36,78 +37,127 @@ type Client interface {
 // When referencing an existing Service, only the identifying fields
 // (Address, Port, Family, and Protocol) are required to be set.
 type Service struct {
-	Address   netip.Addr
-	Netmask   netmask.Mask
-	Scheduler string
-	Timeout   uint32
-	Flags     Flags
-	Port      uint16
-	FWMark    uint32
-	Family    AddressFamily
-	Protocol  Protocol
+	Address   netip.Addr    `json:"address,omitzero"`
+	Netmask   netmask.Mask  `json:"netmask,omitzero"`
+	Scheduler string        `json:"scheduler,omitzero"`
+	Timeout   uint32        `json:"timeout,omitzero"`
+	Flags     Flags         `json:"flags,omitzero"`
+	Port      uint16        `json:"port,omitzero"`
+	FWMark    uint32        `json:"fwmark,omitzero"`
+	Family    AddressFamily `json:"family,omitzero"`
+	Protocol  Protocol      `json:"protocol,omitzero"`
 }
 
 // ServiceExtended contains fields that are not necessary for
 // comparison of the identity of a Service.
 type ServiceExtended struct {
 	Service
-	Stats   Stats
-	Stats64 Stats
+	Stats      Stats      `json:"stats,omitzero"`
+	Stats64    Stats      `json:"stats64,omitzero"`
+	StatsAttrs StatsAttrs `json:"statsAttrs,omitzero"`
+}
+
+// Counters returns the most precise statistics reported for the Service.
+// See StatsAttrs for how the source is chosen.
+func (s ServiceExtended) Counters() (stats Stats, truncated bool) {
+	return counters(s.StatsAttrs, s.Stats, s.Stats64)
 }
 
 // Destination represents a connection to the real server.
 type Destination struct {
-	Address        netip.Addr
-	FwdMethod      ForwardType
-	Weight         uint32
-	UpperThreshold uint32
-	LowerThreshold uint32
-	Port           uint16
-	Family         AddressFamily
-	TunnelType     TunnelType
-	TunnelPort     uint16
-	TunnelFlags    TunnelFlags
+	Address        netip.Addr    `json:"address,omitzero"`
+	FwdMethod      ForwardType   `json:"fwdMethod,omitzero"`
+	Weight         uint32        `json:"weight,omitzero"`
+	UpperThreshold uint32        `json:"upperThreshold,omitzero"`
+	LowerThreshold uint32        `json:"lowerThreshold,omitzero"`
+	Port           uint16        `json:"port,omitzero"`
+	Family         AddressFamily `json:"family,omitzero"`
+	TunnelType     TunnelType    `json:"tunnelType,omitzero"`
+	TunnelPort     uint16        `json:"tunnelPort,omitzero"`
+	TunnelFlags    TunnelFlags   `json:"tunnelFlags,omitzero"`
 }
 
 // DestinationExtended contains fields that are not neccesarry
 // for comparison of the identity of a Destination.
 type DestinationExtended struct {
 	Destination
-	ActiveConnections     uint32
-	InactiveConnections   uint32
-	PersistentConnections uint32
-	Stats                 Stats
-	Stats64               Stats
+	ActiveConnections     uint32     `json:"activeConnections,omitzero"`
+	InactiveConnections   uint32     `json:"inactiveConnections,omitzero"`
+	PersistentConnections uint32     `json:"persistentConnections,omitzero"`
+	Stats                 Stats      `json:"stats,omitzero"`
+	Stats64               Stats      `json:"stats64,omitzero"`
+	StatsAttrs            StatsAttrs `json:"statsAttrs,omitzero"`
+}
+
+// Counters returns the most precise statistics reported for the Destination.
+// See StatsAttrs for how the source is chosen.
+func (d DestinationExtended) Counters() (stats Stats, truncated bool) {
+	return counters(d.StatsAttrs, d.Stats, d.Stats64)
 }
 
 // Stats represents the statistics of a Service as a whole,
 // or the individual Destination connections.
 type Stats struct {
-	Connections     uint64
-	IncomingPackets uint64
-	OutgoingPackets uint64
-	IncomingBytes   uint64
-	OutgoingBytes   uint64
-
-	ConnectionRate     uint64
-	IncomingPacketRate uint64 // pktbs
-	OutgoingPacketRate uint64 // pktbs
-	IncomingByteRate   uint64 // bps
-	OutgoingByteRate   uint64 // bps
+	Connections     uint64 `json:"connections,omitzero"`
+	IncomingPackets uint64 `json:"incomingPackets,omitzero"`
+	OutgoingPackets uint64 `json:"outgoingPackets,omitzero"`
+	IncomingBytes   uint64 `json:"incomingBytes,omitzero"`
+	OutgoingBytes   uint64 `json:"outgoingBytes,omitzero"`
+
+	ConnectionRate     uint64 `json:"connectionRate,omitzero"`
+	IncomingPacketRate uint64 `json:"incomingPacketRate,omitzero"` // pktbs
+	OutgoingPacketRate uint64 `json:"outgoingPacketRate,omitzero"` // pktbs
+	IncomingByteRate   uint64 `json:"incomingByteRate,omitzero"`   // bps
+	OutgoingByteRate   uint64 `json:"outgoingByteRate,omitzero"`   // bps
+}
+
+// StatsAttrs records which statistics attribute sets were present
+// when a ServiceExtended or DestinationExtended was read from IPVS.
+//
+// Kernels which predate 64-bit statistics only report Stats, leaving
+// Stats64 zeroed. Counters uses StatsAttrs to pick Stats64 when it was
+// reported, and otherwise falls back to Stats, reporting the values as
+// truncated: the legacy attributes carry 32-bit counters and rates,
+// which wrap much sooner than their 64-bit equivalents.
+//
+// When StatsAttrs is zero, such as for values not read from the kernel,
+// Counters prefers whichever of Stats64 and Stats is non-zero.
+type StatsAttrs uint8
+
+// Statistics attribute sets.
+const (
+	HasStats StatsAttrs = 1 << iota
+	HasStats64
+)
+
+// counters chooses the authoritative statistics from the attribute sets present.
+func counters(attrs StatsAttrs, stats, stats64 Stats) (Stats, bool) {
+	switch {
+	case attrs&HasStats64 != 0:
+		return stats64, false
+	case attrs&HasStats != 0:
+		return stats, true
+	case stats64 != Stats{}:
+		return stats64, false
+	case stats != Stats{}:
+		return stats, true
+	}
+
+	return Stats{}, false
 }
 
 // Info returns basic high-level information about the IPVS instance.
 type Info struct {
-	Version             [3]int
-	ConnectionTableSize uint32
+	Version             [3]int `json:"version"`
+	ConnectionTableSize uint32 `json:"connectionTableSize"`
 }
 
 // Config represents the timeout values (in seconds) for TCP sessions,
 // TCP sessions after receiving a FIN packet, and UDP packets.
 type Config struct {
-	TCPTimeout    uint32
-	TCPFinTimeout uint32
-	UDPTimeout    uint32
+	TCPTimeout    uint32 `json:"tcpTimeout"`
+	TCPFinTimeout uint32 `json:"tcpFinTimeout"`
+	UDPTimeout    uint32 `json:"udpTimeout"`
 }
 
 // New returns an instance of Client.
@@ -116,7 +166,18 @@ func New() (Client, error) {
 	return newClient()
 }
 
-//go:generate go tool stringer -type=ForwardType,AddressFamily,Protocol,TunnelType,TunnelFlags --output zz_generated.stringer.go
+// ApplyDestination updates dest within svc through c, creating it if it
+// does not exist, such as after it was removed.
+func ApplyDestination(c Client, svc Service, dest Destination) error {
+	err := c.UpdateDestination(svc, dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c.CreateDestination(svc, dest)
+	}
+
+	return err
+}
+
+//go:generate go tool stringer -type=ForwardType,AddressFamily,Protocol,TunnelType --output zz_generated.stringer.go
 
 // ForwardType configures how IPVS forwards traffic to the real server.
 type ForwardType uint32
@@ -166,33 +227,11 @@ const (
 	ServiceSchedulerOpt3 Flags = 0x0020
 )
 
-// String returns a human readable representation of flags.
+// String returns a human readable representation of flags. The
+// scheduler-specific flags are followed by their ipvsadm names, such as
+// "ServiceSchedulerOpt1 (sh-fallback/mh-fallback)".
 func (i Flags) String() string {
-	flags := []string{}
-
-	if i&ServicePersistent != 0 {
-		flags = append(flags, "ServicePersistent")
-	}
-	if i&ServiceHashed != 0 {
-		flags = append(flags, "ServiceHashed")
-	}
-	if i&ServiceOnePacket != 0 {
-		flags = append(flags, "ServiceOnePacket")
-	}
-	if i&ServiceSchedulerOpt1 != 0 {
-		flags = append(flags, "ServiceSchedulerOpt1")
-	}
-	if i&ServiceSchedulerOpt2 != 0 {
-		flags = append(flags, "ServiceSchedulerOpt2")
-	}
-	if i&ServiceSchedulerOpt3 != 0 {
-		flags = append(flags, "ServiceSchedulerOpt3")
-	}
-	if j := i &^ (ServicePersistent | ServiceHashed | ServiceOnePacket | ServiceSchedulerOpt1 | ServiceSchedulerOpt2 | ServiceSchedulerOpt3); j != 0 {
-		flags = append(flags, fmt.Sprintf("%#x", uint32(j)))
-	}
-
-	return strings.Join(flags, " | ")
+	return strings.Join(formatFlags(i, flagNames, schedulerFlagAliases), " | ")
 }
 
 type TunnelType uint8
@@ -210,3 +249,13 @@ const (
 	TunnelEncapChecksum       TunnelFlags = 0x0001
 	TunnelEncapRemoteChecksum TunnelFlags = 0x0002
 )
+
+// String returns a human readable representation of flags, such as
+// "TunnelEncapChecksum | TunnelEncapRemoteChecksum".
+func (i TunnelFlags) String() string {
+	if i == TunnelEncapNoChecksum {
+		return "TunnelEncapNoChecksum"
+	}
+
+	return strings.Join(formatFlags(i, tunnelFlagNames, nil), " | ")
+}
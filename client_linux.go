@@ -21,17 +21,38 @@ import (
 type client struct {
 	c      *genetlink.Conn
 	family genetlink.Family
+
+	// caps are the capabilities of the kernel detected by newClient, or
+	// the error which prevented detecting them. Requests using features
+	// missing from caps are refused.
+	caps    *Capabilities
+	capsErr error
 }
 
 // newClient creates a netlink connection,
-// then passes to initClient.
+// then passes to initClient, and detects the
+// capabilities of the kernel.
 func newClient() (*client, error) {
 	c, err := genetlink.Dial(nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return initClient(c)
+	client, err := initClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// A kernel whose capabilities cannot be detected may still be
+	// configured, so the error is only returned by Capabilities.
+	caps, err := client.detectCapabilities()
+	if err != nil {
+		client.capsErr = err
+	} else {
+		client.caps = &caps
+	}
+
+	return client, nil
 }
 
 // initClient configures a netlink connection for the
@@ -59,7 +80,7 @@ func (c *client) Info() (Info, error) {
 	}
 	flags := netlink.Request
 
-	msgs, err := c.c.Execute(msg, c.family.ID, flags)
+	msgs, err := c.execute(msg, flags)
 	if err != nil {
 		return Info{}, err
 	}
@@ -105,7 +126,7 @@ func (c *client) Config() (Config, error) {
 	}
 	flags := netlink.Request
 
-	msgs, err := c.c.Execute(msg, c.family.ID, flags)
+	msgs, err := c.execute(msg, flags)
 	if err != nil {
 		return Config{}, err
 	}
@@ -161,7 +182,7 @@ func (c *client) SetConfig(config Config) error {
 	}
 	flags := netlink.Request | netlink.Acknowledge
 
-	r, err := c.c.Execute(msg, c.family.ID, flags)
+	r, err := c.execute(msg, flags)
 	if err != nil {
 		return err
 	}
@@ -173,6 +194,19 @@ func (c *client) SetConfig(config Config) error {
 	return nil
 }
 
+var _ SysctlClient = (*client)(nil)
+
+// Sysctls returns the IPVS sysctls under DefaultSysctlRoot.
+func (c *client) Sysctls() (Sysctls, error) {
+	return GetSysctls(DefaultSysctlRoot)
+}
+
+// SetSysctls changes the IPVS sysctls under DefaultSysctlRoot which are
+// set in s.
+func (c *client) SetSysctls(s Sysctls) error {
+	return SetSysctls(DefaultSysctlRoot, s)
+}
+
 // Services returns a list of Services from the netlink connection.
 func (c *client) Services() ([]ServiceExtended, error) {
 	msg := genetlink.Message{
@@ -183,7 +217,7 @@ func (c *client) Services() ([]ServiceExtended, error) {
 	}
 	flags := netlink.Request | netlink.Dump
 
-	msgs, err := c.c.Execute(msg, c.family.ID, flags)
+	msgs, err := c.execute(msg, flags)
 	if err != nil {
 		return nil, err
 	}
@@ -219,7 +253,7 @@ func (c *client) Services() ([]ServiceExtended, error) {
 // Services returns a list of Services from the netlink connection.
 func (c *client) Service(svc Service) (ServiceExtended, error) {
 	ae := netlink.NewAttributeEncoder()
-	ae.Do(cipvs.CmdAttrService, packService(svc))
+	ae.Do(cipvs.CmdAttrService, c.packService(svc))
 	b, err := ae.Encode()
 
 	if err != nil {
@@ -235,7 +269,7 @@ func (c *client) Service(svc Service) (ServiceExtended, error) {
 	}
 	flags := netlink.Request
 
-	msgs, err := c.c.Execute(msg, c.family.ID, flags)
+	msgs, err := c.execute(msg, flags)
 	if err != nil {
 		return ServiceExtended{}, err
 	}
@@ -267,7 +301,7 @@ func (c *client) Service(svc Service) (ServiceExtended, error) {
 // CreateService creates a new virtual service.
 func (c *client) CreateService(svc Service) error {
 	ae := netlink.NewAttributeEncoder()
-	ae.Do(cipvs.CmdAttrService, packService(svc))
+	ae.Do(cipvs.CmdAttrService, c.packService(svc))
 	b, err := ae.Encode()
 
 	if err != nil {
@@ -283,7 +317,7 @@ func (c *client) CreateService(svc Service) error {
 	}
 	flags := netlink.Request | netlink.Acknowledge
 
-	r, err := c.c.Execute(msg, c.family.ID, flags)
+	r, err := c.execute(msg, flags)
 	if err != nil {
 		return err
 	}
@@ -299,7 +333,7 @@ func (c *client) CreateService(svc Service) error {
 // from IPVS.
 func (c *client) RemoveService(svc Service) error {
 	ae := netlink.NewAttributeEncoder()
-	ae.Do(cipvs.CmdAttrService, packService(svc))
+	ae.Do(cipvs.CmdAttrService, c.packService(svc))
 	b, err := ae.Encode()
 
 	if err != nil {
@@ -315,14 +349,14 @@ func (c *client) RemoveService(svc Service) error {
 	}
 	flags := netlink.Request | netlink.Acknowledge
 
-	_, err = c.c.Execute(msg, c.family.ID, flags)
+	_, err = c.execute(msg, flags)
 	return err
 }
 
 // UpdateService replaces the configuration of a Service.
 func (c *client) UpdateService(svc Service) error {
 	ae := netlink.NewAttributeEncoder()
-	ae.Do(cipvs.CmdAttrService, packService(svc))
+	ae.Do(cipvs.CmdAttrService, c.packService(svc))
 	b, err := ae.Encode()
 
 	if err != nil {
@@ -338,7 +372,7 @@ func (c *client) UpdateService(svc Service) error {
 	}
 	flags := netlink.Request | netlink.Acknowledge
 
-	r, err := c.c.Execute(msg, c.family.ID, flags)
+	r, err := c.execute(msg, flags)
 	if err != nil {
 		return err
 	}
@@ -353,7 +387,7 @@ func (c *client) UpdateService(svc Service) error {
 // Destinations returns the configured Destinations for a service.
 func (c *client) Destinations(svc Service) ([]DestinationExtended, error) {
 	ae := netlink.NewAttributeEncoder()
-	ae.Do(cipvs.CmdAttrService, packService(svc))
+	ae.Do(cipvs.CmdAttrService, c.packService(svc))
 	b, err := ae.Encode()
 
 	if err != nil {
@@ -369,7 +403,7 @@ func (c *client) Destinations(svc Service) ([]DestinationExtended, error) {
 	}
 	flags := netlink.Request | netlink.Dump
 
-	msgs, err := c.c.Execute(msg, c.family.ID, flags)
+	msgs, err := c.execute(msg, flags)
 	if err != nil {
 		return nil, err
 	}
@@ -378,6 +412,10 @@ func (c *client) Destinations(svc Service) ([]DestinationExtended, error) {
 		return nil, os.ErrNotExist
 	}
 
+	if resolved, err := svc.ResolveFamily(); err == nil {
+		svc = resolved
+	}
+
 	dests := make([]DestinationExtended, 0, len(msgs))
 	for _, msg := range msgs {
 		var dest DestinationExtended
@@ -410,8 +448,8 @@ func (c *client) Destinations(svc Service) ([]DestinationExtended, error) {
 // CreateDestination creates a Destination for the Service.
 func (c *client) CreateDestination(svc Service, dest Destination) error {
 	ae := netlink.NewAttributeEncoder()
-	ae.Do(cipvs.CmdAttrService, packService(svc))
-	ae.Do(cipvs.CmdAttrDest, packDest(dest))
+	ae.Do(cipvs.CmdAttrService, c.packService(svc))
+	ae.Do(cipvs.CmdAttrDest, c.packDest(svc, dest))
 	b, err := ae.Encode()
 
 	if err != nil {
@@ -427,7 +465,7 @@ func (c *client) CreateDestination(svc Service, dest Destination) error {
 	}
 	flags := netlink.Request | netlink.Acknowledge
 
-	r, err := c.c.Execute(msg, c.family.ID, flags)
+	r, err := c.execute(msg, flags)
 	if err != nil {
 		return err
 	}
@@ -442,8 +480,8 @@ func (c *client) CreateDestination(svc Service, dest Destination) error {
 // UpdateDestination replaces the configuration of a Destination.
 func (c *client) UpdateDestination(svc Service, dest Destination) error {
 	ae := netlink.NewAttributeEncoder()
-	ae.Do(cipvs.CmdAttrService, packService(svc))
-	ae.Do(cipvs.CmdAttrDest, packDest(dest))
+	ae.Do(cipvs.CmdAttrService, c.packService(svc))
+	ae.Do(cipvs.CmdAttrDest, c.packDest(svc, dest))
 	b, err := ae.Encode()
 
 	if err != nil {
@@ -459,7 +497,7 @@ func (c *client) UpdateDestination(svc Service, dest Destination) error {
 	}
 	flags := netlink.Request | netlink.Acknowledge
 
-	r, err := c.c.Execute(msg, c.family.ID, flags)
+	r, err := c.execute(msg, flags)
 	if err != nil {
 		return err
 	}
@@ -474,8 +512,8 @@ func (c *client) UpdateDestination(svc Service, dest Destination) error {
 // RemoveDestination removes the Destinaation from a Service.
 func (c *client) RemoveDestination(svc Service, dest Destination) error {
 	ae := netlink.NewAttributeEncoder()
-	ae.Do(cipvs.CmdAttrService, packService(svc))
-	ae.Do(cipvs.CmdAttrDest, packDest(dest))
+	ae.Do(cipvs.CmdAttrService, c.packService(svc))
+	ae.Do(cipvs.CmdAttrDest, c.packDest(svc, dest))
 	b, err := ae.Encode()
 
 	if err != nil {
@@ -491,7 +529,7 @@ func (c *client) RemoveDestination(svc Service, dest Destination) error {
 	}
 	flags := netlink.Request | netlink.Acknowledge
 
-	_, err = c.c.Execute(msg, c.family.ID, flags)
+	_, err = c.execute(msg, flags)
 	return err
 }
 
@@ -532,8 +570,10 @@ func unpackService(svc *ServiceExtended) func(b []byte) error {
 			case cipvs.SvcAttrNetmask:
 				mask = ad.Bytes()
 			case cipvs.SvcAttrStats:
+				svc.StatsAttrs |= HasStats
 				ad.Do(unpackStats(&svc.Stats))
 			case cipvs.SvcAttrStats64:
+				svc.StatsAttrs |= HasStats64
 				ad.Do(unpackStats64(&svc.Stats64))
 			}
 		}
@@ -573,9 +613,22 @@ func unpackService(svc *ServiceExtended) func(b []byte) error {
 	}
 }
 
-// packService encodes the service attributes
-func packService(svc Service) func() ([]byte, error) {
+// packService encodes the service attributes, inferring the address
+// family from the address if unset. Features the kernel is known not to
+// support are refused, as described by Capabilities.check.
+func (c *client) packService(svc Service) func() ([]byte, error) {
 	return func() ([]byte, error) {
+		svc, err := svc.ResolveFamily()
+		if err != nil {
+			return nil, err
+		}
+
+		if c.caps != nil {
+			if err := c.caps.check(svc, nil); err != nil {
+				return nil, err
+			}
+		}
+
 		flags := make([]byte, 4)
 		binary.NativeEndian.PutUint32(flags, uint32(svc.Flags))
 		flags = append(flags, []byte{0xFF, 0xFF, 0xFF, 0xFF}...)
@@ -646,8 +699,10 @@ func unpackDestination(dest *DestinationExtended) func(b []byte) error {
 			case cipvs.DestAttrTunFlags:
 				dest.TunnelFlags = TunnelFlags(ad.Uint16())
 			case cipvs.DestAttrStats:
+				dest.StatsAttrs |= HasStats
 				ad.Do(unpackStats(&dest.Stats))
 			case cipvs.DestAttrStats64:
+				dest.StatsAttrs |= HasStats64
 				ad.Do(unpackStats64(&dest.Stats64))
 			}
 		}
@@ -666,20 +721,49 @@ func unpackDestination(dest *DestinationExtended) func(b []byte) error {
 	}
 }
 
-// packDest encodes the destination attributes
-func packDest(dest Destination) func() ([]byte, error) {
+// packDest encodes the destination attributes of a Service, inferring
+// the address family from the address if unset. Features the kernel is
+// known not to support are refused, as described by Capabilities.check,
+// and the attributes it does not know are left out.
+func (c *client) packDest(svc Service, dest Destination) func() ([]byte, error) {
 	return func() ([]byte, error) {
+		svc, err := svc.ResolveFamily()
+		if err != nil {
+			return nil, err
+		}
+
+		dest, err := dest.ResolveFamily()
+		if err != nil {
+			return nil, err
+		}
+
+		// Without detected capabilities, every attribute is sent.
+		caps := Capabilities{MixedFamily: true, TunnelTypes: []TunnelType{GUE}, TunnelFlags: true}
+		if c.caps != nil {
+			caps = *c.caps
+			if err := caps.check(svc, &dest); err != nil {
+				return nil, err
+			}
+		}
+
 		ae := netlink.NewAttributeEncoder()
-		ae.Uint16(cipvs.DestAttrAddrFamily, uint16(dest.Family))
+		if caps.MixedFamily {
+			ae.Uint16(cipvs.DestAttrAddrFamily, uint16(dest.Family))
+		}
 		ae.Bytes(cipvs.DestAttrAddr, dest.Address.AsSlice())
 		ae.Do(cipvs.DestAttrPort, packPort(dest.Port))
 		ae.Uint32(cipvs.DestAttrFwdMethod, uint32(dest.FwdMethod))
 		ae.Uint32(cipvs.DestAttrWeight, dest.Weight)
 		ae.Uint32(cipvs.DestAttrUThresh, dest.UpperThreshold)
 		ae.Uint32(cipvs.DestAttrLThresh, dest.LowerThreshold)
-		ae.Uint8(cipvs.DestAttrTunType, uint8(dest.TunnelType))
-		ae.Do(cipvs.DestAttrTunPort, packPort(dest.TunnelPort))
-		ae.Uint16(cipvs.DestAttrTunFlags, uint16(dest.TunnelFlags))
+		// The tunnel type and port were added along with GUE.
+		if caps.SupportsTunnel(GUE) {
+			ae.Uint8(cipvs.DestAttrTunType, uint8(dest.TunnelType))
+			ae.Do(cipvs.DestAttrTunPort, packPort(dest.TunnelPort))
+		}
+		if caps.TunnelFlags {
+			ae.Uint16(cipvs.DestAttrTunFlags, uint16(dest.TunnelFlags))
+		}
 
 		return ae.Encode()
 	}
@@ -280,6 +280,108 @@ func TestServices(t *testing.T) {
 	}
 }
 
+func TestServices_Stats(t *testing.T) {
+	type testCase struct {
+		name      string
+		attrs     []netlink.Attribute
+		expected  StatsAttrs
+		counters  Stats
+		truncated bool
+	}
+
+	stats := nltest.MustMarshalAttributes([]netlink.Attribute{
+		{
+			Type: cipvs.StatsAttrConns,
+			Data: []byte{0x05, 0x00, 0x00, 0x00},
+		},
+		{
+			Type: cipvs.StatsAttrInbytes,
+			Data: []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+	})
+	stats64 := nltest.MustMarshalAttributes([]netlink.Attribute{
+		{
+			Type: cipvs.StatsAttrConns,
+			Data: []byte{0x05, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00},
+		},
+		{
+			Type: cipvs.StatsAttrInbytes,
+			Data: []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+	})
+
+	run := func(t *testing.T, tc testCase) {
+		fn := func(_ genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+			attrs := append([]netlink.Attribute{
+				{
+					Type: cipvs.SvcAttrAf,
+					Data: []byte{0x02, 0x00},
+				},
+				{
+					Type: cipvs.SvcAttrFwmark,
+					Data: []byte{0x01, 0x00, 0x00, 0x00},
+				},
+				{
+					Type: cipvs.SvcAttrFlags,
+					Data: []byte{0, 0, 0, 0, 0, 0, 0, 0},
+				},
+			}, tc.attrs...)
+
+			return []genetlink.Message{
+				{
+					Data: nltest.MustMarshalAttributes([]netlink.Attribute{
+						{
+							Type: cipvs.CmdAttrService,
+							Data: nltest.MustMarshalAttributes(attrs),
+						},
+					}),
+				},
+			}, nil
+		}
+		client := testClient(t, genltest.CheckRequest(familyID, cipvs.CmdGetService, netlink.Request|netlink.Dump, fn))
+
+		svcs, err := client.Services()
+		assert.NilError(t, err)
+		assert.Equal(t, len(svcs), 1)
+		assert.Equal(t, svcs[0].StatsAttrs, tc.expected)
+
+		counters, truncated := svcs[0].Counters()
+		assert.DeepEqual(t, counters, tc.counters)
+		assert.Equal(t, truncated, tc.truncated)
+	}
+
+	testCases := []testCase{
+		{
+			name:     "none",
+			expected: 0,
+		},
+		{
+			name: "legacy",
+			attrs: []netlink.Attribute{
+				{Type: cipvs.SvcAttrStats, Data: stats},
+			},
+			expected:  HasStats,
+			counters:  Stats{Connections: 5, IncomingBytes: 256},
+			truncated: true,
+		},
+		{
+			name: "both",
+			attrs: []netlink.Attribute{
+				{Type: cipvs.SvcAttrStats, Data: stats},
+				{Type: cipvs.SvcAttrStats64, Data: stats64},
+			},
+			expected: HasStats | HasStats64,
+			counters: Stats{Connections: 1<<32 + 5, IncomingBytes: 256},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
 func TestService_PackUnpack(t *testing.T) {
 	rapid.Check(t, func(t *rapid.T) {
 		svc := rapid.Custom[Service](func(t *rapid.T) Service {
@@ -309,7 +411,7 @@ func TestService_PackUnpack(t *testing.T) {
 		}).Draw(t, "svc")
 
 		ae := netlink.NewAttributeEncoder()
-		ae.Do(cipvs.CmdAttrService, packService(svc))
+		ae.Do(cipvs.CmdAttrService, (&client{}).packService(svc))
 		p, err := ae.Encode()
 
 		assert.NilError(t, err)
@@ -876,6 +978,90 @@ func testClient(t *testing.T, fn genltest.Func) *client {
 	return client
 }
 
-func NetipAddrCompare(x, y netip.Addr) bool {
-	return x == y
+func TestPack_FamilyInference(t *testing.T) {
+	type testCase struct {
+		name     string
+		svc      Service
+		dest     Destination
+		expected map[uint16][]byte
+		err      string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		b, err := (&client{}).packDest(tc.svc, tc.dest)()
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err)
+			return
+		}
+		assert.NilError(t, err)
+
+		attrs, err := netlink.UnmarshalAttributes(b)
+		assert.NilError(t, err)
+
+		got := make(map[uint16][]byte)
+		for _, a := range attrs {
+			if _, ok := tc.expected[a.Type]; ok {
+				got[a.Type] = a.Data
+			}
+		}
+		assert.DeepEqual(t, got, tc.expected)
+	}
+
+	svc := Service{
+		Address:  netip.MustParseAddr("192.0.2.1"),
+		Port:     80,
+		Protocol: TCP,
+	}
+
+	testCases := []testCase{
+		{
+			name: "inferred",
+			svc:  svc,
+			dest: Destination{Address: netip.MustParseAddr("192.0.2.10")},
+			expected: map[uint16][]byte{
+				cipvs.DestAttrAddrFamily: {0x02, 0x00},
+				cipvs.DestAttrAddr:       {192, 0, 2, 10},
+			},
+		},
+		{
+			name: "unmapped",
+			svc:  svc,
+			dest: Destination{Address: netip.MustParseAddr("::ffff:192.0.2.10")},
+			expected: map[uint16][]byte{
+				cipvs.DestAttrAddrFamily: {0x02, 0x00},
+				cipvs.DestAttrAddr:       {192, 0, 2, 10},
+			},
+		},
+		{
+			name: "mixed family tunnel",
+			svc:  svc,
+			dest: Destination{
+				Address:   netip.MustParseAddr("2001:db8::10"),
+				FwdMethod: Tunnel,
+				Family:    INET6,
+			},
+			expected: map[uint16][]byte{
+				cipvs.DestAttrAddrFamily: {0x0A, 0x00},
+				cipvs.DestAttrAddr:       netip.MustParseAddr("2001:db8::10").AsSlice(),
+			},
+		},
+		{
+			name: "contradictory destination",
+			svc:  svc,
+			dest: Destination{Address: netip.MustParseAddr("2001:db8::10"), Family: INET},
+			err:  "ipvs: Family: INET does not match the address 2001:db8::10",
+		},
+		{
+			name: "contradictory service",
+			svc:  Service{Address: netip.MustParseAddr("192.0.2.1"), Family: INET6},
+			dest: Destination{Address: netip.MustParseAddr("192.0.2.10")},
+			err:  "ipvs: Family: INET6 does not match the address 192.0.2.1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
 }
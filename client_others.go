@@ -23,6 +23,10 @@ func (c *client) Info() (Info, error) {
 	return Info{}, errUnimplemented
 }
 
+func (c *client) Capabilities() (Capabilities, error) {
+	return Capabilities{}, errUnimplemented
+}
+
 func (c *client) Config() (Config, error) {
 	return Config{}, errUnimplemented
 }
@@ -31,6 +35,14 @@ func (c *client) SetConfig(config Config) error {
 	return errUnimplemented
 }
 
+func (c *client) Sysctls() (Sysctls, error) {
+	return Sysctls{}, errUnimplemented
+}
+
+func (c *client) SetSysctls(Sysctls) error {
+	return errUnimplemented
+}
+
 func (c *client) Services() ([]ServiceExtended, error) {
 	return nil, errUnimplemented
 }
@@ -66,3 +78,15 @@ func (c *client) UpdateDestination(Service, Destination) error {
 func (c *client) RemoveDestination(Service, Destination) error {
 	return errUnimplemented
 }
+
+func (c *client) Daemons() ([]Daemon, error) {
+	return nil, errUnimplemented
+}
+
+func (c *client) CreateDaemon(Daemon) error {
+	return errUnimplemented
+}
+
+func (c *client) RemoveDaemon(Daemon) error {
+	return errUnimplemented
+}
@@ -0,0 +1,81 @@
+package ipvs
+
+import (
+	"net/netip"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCounters(t *testing.T) {
+	type testCase struct {
+		name      string
+		dest      DestinationExtended
+		expected  Stats
+		truncated bool
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		stats, truncated := tc.dest.Counters()
+		assert.DeepEqual(t, stats, tc.expected)
+		assert.Equal(t, truncated, tc.truncated)
+	}
+
+	testCases := []testCase{
+		{
+			name: "zero",
+		},
+		{
+			name: "stats64 reported",
+			dest: DestinationExtended{
+				Stats:      Stats{Connections: 1},
+				Stats64:    Stats{Connections: 1 << 33},
+				StatsAttrs: HasStats | HasStats64,
+			},
+			expected: Stats{Connections: 1 << 33},
+		},
+		{
+			name: "stats64 reported as zero",
+			dest: DestinationExtended{
+				Stats:      Stats{Connections: 1},
+				StatsAttrs: HasStats | HasStats64,
+			},
+			expected: Stats{},
+		},
+		{
+			name: "legacy only",
+			dest: DestinationExtended{
+				Stats:      Stats{Connections: 1},
+				StatsAttrs: HasStats,
+			},
+			expected:  Stats{Connections: 1},
+			truncated: true,
+		},
+		{
+			name: "unknown source with stats64",
+			dest: DestinationExtended{
+				Stats:   Stats{Connections: 1},
+				Stats64: Stats{Connections: 2},
+			},
+			expected: Stats{Connections: 2},
+		},
+		{
+			name: "unknown source with legacy stats",
+			dest: DestinationExtended{
+				Stats: Stats{Connections: 1},
+			},
+			expected:  Stats{Connections: 1},
+			truncated: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func NetipAddrCompare(x, y netip.Addr) bool {
+	return x == y
+}
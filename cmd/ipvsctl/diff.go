@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cloudflare/ipvs/drift"
+	"github.com/cloudflare/ipvs/snapshot"
+)
+
+// errDrift is returned by diff when the snapshots differ.
+var errDrift = errors.New("snapshots differ")
+
+// diff compares two saved snapshots, reporting how the second differs
+// from the first.
+func diff(e env, args []string) error {
+	fs := newFlagSet(e, "diff")
+	asJSON := fs.Bool("json", false, "print the differences as JSON")
+	minSeverity := drift.Info
+	fs.TextVar(&minSeverity, "min", drift.Info, "least severity reported: info, warning, or critical")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+
+	want, err := readSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	got, err := readSnapshot(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	r := drift.Compare(want, got).AtLeast(minSeverity)
+
+	if *asJSON {
+		enc := json.NewEncoder(e.stdout)
+		enc.SetIndent("", "  ")
+		if r == nil {
+			r = drift.Report{}
+		}
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	} else {
+		for _, d := range r {
+			fmt.Fprintf(e.stdout, "%-8s %s\n", d.Severity, d)
+		}
+	}
+
+	if len(r) > 0 {
+		return errDrift
+	}
+
+	return nil
+}
+
+// readSnapshot reads the snapshot file name.
+func readSnapshot(name string) (*snapshot.Snapshot, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := snapshot.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return s, nil
+}
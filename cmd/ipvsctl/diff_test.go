@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestDiff(t *testing.T) {
+	type testCase struct {
+		name     string
+		args     []string
+		err      error
+		expected string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		e, out := testEnv(nil)
+		err := dispatch(e, append([]string{"diff"}, tc.args...))
+		if tc.err != nil {
+			assert.ErrorIs(t, err, tc.err)
+		} else {
+			assert.NilError(t, err)
+		}
+		assert.Equal(t, out.String(), tc.expected)
+	}
+
+	testCases := []testCase{
+		{
+			name: "equal",
+			args: []string{"testdata/want.json", "testdata/want.json"},
+		},
+		{
+			name: "differ",
+			args: []string{"testdata/want.json", "testdata/got.json"},
+			err:  errDrift,
+			expected: "info     config: TCPTimeout is 60, want 900\n" +
+				"warning  tcp:192.0.2.1:80 198.51.100.2:8080: Weight is 0, want 10\n" +
+				"warning  fwm:42: unexpected\n",
+		},
+		{
+			name: "min",
+			args: []string{"-min", "critical", "testdata/want.json", "testdata/got.json"},
+		},
+		{
+			name: "json",
+			args: []string{"-json", "-min", "warning", "testdata/want.json", "testdata/got.json"},
+			err:  errDrift,
+			expected: `[
+  {
+    "kind": "destination-field",
+    "severity": "warning",
+    "service": "tcp:192.0.2.1:80",
+    "destination": "198.51.100.2:8080",
+    "field": "Weight",
+    "want": "10",
+    "got": "0"
+  },
+  {
+    "kind": "extra-service",
+    "severity": "warning",
+    "service": "fwm:42"
+  }
+]
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Command ipvsctl inspects IPVS.
+//
+// Usage:
+//
+//	ipvsctl <command> [arguments]
+//
+// Run "ipvsctl help" for the list of commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// env is the environment commands run in.
+type env struct {
+	stdout io.Writer
+	stderr io.Writer
+
+	// client returns the Client used by commands which query IPVS. The
+	// command closes it with closeClient when done.
+	client func() (ipvs.Client, error)
+}
+
+// closeClient closes c if it implements io.Closer, such as the netlink
+// Client returned by ipvs.New.
+func closeClient(c ipvs.Client) {
+	if closer, ok := c.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// command is an ipvsctl subcommand.
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(e env, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{
+			name:    "whereis",
+			args:    "[-conn path] [-proto tcp|udp|sctp] <client> <vip:port|service>",
+			summary: "show the destination a client is directed to",
+			run:     whereis,
+		},
+		{
+			name:    "diff",
+			args:    "[-json] [-min info|warning|critical] <want.json> <got.json>",
+			summary: "compare two snapshots",
+			run:     diff,
+		},
+		{
+			name:    "help",
+			summary: "show this help",
+			run:     help,
+		},
+	}
+}
+
+// errUsage is returned by commands called with invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	e := env{
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		client: ipvs.New,
+	}
+
+	if err := dispatch(e, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "ipvsctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// dispatch runs the command named by args[0].
+func dispatch(e env, args []string) error {
+	if len(args) == 0 {
+		help(e, nil)
+		return errUsage
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			err := cmd.run(e, args[1:])
+			if errors.Is(err, errUsage) {
+				fmt.Fprintf(e.stderr, "usage: ipvsctl %s %s\n", cmd.name, cmd.args)
+			}
+			return err
+		}
+	}
+
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// help lists the commands.
+func help(e env, _ []string) error {
+	fmt.Fprintln(e.stderr, "usage: ipvsctl <command> [arguments]")
+	fmt.Fprintln(e.stderr)
+	fmt.Fprintln(e.stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(e.stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+
+	return nil
+}
+
+// newFlagSet returns a FlagSet for the named command, which reports
+// errors to e.stderr.
+func newFlagSet(e env, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	return fs
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cloudflare/ipvs"
+	"gotest.tools/v3/assert"
+)
+
+// testEnv returns an env using c, and the buffer its output is written to.
+func testEnv(c ipvs.Client) (env, *bytes.Buffer) {
+	var out bytes.Buffer
+	return env{
+		stdout: &out,
+		stderr: &out,
+		client: func() (ipvs.Client, error) { return c, nil },
+	}, &out
+}
+
+func TestRun_Unknown(t *testing.T) {
+	e, _ := testEnv(nil)
+	assert.ErrorContains(t, dispatch(e, []string{"frobnicate"}), `unknown command "frobnicate"`)
+}
+
+func TestRun_Usage(t *testing.T) {
+	e, out := testEnv(nil)
+	assert.ErrorIs(t, dispatch(e, []string{"whereis", "192.0.2.1"}), errUsage)
+	assert.Assert(t, bytes.Contains(out.Bytes(), []byte("usage: ipvsctl whereis")))
+}
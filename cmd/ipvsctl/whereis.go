@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/conntable"
+)
+
+// whereis prints the destination a client is directed to by a service,
+// given as vip:port or in any form accepted by ipvs.ParseService.
+func whereis(e env, args []string) error {
+	fs := newFlagSet(e, "whereis")
+	conn := fs.String("conn", conntable.ConnPath, "path of the connection table")
+	proto := fs.String("proto", "tcp", "protocol of the service: tcp, udp, or sctp")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+
+	client, err := netip.ParseAddr(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// A bare vip:port is qualified by -proto; any other service, such as
+	// "udp://192.0.2.1:53" or "fwmark:100", is parsed as given.
+	vip := fs.Arg(1)
+	spec := vip
+	if _, err := netip.ParseAddrPort(vip); err == nil {
+		spec = *proto + "://" + vip
+	}
+
+	svc, err := ipvs.ParseService(spec)
+	if err != nil {
+		return err
+	}
+	if svc.FWMark == 0 {
+		svc.Address = svc.Address.Unmap()
+		svc.Family = 0
+	}
+
+	c, err := e.client()
+	if err != nil {
+		return err
+	}
+	defer closeClient(c)
+
+	se, err := c.Service(svc)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", vip, err)
+	}
+
+	f, err := os.Open(*conn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pin, err := conntable.WhereIs(conntable.NewScanner(f), se.Service, client)
+	if errors.Is(err, conntable.ErrNotPinned) {
+		fmt.Fprintf(e.stdout, "%s is not directed to a destination of %s\n", client, vip)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	kind := "connection"
+	if pin.Template {
+		kind = "persistence"
+	}
+	fmt.Fprintf(e.stdout, "%s -> %s (%s expires in %s)\n", client, pin.Destination, kind, pin.Remaining)
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/ipvstest"
+	"github.com/cloudflare/ipvs/netmask"
+	"gotest.tools/v3/assert"
+)
+
+func TestWhereIs(t *testing.T) {
+	type testCase struct {
+		name     string
+		args     []string
+		expected string
+	}
+
+	c := ipvstest.New()
+	assert.NilError(t, c.CreateService(ipvs.Service{
+		Address:   netip.MustParseAddr("192.0.2.1"),
+		Port:      80,
+		Family:    ipvs.INET,
+		Protocol:  ipvs.TCP,
+		Scheduler: "wlc",
+		Flags:     ipvs.ServicePersistent,
+		Timeout:   300,
+		Netmask:   netmask.MaskFrom(24, 32),
+	}))
+	assert.NilError(t, c.CreateService(ipvs.Service{
+		Address:   netip.MustParseAddr("192.0.2.2"),
+		Port:      53,
+		Family:    ipvs.INET,
+		Protocol:  ipvs.UDP,
+		Scheduler: "rr",
+	}))
+
+	run := func(t *testing.T, tc testCase) {
+		e, out := testEnv(c)
+		args := append([]string{"whereis", "-conn", "../../conntable/testdata/ip_vs_conn"}, tc.args...)
+		assert.NilError(t, dispatch(e, args))
+		assert.Equal(t, out.String(), tc.expected)
+	}
+
+	testCases := []testCase{
+		{
+			name:     "persistent",
+			args:     []string{"192.168.10.99", "192.0.2.1:80"},
+			expected: "192.168.10.99 -> 198.51.100.1:8080 (persistence expires in 4m59s)\n",
+		},
+		{
+			name:     "connection",
+			args:     []string{"-proto", "udp", "192.168.10.3", "192.0.2.2:53"},
+			expected: "192.168.10.3 -> 198.51.100.3:53 (connection expires in 3m3s)\n",
+		},
+		{
+			name:     "service",
+			args:     []string{"192.168.10.3", "udp://192.0.2.2:53"},
+			expected: "192.168.10.3 -> 198.51.100.3:53 (connection expires in 3m3s)\n",
+		},
+		{
+			name:     "not pinned",
+			args:     []string{"192.168.12.1", "192.0.2.1:80"},
+			expected: "192.168.12.1 is not directed to a destination of 192.0.2.1:80\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+// closingClient records whether it was closed.
+type closingClient struct {
+	ipvs.Client
+	closed bool
+}
+
+func (c *closingClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestWhereIs_Close(t *testing.T) {
+	c := &closingClient{Client: ipvstest.New()}
+	e, _ := testEnv(c)
+
+	err := dispatch(e, []string{"whereis", "192.168.10.3", "192.0.2.2:53"})
+	assert.ErrorContains(t, err, "service 192.0.2.2:53")
+	assert.Assert(t, c.closed)
+}
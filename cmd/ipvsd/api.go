@@ -0,0 +1,625 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/remote"
+	"github.com/cloudflare/ipvs/snapshot"
+)
+
+//go:embed openapi.json
+var openapi []byte
+
+// maxRequestSize limits the body of a request.
+const maxRequestSize = 16 << 20
+
+// role is what a client of the API is allowed to do.
+type role int
+
+const (
+	// roleNone may not use the API.
+	roleNone role = iota
+
+	// roleReader may read the table.
+	roleReader
+
+	// roleAdmin may also change it.
+	roleAdmin
+)
+
+// token is a bearer token, and the role it grants.
+type token struct {
+	value string
+	role  role
+}
+
+// etagMaxAge is how long the ETag of the table is cached for the ETag
+// header of reads, so changes made without the API are noticed. The cache
+// is only dropped by changes made through the API, so conditional requests
+// always compute the ETag afresh.
+const etagMaxAge = time.Second
+
+// api serves the REST API for a Client.
+//
+// Every response carries the ETag of the table, a digest of its
+// configuration (see snapshot.Digest). Changes may be made conditional
+// on the table being unchanged with If-Match.
+type api struct {
+	client ipvs.Client
+
+	// tokens are the bearer tokens accepted, and anonymous is the role of
+	// requests without one.
+	tokens    []token
+	anonymous role
+
+	// mu serializes changes, so the ETag checked by If-Match is not
+	// changed by another request before the change is applied.
+	mu sync.Mutex
+
+	// cache is the ETag returned to reads, which is dropped by changes.
+	cacheMu sync.Mutex
+	cache   string
+	cached  time.Time
+}
+
+// handler returns the routes of the API.
+func (a *api) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openapi)
+	})
+
+	a.read(mux, "GET /info", a.getInfo)
+	a.read(mux, "GET /capabilities", a.getCapabilities)
+	a.read(mux, "GET /config", a.getConfig)
+	a.write(mux, "PUT /config", a.putConfig)
+	a.read(mux, "GET /stats", a.getStats)
+	a.read(mux, "GET /snapshot", a.getSnapshot)
+	a.write(mux, "PUT /snapshot", a.putSnapshot)
+
+	a.read(mux, "GET /services", a.getServices)
+	a.write(mux, "POST /services", a.postService)
+	a.read(mux, "GET /services/{service}", a.getService)
+	a.write(mux, "PUT /services/{service}", a.putService)
+	a.write(mux, "DELETE /services/{service}", a.deleteService)
+
+	a.read(mux, "GET /services/{service}/destinations", a.getDestinations)
+	a.write(mux, "POST /services/{service}/destinations", a.postDestination)
+	a.read(mux, "GET /services/{service}/destinations/{destination}", a.getDestination)
+	a.write(mux, "PUT /services/{service}/destinations/{destination}", a.putDestination)
+	a.write(mux, "DELETE /services/{service}/destinations/{destination}", a.deleteDestination)
+
+	return mux
+}
+
+// A handlerFunc handles a request, returning the value of the response,
+// which is nil for changes, and its status.
+type handlerFunc func(r *http.Request) (v any, status int, err error)
+
+// read registers h for readers, answering If-None-Match with the ETag of the table.
+func (a *api) read(mux *http.ServeMux, pattern string, h handlerFunc) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorize(w, r, roleReader) {
+			return
+		}
+
+		etag, err := a.cachedETag()
+		if r.Header.Get("If-None-Match") != "" {
+			etag, err = a.refreshETag()
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("ETag", etag)
+
+		if matchETag(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		v, status, err := h(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, status, v)
+	})
+}
+
+// write registers h for admins, checking If-Match against the ETag of the
+// table before applying the change. The ETag of the changed table is returned.
+func (a *api) write(mux *http.ServeMux, pattern string, h handlerFunc) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorize(w, r, roleAdmin) {
+			return
+		}
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+			etag, err := a.etag()
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if !matchETag(ifMatch, etag) {
+				w.Header().Set("ETag", etag)
+				writeError(w, &apiError{http.StatusPreconditionFailed, "the table has changed"})
+				return
+			}
+		}
+
+		v, status, err := h(r)
+		a.invalidate()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if etag, err := a.cachedETag(); err == nil {
+			w.Header().Set("ETag", etag)
+		}
+
+		if v == nil {
+			w.WriteHeader(status)
+			return
+		}
+		writeJSON(w, status, v)
+	})
+}
+
+// authorize reports whether r is allowed the role want, writing an error if not.
+func (a *api) authorize(w http.ResponseWriter, r *http.Request, want role) bool {
+	got := a.anonymous
+	if value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		got = roleNone
+		for _, t := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(value), []byte(t.value)) == 1 {
+				got = max(got, t.role)
+			}
+		}
+	}
+
+	switch {
+	case got == roleNone:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ipvsd"`)
+		writeError(w, &apiError{http.StatusUnauthorized, "a valid bearer token is required"})
+		return false
+	case got < want:
+		writeError(w, &apiError{http.StatusForbidden, "the token does not allow changes"})
+		return false
+	}
+
+	return true
+}
+
+// cachedETag returns the ETag of the table, computing it if the table was
+// changed since it was last computed, or it is older than etagMaxAge.
+func (a *api) cachedETag() (string, error) {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+
+	if a.cache != "" && time.Since(a.cached) < etagMaxAge {
+		return a.cache, nil
+	}
+
+	etag, err := a.etag()
+	if err != nil {
+		return "", err
+	}
+
+	a.cache, a.cached = etag, time.Now()
+	return etag, nil
+}
+
+// refreshETag computes the ETag of the table, replacing the cached one.
+func (a *api) refreshETag() (string, error) {
+	etag, err := a.etag()
+	if err != nil {
+		return "", err
+	}
+
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+
+	a.cache, a.cached = etag, time.Now()
+	return etag, nil
+}
+
+// invalidate drops the cached ETag after a change.
+func (a *api) invalidate() {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+
+	a.cache = ""
+}
+
+// etag returns the ETag of the current table.
+func (a *api) etag() (string, error) {
+	s, err := snapshot.Take(a.client)
+	if err != nil {
+		return "", err
+	}
+
+	return `"` + s.Digest()[:32] + `"`, nil
+}
+
+// matchETag reports whether the If-Match or If-None-Match header h lists etag.
+func matchETag(h, etag string) bool {
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *api) getInfo(r *http.Request) (any, int, error) {
+	info, err := a.client.Info()
+	return info, http.StatusOK, err
+}
+
+func (a *api) getCapabilities(r *http.Request) (any, int, error) {
+	cc, ok := a.client.(ipvs.CapabilitiesClient)
+	if !ok {
+		return nil, 0, errors.ErrUnsupported
+	}
+
+	caps, err := cc.Capabilities()
+	return caps, http.StatusOK, err
+}
+
+func (a *api) getConfig(r *http.Request) (any, int, error) {
+	config, err := a.client.Config()
+	return config, http.StatusOK, err
+}
+
+func (a *api) putConfig(r *http.Request) (any, int, error) {
+	config, err := a.client.Config()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := decode(r, &config); err != nil {
+		return nil, 0, err
+	}
+
+	if err := a.client.SetConfig(config); err != nil {
+		return nil, 0, err
+	}
+
+	return config, http.StatusOK, nil
+}
+
+func (a *api) getStats(r *http.Request) (any, int, error) {
+	stats, err := snapshot.TakeStats(a.client)
+	return stats, http.StatusOK, err
+}
+
+func (a *api) getSnapshot(r *http.Request) (any, int, error) {
+	s, err := snapshot.Take(a.client)
+	return s, http.StatusOK, err
+}
+
+func (a *api) putSnapshot(r *http.Request) (any, int, error) {
+	s, err := snapshot.Decode(http.MaxBytesReader(nil, r.Body, maxRequestSize))
+	if err != nil {
+		return nil, 0, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	if err := snapshot.Restore(a.client, s); err != nil {
+		return nil, 0, err
+	}
+
+	return nil, http.StatusNoContent, nil
+}
+
+func (a *api) getServices(r *http.Request) (any, int, error) {
+	services, err := a.services()
+	return services, http.StatusOK, err
+}
+
+func (a *api) postService(r *http.Request) (any, int, error) {
+	var se ipvs.ServiceExtended
+	if err := decode(r, &se); err != nil {
+		return nil, 0, err
+	}
+	svc := se.Service
+
+	if err := a.client.CreateService(svc); err != nil {
+		return nil, 0, err
+	}
+
+	se, err := a.client.Service(svc)
+	return se, http.StatusCreated, err
+}
+
+func (a *api) getService(r *http.Request) (any, int, error) {
+	key, err := serviceKey(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	se, err := a.client.Service(key.Service())
+	return se, http.StatusOK, err
+}
+
+func (a *api) putService(r *http.Request) (any, int, error) {
+	key, err := serviceKey(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	se, err := a.client.Service(key.Service())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := decode(r, &se); err != nil {
+		return nil, 0, err
+	}
+	svc := se.Service
+	svc.Flags &^= ipvs.ServiceHashed
+	if svc.Key() != key {
+		return nil, 0, &apiError{http.StatusBadRequest, fmt.Sprintf("service %s does not match %s", svc.Key(), key)}
+	}
+
+	if err := a.client.UpdateService(svc); err != nil {
+		return nil, 0, err
+	}
+
+	se, err = a.client.Service(svc)
+	return se, http.StatusOK, err
+}
+
+func (a *api) deleteService(r *http.Request) (any, int, error) {
+	key, err := serviceKey(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return nil, http.StatusNoContent, a.client.RemoveService(key.Service())
+}
+
+func (a *api) getDestinations(r *http.Request) (any, int, error) {
+	svc, err := a.service(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dests, err := a.destinations(svc)
+	return dests, http.StatusOK, err
+}
+
+func (a *api) postDestination(r *http.Request) (any, int, error) {
+	svc, err := a.service(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var de ipvs.DestinationExtended
+	if err := decode(r, &de); err != nil {
+		return nil, 0, err
+	}
+	dest := de.Destination
+
+	if err := a.client.CreateDestination(svc, dest); err != nil {
+		return nil, 0, err
+	}
+
+	de, err = a.destination(svc, dest.Key())
+	return de, http.StatusCreated, err
+}
+
+func (a *api) getDestination(r *http.Request) (any, int, error) {
+	svc, err := a.service(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	key, err := destinationKey(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	de, err := a.destination(svc, key)
+	return de, http.StatusOK, err
+}
+
+func (a *api) putDestination(r *http.Request) (any, int, error) {
+	svc, err := a.service(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	key, err := destinationKey(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	de, err := a.destination(svc, key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := decode(r, &de); err != nil {
+		return nil, 0, err
+	}
+	dest := de.Destination
+	if dest.Key() != key {
+		return nil, 0, &apiError{http.StatusBadRequest, fmt.Sprintf("destination %s does not match %s", dest.Key(), key)}
+	}
+
+	if err := a.client.UpdateDestination(svc, dest); err != nil {
+		return nil, 0, err
+	}
+
+	de, err = a.destination(svc, key)
+	return de, http.StatusOK, err
+}
+
+func (a *api) deleteDestination(r *http.Request) (any, int, error) {
+	svc, err := a.service(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	key, err := destinationKey(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return nil, http.StatusNoContent, a.client.RemoveDestination(svc, key.Destination())
+}
+
+// services returns every Service, which is empty rather than an error for
+// an empty table.
+func (a *api) services() ([]ipvs.ServiceExtended, error) {
+	services, err := a.client.Services()
+	if errors.Is(err, fs.ErrNotExist) {
+		return []ipvs.ServiceExtended{}, nil
+	}
+
+	return services, err
+}
+
+// service returns the Service named in the path of r.
+func (a *api) service(r *http.Request) (ipvs.Service, error) {
+	key, err := serviceKey(r)
+	if err != nil {
+		return ipvs.Service{}, err
+	}
+
+	se, err := a.client.Service(key.Service())
+	return se.Service, err
+}
+
+// destinations returns the Destinations of svc, which is empty rather than
+// an error for a Service without Destinations.
+func (a *api) destinations(svc ipvs.Service) ([]ipvs.DestinationExtended, error) {
+	dests, err := a.client.Destinations(svc)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []ipvs.DestinationExtended{}, nil
+	}
+
+	return dests, err
+}
+
+// destination returns the Destination of svc identified by key.
+func (a *api) destination(svc ipvs.Service, key ipvs.DestinationKey) (ipvs.DestinationExtended, error) {
+	dests, err := a.destinations(svc)
+	if err != nil {
+		return ipvs.DestinationExtended{}, err
+	}
+
+	for _, de := range dests {
+		if de.Key() == key {
+			return de, nil
+		}
+	}
+
+	return ipvs.DestinationExtended{}, fs.ErrNotExist
+}
+
+// serviceKey parses the key of the Service in the path of r, such as
+// "tcp:192.0.2.1:80". The "/" of IPv6 firewall-mark keys is escaped as %2F.
+func serviceKey(r *http.Request) (ipvs.ServiceKey, error) {
+	key, err := ipvs.ParseServiceKey(r.PathValue("service"))
+	if err != nil {
+		return ipvs.ServiceKey{}, &apiError{http.StatusNotFound, err.Error()}
+	}
+
+	return key, nil
+}
+
+// destinationKey parses the key of the Destination in the path of r, such
+// as "198.51.100.1:8080".
+func destinationKey(r *http.Request) (ipvs.DestinationKey, error) {
+	key, err := ipvs.ParseDestinationKey(r.PathValue("destination"))
+	if err != nil {
+		return ipvs.DestinationKey{}, &apiError{http.StatusNotFound, err.Error()}
+	}
+
+	return key, nil
+}
+
+// decode decodes the JSON body of r into v, keeping the fields of v which
+// are not in the body. Unknown fields are rejected, so services and
+// destinations are decoded into their Extended types: the statistics and
+// connection counts of a document returned by GET are then accepted, and
+// dropped as they cannot be set.
+func decode(r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestSize))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return &apiError{http.StatusBadRequest, "invalid request: " + err.Error()}
+	}
+
+	return nil
+}
+
+// apiError is an error with the status of its response.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// errorBody is the body of error responses.
+type errorBody struct {
+	Error  string       `json:"error"`
+	Fields []fieldError `json:"fields,omitempty"`
+}
+
+// fieldError describes an invalid field, from an *ipvs.FieldError.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// writeError writes err, with a status derived from its type.
+func writeError(w http.ResponseWriter, err error) {
+	body := errorBody{Error: err.Error()}
+	status := remote.HTTPStatus(err)
+
+	var ae *apiError
+	if errors.As(err, &ae) {
+		status = ae.status
+	}
+
+	for _, fe := range ipvs.FieldErrors(err) {
+		body.Fields = append(body.Fields, fieldError{Field: fe.Field, Message: fe.Err.Error()})
+	}
+
+	writeJSON(w, status, body)
+}
+
+// writeJSON writes v as the body of the response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/ipvstest"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+)
+
+// testServer serves the API for a new ipvstest.Client. Without tokens,
+// every client is an admin.
+func testServer(t *testing.T, tokens ...token) (*httptest.Server, *ipvstest.Client) {
+	c := ipvstest.New()
+	a := &api{client: ipvs.Validating(c), tokens: tokens}
+	if len(tokens) == 0 {
+		a.anonymous = roleAdmin
+	}
+	srv := httptest.NewServer(a.handler())
+	t.Cleanup(srv.Close)
+
+	return srv, c
+}
+
+// do makes a request, returning the response and its body.
+func do(t *testing.T, srv *httptest.Server, method, path, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	assert.NilError(t, err)
+	for i := 0; i < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	resp, err := srv.Client().Do(req)
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	assert.NilError(t, err)
+
+	return resp, string(b)
+}
+
+func TestAPI_Services(t *testing.T) {
+	srv, c := testServer(t)
+
+	resp, body := do(t, srv, "GET", "/services", "")
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.Equal(t, strings.TrimSpace(body), "[]")
+
+	resp, _ = do(t, srv, "POST", "/services", `{"address": "192.0.2.1", "port": 80, "protocol": "TCP", "scheduler": "wrr"}`)
+	assert.Equal(t, resp.StatusCode, http.StatusCreated)
+
+	svc := ipvs.Service{Address: netip.MustParseAddr("192.0.2.1"), Port: 80, Protocol: ipvs.TCP}
+	se, err := c.Service(svc)
+	assert.NilError(t, err)
+	assert.Equal(t, se.Scheduler, ipvs.WeightedRoundRobin)
+
+	resp, _ = do(t, srv, "POST", "/services", `{"address": "192.0.2.1", "port": 80, "protocol": "TCP", "scheduler": "wrr"}`)
+	assert.Equal(t, resp.StatusCode, http.StatusConflict)
+
+	resp, _ = do(t, srv, "PUT", "/services/tcp:192.0.2.1:80", `{"scheduler": "lc"}`)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	se, err = c.Service(svc)
+	assert.NilError(t, err)
+	assert.Equal(t, se.Scheduler, ipvs.LeastConnection)
+
+	resp, _ = do(t, srv, "PUT", "/services/tcp:192.0.2.1:80", `{"port": 81}`)
+	assert.Equal(t, resp.StatusCode, http.StatusBadRequest)
+
+	resp, _ = do(t, srv, "POST", "/services/tcp:192.0.2.1:80/destinations", `{"address": "198.51.100.1", "port": 8080, "weight": 10}`)
+	assert.Equal(t, resp.StatusCode, http.StatusCreated)
+
+	resp, body = do(t, srv, "PUT", "/services/tcp:192.0.2.1:80/destinations/198.51.100.1:8080", `{"weight": 20}`)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+
+	var de ipvs.DestinationExtended
+	assert.NilError(t, json.Unmarshal([]byte(body), &de))
+	assert.Equal(t, de.Weight, uint32(20))
+	assert.Equal(t, de.Port, uint16(8080))
+
+	resp, _ = do(t, srv, "GET", "/services/tcp:192.0.2.1:80/destinations/198.51.100.2:8080", "")
+	assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+
+	resp, _ = do(t, srv, "DELETE", "/services/tcp:192.0.2.1:80/destinations/198.51.100.1:8080", "")
+	assert.Equal(t, resp.StatusCode, http.StatusNoContent)
+
+	resp, _ = do(t, srv, "DELETE", "/services/tcp:192.0.2.1:80", "")
+	assert.Equal(t, resp.StatusCode, http.StatusNoContent)
+
+	resp, _ = do(t, srv, "GET", "/services/tcp:192.0.2.1:80", "")
+	assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+}
+
+func TestAPI_PutBack(t *testing.T) {
+	srv, c := testServer(t)
+
+	resp, _ := do(t, srv, "POST", "/services", `{"address": "192.0.2.1", "port": 80, "protocol": "TCP", "scheduler": "wrr"}`)
+	assert.Equal(t, resp.StatusCode, http.StatusCreated)
+	resp, _ = do(t, srv, "POST", "/services/tcp:192.0.2.1:80/destinations", `{"address": "198.51.100.1", "port": 8080, "weight": 10}`)
+	assert.Equal(t, resp.StatusCode, http.StatusCreated)
+
+	// The read-only fields of a document returned by GET are accepted,
+	// and dropped.
+	resp, body := do(t, srv, "GET", "/services/tcp:192.0.2.1:80", "")
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	var doc map[string]any
+	assert.NilError(t, json.Unmarshal([]byte(body), &doc))
+	doc["scheduler"] = "lc"
+	doc["stats"] = map[string]any{"connections": 5}
+	doc["statsAttrs"] = int(ipvs.HasStats64)
+	b, err := json.Marshal(doc)
+	assert.NilError(t, err)
+
+	resp, _ = do(t, srv, "PUT", "/services/tcp:192.0.2.1:80", string(b))
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	se, err := c.Service(ipvs.Service{Address: netip.MustParseAddr("192.0.2.1"), Port: 80, Protocol: ipvs.TCP})
+	assert.NilError(t, err)
+	assert.Equal(t, se.Scheduler, ipvs.LeastConnection)
+
+	resp, _ = do(t, srv, "PUT", "/services/tcp:192.0.2.1:80/destinations/198.51.100.1:8080",
+		`{"address": "198.51.100.1", "port": 8080, "weight": 20, "activeConnections": 3, "stats": {"connections": 5}}`)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	dests, err := c.Destinations(se.Service)
+	assert.NilError(t, err)
+	assert.Equal(t, dests[0].Weight, uint32(20))
+	assert.Equal(t, dests[0].ActiveConnections, uint32(0))
+}
+
+func TestAPI_FWMark(t *testing.T) {
+	srv, _ := testServer(t)
+
+	resp, _ := do(t, srv, "POST", "/services", `{"fwmark": 42, "family": "INET6", "scheduler": "rr"}`)
+	assert.Equal(t, resp.StatusCode, http.StatusCreated)
+
+	resp, body := do(t, srv, "GET", "/services/fwm:42%2Finet6", "")
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.Assert(t, strings.Contains(body, `"fwmark": 42`))
+}
+
+func TestAPI_Invalid(t *testing.T) {
+	srv, _ := testServer(t)
+
+	resp, body := do(t, srv, "POST", "/services", `{"address": "192.0.2.1", "protocol": "TCP", "scheduler": "rr"}`)
+	assert.Equal(t, resp.StatusCode, http.StatusUnprocessableEntity)
+
+	var e errorBody
+	assert.NilError(t, json.Unmarshal([]byte(body), &e))
+	assert.DeepEqual(t, e.Fields, []fieldError{{Field: "Port", Message: "zero port requires a persistent service"}})
+
+	resp, _ = do(t, srv, "POST", "/services", `{"address": "192.0.2.1", "colour": "blue"}`)
+	assert.Equal(t, resp.StatusCode, http.StatusBadRequest)
+
+	resp, _ = do(t, srv, "GET", "/services/http:192.0.2.1:80", "")
+	assert.Equal(t, resp.StatusCode, http.StatusNotFound)
+}
+
+func TestAPI_ETag(t *testing.T) {
+	srv, c := testServer(t)
+
+	resp, _ := do(t, srv, "GET", "/config", "")
+	etag := resp.Header.Get("ETag")
+	assert.Assert(t, etag != "")
+
+	resp, _ = do(t, srv, "GET", "/info", "", "If-None-Match", etag)
+	assert.Equal(t, resp.StatusCode, http.StatusNotModified)
+
+	resp, _ = do(t, srv, "PUT", "/config", `{"tcpTimeout": 900}`, "If-Match", etag)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	changed := resp.Header.Get("ETag")
+	assert.Assert(t, changed != etag)
+
+	config, err := c.Config()
+	assert.NilError(t, err)
+	assert.Equal(t, config.TCPTimeout, uint32(900))
+
+	resp, _ = do(t, srv, "PUT", "/config", `{"tcpTimeout": 60}`, "If-Match", etag)
+	assert.Equal(t, resp.StatusCode, http.StatusPreconditionFailed)
+	assert.Equal(t, resp.Header.Get("ETag"), changed)
+
+	config, err = c.Config()
+	assert.NilError(t, err)
+	assert.Equal(t, config.TCPTimeout, uint32(900))
+}
+
+// countingClient counts the dumps of its Services.
+type countingClient struct {
+	ipvs.Client
+	services atomic.Int32
+}
+
+func (c *countingClient) Services() ([]ipvs.ServiceExtended, error) {
+	c.services.Add(1)
+	return c.Client.Services()
+}
+
+func TestAPI_ETagCache(t *testing.T) {
+	c := &countingClient{Client: ipvstest.New()}
+	srv := httptest.NewServer((&api{client: c, anonymous: roleAdmin}).handler())
+	defer srv.Close()
+
+	resp, _ := do(t, srv, "GET", "/config", "")
+	etag := resp.Header.Get("ETag")
+	resp, _ = do(t, srv, "GET", "/info", "")
+	assert.Equal(t, resp.Header.Get("ETag"), etag)
+	assert.Equal(t, c.services.Load(), int32(1))
+
+	resp, _ = do(t, srv, "POST", "/services", `{"address": "192.0.2.1", "port": 80, "protocol": "TCP", "scheduler": "rr"}`)
+	assert.Equal(t, resp.StatusCode, http.StatusCreated)
+	changed := resp.Header.Get("ETag")
+	assert.Assert(t, changed != etag)
+
+	resp, _ = do(t, srv, "GET", "/config", "")
+	assert.Equal(t, resp.Header.Get("ETag"), changed)
+	assert.Equal(t, c.services.Load(), int32(2))
+
+	// Changes made without the API are not seen by the cache, but are by
+	// conditional requests.
+	svc := ipvs.Service{Address: netip.MustParseAddr("192.0.2.2"), Port: 80, Protocol: ipvs.TCP, Scheduler: "rr"}
+	assert.NilError(t, c.CreateService(svc))
+
+	resp, _ = do(t, srv, "GET", "/config", "")
+	assert.Equal(t, resp.Header.Get("ETag"), changed)
+
+	resp, _ = do(t, srv, "GET", "/config", "", "If-None-Match", changed)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	external := resp.Header.Get("ETag")
+	assert.Assert(t, external != changed)
+
+	resp, _ = do(t, srv, "PUT", "/config", `{"tcpTimeout": 60}`, "If-Match", changed)
+	assert.Equal(t, resp.StatusCode, http.StatusPreconditionFailed)
+	assert.Equal(t, resp.Header.Get("ETag"), external)
+}
+
+func TestAPI_ReadOnly(t *testing.T) {
+	srv := httptest.NewServer((&api{client: ipvstest.New(), anonymous: roleReader}).handler())
+	defer srv.Close()
+
+	resp, _ := do(t, srv, "GET", "/config", "")
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+
+	resp, _ = do(t, srv, "PUT", "/config", `{"tcpTimeout": 900}`)
+	assert.Equal(t, resp.StatusCode, http.StatusForbidden)
+}
+
+func TestAPI_Roles(t *testing.T) {
+	srv, _ := testServer(t, token{"reader", roleReader}, token{"admin", roleAdmin})
+
+	type testCase struct {
+		name   string
+		method string
+		token  string
+		status int
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		var header []string
+		if tc.token != "" {
+			header = []string{"Authorization", "Bearer " + tc.token}
+		}
+
+		resp, _ := do(t, srv, tc.method, "/config", `{}`, header...)
+		assert.Equal(t, resp.StatusCode, tc.status)
+	}
+
+	testCases := []testCase{
+		{name: "anonymous read", method: "GET", status: http.StatusUnauthorized},
+		{name: "unknown read", method: "GET", token: "nobody", status: http.StatusUnauthorized},
+		{name: "reader read", method: "GET", token: "reader", status: http.StatusOK},
+		{name: "reader write", method: "PUT", token: "reader", status: http.StatusForbidden},
+		{name: "admin read", method: "GET", token: "admin", status: http.StatusOK},
+		{name: "admin write", method: "PUT", token: "admin", status: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestAPI_Snapshot(t *testing.T) {
+	srv, c := testServer(t)
+
+	doc := `{
+		"version": 1,
+		"services": [
+			{
+				"address": "192.0.2.1", "port": 80, "protocol": "TCP", "scheduler": "wrr",
+				"destinations": [{"address": "198.51.100.1", "port": 8080, "weight": 10}]
+			}
+		]
+	}`
+	resp, _ := do(t, srv, "PUT", "/snapshot", doc)
+	assert.Equal(t, resp.StatusCode, http.StatusNoContent)
+
+	dests, err := c.Destinations(ipvs.Service{Address: netip.MustParseAddr("192.0.2.1"), Port: 80, Protocol: ipvs.TCP})
+	assert.NilError(t, err)
+	assert.Equal(t, len(dests), 1)
+
+	resp, body := do(t, srv, "GET", "/snapshot", "")
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.Assert(t, strings.Contains(body, `"weight": 10`))
+
+	resp, body = do(t, srv, "GET", "/stats", "")
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.Assert(t, strings.Contains(body, `"destination": "198.51.100.1:8080"`))
+
+	resp, _ = do(t, srv, "PUT", "/snapshot", `{"services": []}`)
+	assert.Equal(t, resp.StatusCode, http.StatusBadRequest)
+}
+
+func TestOpenAPI(t *testing.T) {
+	srv, _ := testServer(t)
+
+	resp, body := do(t, srv, "GET", "/openapi.json", "")
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+
+	var doc struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+	assert.NilError(t, json.Unmarshal([]byte(body), &doc))
+
+	// Every route is documented.
+	for _, route := range []string{
+		"GET /info", "GET /capabilities", "GET /config", "PUT /config", "GET /stats",
+		"GET /snapshot", "PUT /snapshot",
+		"GET /services", "POST /services",
+		"GET /services/{service}", "PUT /services/{service}", "DELETE /services/{service}",
+		"GET /services/{service}/destinations", "POST /services/{service}/destinations",
+		"GET /services/{service}/destinations/{destination}",
+		"PUT /services/{service}/destinations/{destination}",
+		"DELETE /services/{service}/destinations/{destination}",
+	} {
+		method, path, _ := strings.Cut(route, " ")
+		_, ok := doc.Paths[path][strings.ToLower(method)]
+		assert.Assert(t, ok, route)
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	tokens, err := parseTokens(strings.NewReader("# comment\nread abc\n\nadmin def\n"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, tokens, []token{{"abc", roleReader}, {"def", roleAdmin}}, cmp.AllowUnexported(token{}))
+
+	_, err = parseTokens(strings.NewReader("root abc\n"))
+	assert.ErrorContains(t, err, `tokens:1: unknown role "root"`)
+
+	_, err = parseTokens(strings.NewReader("admin\n"))
+	assert.ErrorContains(t, err, "tokens:1: expected a role and a token")
+}
@@ -0,0 +1,161 @@
+// Command ipvsd serves a REST API for managing IPVS, so that tools not
+// written in Go can program it.
+//
+// Usage:
+//
+//	ipvsd [-listen addr] [-tokens path] [-tls-cert path -tls-key path [-client-ca path]]
+//
+// The API is described by /openapi.json. Resources are JSON documents:
+//
+//	/info, /capabilities, /config, /stats
+//	/services
+//	/services/{service}, such as /services/tcp:192.0.2.1:80
+//	/services/{service}/destinations
+//	/services/{service}/destinations/{destination}, such as .../198.51.100.1:8080
+//	/snapshot, the whole configuration, which PUT restores
+//
+// Every response carries an ETag, a digest of the configuration of the
+// table. Changes sent with If-Match are refused with 412 Precondition
+// Failed if the table has changed since.
+//
+// The tokens file lists the bearer tokens accepted, one per line, each
+// preceded by its role: "read" tokens may only read, and "admin" tokens
+// may also make changes. Blank lines and lines starting with "#" are
+// ignored. Without a tokens file, every client may read, and none may
+// make changes.
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "ipvsd: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run starts the daemon with the command-line arguments args.
+func run(args []string) error {
+	fs := flag.NewFlagSet("ipvsd", flag.ContinueOnError)
+	listen := fs.String("listen", "localhost:9080", "address to listen on")
+	tokens := fs.String("tokens", "", "path of the file of bearer tokens")
+	cert := fs.String("tls-cert", "", "path of the TLS certificate")
+	key := fs.String("tls-key", "", "path of the TLS key")
+	clientCA := fs.String("client-ca", "", "path of the CA certificates which issue client certificates")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || (*cert == "") != (*key == "") || (*clientCA != "" && *cert == "") {
+		fs.Usage()
+		return errors.New("invalid arguments")
+	}
+
+	c, err := ipvs.New()
+	if err != nil {
+		return err
+	}
+
+	a := &api{client: ipvs.Validating(c), anonymous: roleReader}
+	if *tokens != "" {
+		if a.tokens, err = readTokens(*tokens); err != nil {
+			return err
+		}
+		a.anonymous = roleNone
+	} else {
+		log.Printf("ipvsd: no tokens file, serving read-only")
+	}
+
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           a.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if *cert == "" {
+		log.Printf("ipvsd: listening on http://%s", *listen)
+		return srv.ListenAndServe()
+	}
+
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if *clientCA != "" {
+		pem, err := os.ReadFile(*clientCA)
+		if err != nil {
+			return err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", *clientCA)
+		}
+		srv.TLSConfig.ClientCAs = pool
+		srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	log.Printf("ipvsd: listening on https://%s", *listen)
+	return srv.ListenAndServeTLS(*cert, *key)
+}
+
+// readTokens reads the tokens file name.
+func readTokens(name string) ([]token, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseTokens(f)
+}
+
+// parseTokens parses a tokens file.
+func parseTokens(r io.Reader) ([]token, error) {
+	var tokens []token
+
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("tokens:%d: expected a role and a token", line)
+		}
+
+		t := token{value: fields[1]}
+		switch fields[0] {
+		case "read":
+			t.role = roleReader
+		case "admin":
+			t.role = roleAdmin
+		default:
+			return nil, fmt.Errorf("tokens:%d: unknown role %q", line, fields[0])
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("tokens: no tokens found")
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,147 @@
+package conntable
+
+import (
+	"net/netip"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// Counts tallies connection table entries.
+type Counts struct {
+	// Connections is the number of connections, excluding templates.
+	Connections int
+
+	// States counts connections by protocol state,
+	// such as "ESTABLISHED" or "SYN_RECV".
+	States map[string]int
+
+	// Templates is the number of persistence templates.
+	Templates int
+}
+
+// add counts e.
+func (c *Counts) add(e Entry) {
+	if e.Template() {
+		c.Templates++
+		return
+	}
+
+	if c.States == nil {
+		c.States = make(map[string]int)
+	}
+	c.Connections++
+	c.States[e.State]++
+}
+
+// ServiceCounts tallies the entries of a Service, and of each of its Destinations.
+type ServiceCounts struct {
+	Service ipvs.Service
+	Counts
+
+	// Destinations in the order they were first seen.
+	Destinations []DestinationCounts
+}
+
+// DestinationCounts tallies the entries of a Destination.
+type DestinationCounts struct {
+	Destination netip.AddrPort
+	Counts
+}
+
+// Aggregator tallies entries of the connection table by Service and Destination.
+//
+// As with ForService, only the persistence templates of firewall-mark
+// services can be attributed to them.
+type Aggregator struct {
+	results []ServiceCounts
+	dests   []map[netip.AddrPort]int
+
+	// exact indexes services by protocol, address, and port.
+	exact map[virtual][]int
+
+	// other holds services which cannot be indexed exactly,
+	// such as firewall-mark services.
+	other []int
+	match []Filter
+}
+
+// virtual identifies the virtual service of a connection.
+type virtual struct {
+	protocol ipvs.Protocol
+	addr     netip.AddrPort
+}
+
+// NewAggregator returns an Aggregator for services.
+func NewAggregator(services []ipvs.Service) *Aggregator {
+	a := &Aggregator{
+		results: make([]ServiceCounts, len(services)),
+		dests:   make([]map[netip.AddrPort]int, len(services)),
+		exact:   make(map[virtual][]int),
+		match:   make([]Filter, len(services)),
+	}
+
+	for i, svc := range services {
+		a.results[i].Service = svc
+		a.dests[i] = make(map[netip.AddrPort]int)
+		a.match[i] = ForService(svc)
+
+		if svc.FWMark != 0 || svc.Port == 0 {
+			a.other = append(a.other, i)
+			continue
+		}
+
+		k := virtual{svc.Protocol, netip.AddrPortFrom(svc.Address.Unmap(), svc.Port)}
+		a.exact[k] = append(a.exact[k], i)
+	}
+
+	return a
+}
+
+// Add counts e against each Service it belongs to.
+func (a *Aggregator) Add(e Entry) {
+	k := virtual{e.Protocol, netip.AddrPortFrom(e.Virtual.Addr().Unmap(), e.Virtual.Port())}
+	for _, i := range a.exact[k] {
+		a.add(i, e)
+	}
+
+	for _, i := range a.other {
+		if a.match[i](e) {
+			a.add(i, e)
+		}
+	}
+}
+
+// add counts e against the i-th Service, and its Destination.
+func (a *Aggregator) add(i int, e Entry) {
+	svc := &a.results[i]
+	svc.add(e)
+
+	j, ok := a.dests[i][e.Destination]
+	if !ok {
+		j = len(svc.Destinations)
+		a.dests[i][e.Destination] = j
+		svc.Destinations = append(svc.Destinations, DestinationCounts{Destination: e.Destination})
+	}
+
+	svc.Destinations[j].add(e)
+}
+
+// Counts returns the tallies of each Service, in the order they were
+// passed to NewAggregator.
+func (a *Aggregator) Counts() []ServiceCounts {
+	return a.results
+}
+
+// Aggregate reads every entry from s, and tallies them for services.
+func Aggregate(s *Scanner, services []ipvs.Service) ([]ServiceCounts, error) {
+	a := NewAggregator(services)
+	for s.Scan() {
+		a.Add(s.Entry())
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return a.Counts(), nil
+}
@@ -0,0 +1,109 @@
+package conntable
+
+import (
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+)
+
+func TestAggregate(t *testing.T) {
+	f, err := os.Open("testdata/ip_vs_conn")
+	assert.NilError(t, err)
+	defer f.Close()
+
+	web := ipvs.Service{
+		Address:  netip.MustParseAddr("192.0.2.1"),
+		Port:     80,
+		Family:   ipvs.INET,
+		Protocol: ipvs.TCP,
+		Flags:    ipvs.ServicePersistent,
+	}
+	dns := ipvs.Service{
+		Address:  netip.MustParseAddr("192.0.2.2"),
+		Family:   ipvs.INET,
+		Protocol: ipvs.UDP,
+	}
+	fwm := ipvs.Service{
+		FWMark: 42,
+		Family: ipvs.INET,
+	}
+	idle := ipvs.Service{
+		Address:  netip.MustParseAddr("192.0.2.9"),
+		Port:     443,
+		Family:   ipvs.INET,
+		Protocol: ipvs.TCP,
+	}
+
+	counts, err := Aggregate(NewScanner(f), []ipvs.Service{web, dns, fwm, idle})
+	assert.NilError(t, err)
+
+	expected := []ServiceCounts{
+		{
+			Service: web,
+			Counts: Counts{
+				Connections: 2,
+				States:      map[string]int{"ESTABLISHED": 1, "TIME_WAIT": 1},
+				Templates:   1,
+			},
+			Destinations: []DestinationCounts{
+				{
+					Destination: netip.MustParseAddrPort("198.51.100.1:8080"),
+					Counts: Counts{
+						Connections: 1,
+						States:      map[string]int{"ESTABLISHED": 1},
+						Templates:   1,
+					},
+				},
+				{
+					Destination: netip.MustParseAddrPort("198.51.100.2:8080"),
+					Counts: Counts{
+						Connections: 1,
+						States:      map[string]int{"TIME_WAIT": 1},
+					},
+				},
+			},
+		},
+		{
+			Service: dns,
+			Counts: Counts{
+				Connections: 1,
+				States:      map[string]int{"UDP": 1},
+			},
+			Destinations: []DestinationCounts{
+				{
+					Destination: netip.MustParseAddrPort("198.51.100.3:53"),
+					Counts: Counts{
+						Connections: 1,
+						States:      map[string]int{"UDP": 1},
+					},
+				},
+			},
+		},
+		{
+			Service: fwm,
+			Counts: Counts{
+				Templates: 1,
+			},
+			Destinations: []DestinationCounts{
+				{
+					Destination: netip.MustParseAddrPort("198.51.100.4:0"),
+					Counts: Counts{
+						Templates: 1,
+					},
+				},
+			},
+		},
+		{
+			Service: idle,
+		},
+	}
+
+	assert.DeepEqual(t, counts, expected,
+		cmp.Comparer(func(x, y netip.Addr) bool { return x == y }),
+		cmp.Comparer(func(x, y netip.AddrPort) bool { return x == y }),
+	)
+}
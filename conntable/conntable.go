@@ -0,0 +1,361 @@
+// Package conntable reads the IPVS connection table, which is exposed by
+// the kernel in /proc/net/ip_vs_conn and /proc/net/ip_vs_conn_sync, but
+// not over netlink.
+//
+// The tables can contain millions of entries, so they are read with a
+// Scanner, one Entry at a time.
+package conntable
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// Paths of the connection tables.
+const (
+	ConnPath = "/proc/net/ip_vs_conn"
+	SyncPath = "/proc/net/ip_vs_conn_sync"
+)
+
+// Origin reports whether a connection was created locally, or synchronized
+// from another director by the IPVS sync daemon.
+type Origin uint8
+
+// Connection origins.
+const (
+	// OriginUnknown is reported for entries of ip_vs_conn,
+	// which does not include the origin of connections.
+	OriginUnknown Origin = iota
+	OriginLocal
+	OriginSync
+)
+
+// String returns a human readable representation of the origin.
+func (o Origin) String() string {
+	switch o {
+	case OriginLocal:
+		return "LOCAL"
+	case OriginSync:
+		return "SYNC"
+	}
+
+	return "unknown"
+}
+
+// Entry is a connection, or a persistence template, in the IPVS connection table.
+type Entry struct {
+	// Protocol of the connection. Persistence templates of
+	// firewall-mark services have a Protocol of zero.
+	Protocol ipvs.Protocol
+
+	// Client is the source of the connection. For persistence templates,
+	// this is the client address masked with the Service's netmask.
+	Client netip.AddrPort
+
+	// Virtual is the address of the virtual service. For persistence
+	// templates of firewall-mark services, the address holds the
+	// firewall mark instead.
+	Virtual netip.AddrPort
+
+	// Destination is the real server the connection is forwarded to.
+	Destination netip.AddrPort
+
+	// State is the protocol state, such as "ESTABLISHED" or "TIME_WAIT".
+	// Persistence templates are in state "NONE" or "ASSURED".
+	State string
+
+	// Expires is the time remaining before the entry expires.
+	Expires time.Duration
+
+	// PEName and PEData are the persistence engine, and its data,
+	// for connections scheduled by a persistence engine such as "sip".
+	PEName string
+	PEData string
+
+	// Origin is only reported by ip_vs_conn_sync.
+	Origin Origin
+}
+
+// Template reports whether the entry is a persistence template, which
+// pins clients to a destination, rather than a connection.
+func (e Entry) Template() bool {
+	switch {
+	case e.State == "ASSURED":
+		return true
+	case e.Protocol == 0:
+		return true
+	case e.State == "NONE" && e.Client.Port() == 0:
+		return true
+	}
+
+	return false
+}
+
+// Scanner reads Entries from a connection table. Both the ip_vs_conn
+// and ip_vs_conn_sync formats are supported, chosen by the header line.
+type Scanner struct {
+	s     *bufio.Scanner
+	line  int
+	sync  bool
+	entry Entry
+	err   error
+}
+
+// NewScanner returns a Scanner reading from r.
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{
+		s: bufio.NewScanner(r),
+	}
+}
+
+// Scan advances to the next Entry, which will then be available through
+// Entry. It returns false when there are no more entries, or an error
+// occurred, which will be available through Err.
+func (s *Scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+
+	for s.s.Scan() {
+		s.line++
+		line := s.s.Text()
+
+		if s.line == 1 {
+			if !strings.HasPrefix(line, "Pro ") {
+				s.err = fmt.Errorf("conntable: line 1: unexpected header %q", line)
+				return false
+			}
+
+			s.sync = strings.Contains(line, " Origin ")
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		e, err := parseEntry(line, s.sync)
+		if err != nil {
+			s.err = fmt.Errorf("conntable: line %d: %w", s.line, err)
+			return false
+		}
+
+		s.entry = e
+		return true
+	}
+
+	s.err = s.s.Err()
+	return false
+}
+
+// Entry returns the most recent Entry read by Scan.
+func (s *Scanner) Entry() Entry {
+	return s.entry
+}
+
+// Err returns the first error encountered by the Scanner.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// ReadFile reads the entries of the connection table at path,
+// such as ConnPath, which match every filter.
+func ReadFile(path string, filters ...Filter) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	s := NewScanner(f)
+	for s.Scan() {
+		if e := s.Entry(); matchAll(e, filters) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, s.Err()
+}
+
+// parseEntry parses a line of the connection table.
+func parseEntry(line string, sync bool) (Entry, error) {
+	fields := strings.Fields(line)
+
+	n := 9
+	if sync {
+		n = 10
+	}
+	if len(fields) < n {
+		return Entry{}, fmt.Errorf("expected at least %d fields, got %d", n, len(fields))
+	}
+
+	var e Entry
+	var err error
+
+	e.Protocol, err = parseProtocol(fields[0])
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if e.Client, err = parseAddrPort(fields[1], fields[2]); err != nil {
+		return Entry{}, err
+	}
+	if e.Virtual, err = parseAddrPort(fields[3], fields[4]); err != nil {
+		return Entry{}, err
+	}
+	if e.Destination, err = parseAddrPort(fields[5], fields[6]); err != nil {
+		return Entry{}, err
+	}
+
+	e.State = fields[7]
+
+	expires := fields[8]
+	if sync {
+		switch fields[8] {
+		case "LOCAL":
+			e.Origin = OriginLocal
+		case "SYNC":
+			e.Origin = OriginSync
+		default:
+			return Entry{}, fmt.Errorf("unknown origin %q", fields[8])
+		}
+
+		expires = fields[9]
+	}
+
+	secs, err := strconv.ParseUint(expires, 10, 32)
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid expiry %q", expires)
+	}
+	e.Expires = time.Duration(secs) * time.Second
+
+	if !sync && len(fields) > n {
+		e.PEName = fields[n]
+		e.PEData = strings.Join(fields[n+1:], " ")
+	}
+
+	return e, nil
+}
+
+// parseProtocol parses a protocol name, as printed by the kernel.
+func parseProtocol(s string) (ipvs.Protocol, error) {
+	switch s {
+	case "IP":
+		return 0, nil
+	case "TCP":
+		return ipvs.TCP, nil
+	case "UDP":
+		return ipvs.UDP, nil
+	case "SCTP":
+		return ipvs.SCTP, nil
+	case "ICMP":
+		return 1, nil
+	case "ICMPv6":
+		return 58, nil
+	}
+
+	if n, ok := strings.CutPrefix(s, "IP_"); ok {
+		p, err := strconv.ParseUint(n, 10, 8)
+		if err == nil {
+			return ipvs.Protocol(p), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown protocol %q", s)
+}
+
+// parseAddrPort parses an address, which is either a hexadecimal IPv4
+// address or an IPv6 address, and a hexadecimal port.
+func parseAddrPort(addr, port string) (netip.AddrPort, error) {
+	var a netip.Addr
+	if strings.Contains(addr, ":") {
+		var err error
+		a, err = netip.ParseAddr(addr)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+	} else {
+		if len(addr) != 8 {
+			return netip.AddrPort{}, fmt.Errorf("invalid address %q", addr)
+		}
+
+		var b [4]byte
+		if _, err := hex.Decode(b[:], []byte(addr)); err != nil {
+			return netip.AddrPort{}, fmt.Errorf("invalid address %q", addr)
+		}
+		a = netip.AddrFrom4(b)
+	}
+
+	p, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid port %q", port)
+	}
+
+	return netip.AddrPortFrom(a, uint16(p)), nil
+}
+
+// Filter selects Entries.
+type Filter func(Entry) bool
+
+// ForService selects the entries of a Service.
+//
+// The connections of firewall-mark services do not record the mark, so
+// only their persistence templates are selected. Services with a port
+// of zero match connections to any port.
+func ForService(svc ipvs.Service) Filter {
+	if svc.FWMark != 0 {
+		var mark [4]byte
+		binary.BigEndian.PutUint32(mark[:], svc.FWMark)
+
+		return func(e Entry) bool {
+			if e.Protocol != 0 {
+				return false
+			}
+
+			a := e.Virtual.Addr()
+			if a.Is4() {
+				return a.As4() == mark
+			}
+
+			b := a.As16()
+			return [4]byte(b[:4]) == mark && [12]byte(b[4:]) == [12]byte{}
+		}
+	}
+
+	addr := svc.Address.Unmap()
+	return func(e Entry) bool {
+		return e.Protocol == svc.Protocol &&
+			e.Virtual.Addr().Unmap() == addr &&
+			(svc.Port == 0 || e.Virtual.Port() == svc.Port)
+	}
+}
+
+// ForClient selects the entries of a client address.
+func ForClient(addr netip.Addr) Filter {
+	addr = addr.Unmap()
+	return func(e Entry) bool {
+		return e.Client.Addr().Unmap() == addr
+	}
+}
+
+// matchAll reports whether e matches every filter.
+func matchAll(e Entry, filters []Filter) bool {
+	for _, f := range filters {
+		if !f(e) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,260 @@
+package conntable
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+)
+
+func TestReadFile(t *testing.T) {
+	type testCase struct {
+		name     string
+		path     string
+		filters  []Filter
+		expected []Entry
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		entries, err := ReadFile(tc.path, tc.filters...)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, entries, tc.expected, cmp.Comparer(func(x, y netip.AddrPort) bool { return x == y }))
+	}
+
+	testCases := []testCase{
+		{
+			name: "ip_vs_conn",
+			path: "testdata/ip_vs_conn",
+			expected: []Entry{
+				{
+					Protocol:    ipvs.TCP,
+					Client:      netip.MustParseAddrPort("192.168.10.1:54321"),
+					Virtual:     netip.MustParseAddrPort("192.0.2.1:80"),
+					Destination: netip.MustParseAddrPort("198.51.100.1:8080"),
+					State:       "ESTABLISHED",
+					Expires:     899 * time.Second,
+				},
+				{
+					Protocol:    ipvs.TCP,
+					Client:      netip.MustParseAddrPort("192.168.10.2:54322"),
+					Virtual:     netip.MustParseAddrPort("192.0.2.1:80"),
+					Destination: netip.MustParseAddrPort("198.51.100.2:8080"),
+					State:       "TIME_WAIT",
+					Expires:     61 * time.Second,
+				},
+				{
+					Protocol:    ipvs.TCP,
+					Client:      netip.MustParseAddrPort("192.168.10.0:0"),
+					Virtual:     netip.MustParseAddrPort("192.0.2.1:80"),
+					Destination: netip.MustParseAddrPort("198.51.100.1:8080"),
+					State:       "NONE",
+					Expires:     299 * time.Second,
+				},
+				{
+					Protocol:    ipvs.UDP,
+					Client:      netip.MustParseAddrPort("192.168.10.3:57345"),
+					Virtual:     netip.MustParseAddrPort("192.0.2.2:53"),
+					Destination: netip.MustParseAddrPort("198.51.100.3:53"),
+					State:       "UDP",
+					Expires:     183 * time.Second,
+				},
+				{
+					Protocol:    0,
+					Client:      netip.MustParseAddrPort("192.168.11.0:0"),
+					Virtual:     netip.MustParseAddrPort("0.0.0.42:0"),
+					Destination: netip.MustParseAddrPort("198.51.100.4:0"),
+					State:       "ASSURED",
+					Expires:     120 * time.Second,
+				},
+				{
+					Protocol:    ipvs.UDP,
+					Client:      netip.MustParseAddrPort("192.168.10.4:5060"),
+					Virtual:     netip.MustParseAddrPort("192.0.2.3:5060"),
+					Destination: netip.MustParseAddrPort("198.51.100.5:5060"),
+					State:       "UDP",
+					Expires:     170 * time.Second,
+					PEName:      "sip",
+					PEData:      "1-2@198.51.100.7",
+				},
+				{
+					Protocol:    ipvs.SCTP,
+					Client:      netip.MustParseAddrPort("192.168.10.5:2905"),
+					Virtual:     netip.MustParseAddrPort("192.0.2.4:2905"),
+					Destination: netip.MustParseAddrPort("198.51.100.6:2905"),
+					State:       "ESTABLISHED",
+					Expires:     899 * time.Second,
+				},
+			},
+		},
+		{
+			name: "ipv6",
+			path: "testdata/ip_vs_conn6",
+			expected: []Entry{
+				{
+					Protocol:    ipvs.TCP,
+					Client:      netip.MustParseAddrPort("[2001:db8::1]:54321"),
+					Virtual:     netip.MustParseAddrPort("[2001:db8::1:1]:443"),
+					Destination: netip.MustParseAddrPort("[2001:db8::2:1]:443"),
+					State:       "SYN_RECV",
+					Expires:     57 * time.Second,
+				},
+				{
+					Protocol:    ipvs.TCP,
+					Client:      netip.MustParseAddrPort("[2001:db8::2]:54322"),
+					Virtual:     netip.MustParseAddrPort("[2001:db8::1:1]:443"),
+					Destination: netip.MustParseAddrPort("198.51.100.1:443"),
+					State:       "ESTABLISHED",
+					Expires:     899 * time.Second,
+				},
+			},
+		},
+		{
+			name: "ip_vs_conn_sync",
+			path: "testdata/ip_vs_conn_sync",
+			expected: []Entry{
+				{
+					Protocol:    ipvs.TCP,
+					Client:      netip.MustParseAddrPort("192.168.10.1:54321"),
+					Virtual:     netip.MustParseAddrPort("192.0.2.1:80"),
+					Destination: netip.MustParseAddrPort("198.51.100.1:8080"),
+					State:       "ESTABLISHED",
+					Expires:     899 * time.Second,
+					Origin:      OriginLocal,
+				},
+				{
+					Protocol:    ipvs.TCP,
+					Client:      netip.MustParseAddrPort("192.168.10.6:54323"),
+					Virtual:     netip.MustParseAddrPort("192.0.2.1:80"),
+					Destination: netip.MustParseAddrPort("198.51.100.2:8080"),
+					State:       "FIN_WAIT",
+					Expires:     95 * time.Second,
+					Origin:      OriginSync,
+				},
+			},
+		},
+		{
+			name: "service",
+			path: "testdata/ip_vs_conn",
+			filters: []Filter{ForService(ipvs.Service{
+				Address:  netip.MustParseAddr("192.0.2.2"),
+				Port:     53,
+				Family:   ipvs.INET,
+				Protocol: ipvs.UDP,
+			})},
+			expected: []Entry{
+				{
+					Protocol:    ipvs.UDP,
+					Client:      netip.MustParseAddrPort("192.168.10.3:57345"),
+					Virtual:     netip.MustParseAddrPort("192.0.2.2:53"),
+					Destination: netip.MustParseAddrPort("198.51.100.3:53"),
+					State:       "UDP",
+					Expires:     183 * time.Second,
+				},
+			},
+		},
+		{
+			name: "fwmark service",
+			path: "testdata/ip_vs_conn",
+			filters: []Filter{ForService(ipvs.Service{
+				FWMark: 42,
+				Family: ipvs.INET,
+			})},
+			expected: []Entry{
+				{
+					Protocol:    0,
+					Client:      netip.MustParseAddrPort("192.168.11.0:0"),
+					Virtual:     netip.MustParseAddrPort("0.0.0.42:0"),
+					Destination: netip.MustParseAddrPort("198.51.100.4:0"),
+					State:       "ASSURED",
+					Expires:     120 * time.Second,
+				},
+			},
+		},
+		{
+			name: "client",
+			path: "testdata/ip_vs_conn_sync",
+			filters: []Filter{
+				ForClient(netip.MustParseAddr("::ffff:192.168.10.6")),
+			},
+			expected: []Entry{
+				{
+					Protocol:    ipvs.TCP,
+					Client:      netip.MustParseAddrPort("192.168.10.6:54323"),
+					Virtual:     netip.MustParseAddrPort("192.0.2.1:80"),
+					Destination: netip.MustParseAddrPort("198.51.100.2:8080"),
+					State:       "FIN_WAIT",
+					Expires:     95 * time.Second,
+					Origin:      OriginSync,
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestEntry_Template(t *testing.T) {
+	entries, err := ReadFile("testdata/ip_vs_conn")
+	assert.NilError(t, err)
+
+	var templates []bool
+	for _, e := range entries {
+		templates = append(templates, e.Template())
+	}
+
+	assert.DeepEqual(t, templates, []bool{false, false, true, false, true, false, false})
+}
+
+func TestScanner_Errors(t *testing.T) {
+	type testCase struct {
+		name     string
+		input    string
+		expected string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		s := NewScanner(strings.NewReader(tc.input))
+		for s.Scan() {
+		}
+		assert.ErrorContains(t, s.Err(), tc.expected)
+	}
+
+	const header = "Pro FromIP   FPrt ToIP     TPrt DestIP   DPrt State       Expires PEName PEData\n"
+
+	testCases := []testCase{
+		{
+			name:     "header",
+			input:    "TCP C0A80A01 D431 C0000201 0050 c6336401 1F90 ESTABLISHED     899\n",
+			expected: "line 1: unexpected header",
+		},
+		{
+			name:     "short",
+			input:    header + "TCP C0A80A01 D431 C0000201 0050\n",
+			expected: "line 2: expected at least 9 fields",
+		},
+		{
+			name:     "address",
+			input:    header + "TCP C0A80A01 D431 C0000201 0050 c63364 1F90 ESTABLISHED     899\n",
+			expected: `line 2: invalid address "c63364"`,
+		},
+		{
+			name:     "protocol",
+			input:    header + "XYZ C0A80A01 D431 C0000201 0050 c6336401 1F90 ESTABLISHED     899\n",
+			expected: `line 2: unknown protocol "XYZ"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
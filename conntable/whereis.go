@@ -0,0 +1,94 @@
+package conntable
+
+import (
+	"errors"
+	"net/netip"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/netmask"
+)
+
+// ErrNotPinned is returned by WhereIs when no entry directs the client
+// to a Destination.
+var ErrNotPinned = errors.New("conntable: client is not pinned to a destination")
+
+// Pin is the Destination a client is directed to by a Service.
+type Pin struct {
+	Destination netip.AddrPort
+
+	// Remaining is the time before the persistence template expires, or
+	// for services without persistence, before the client's longest-lived
+	// connection expires.
+	Remaining time.Duration
+
+	// Template is set when the client is pinned by a persistence template.
+	Template bool
+}
+
+// WhereIs reads the connection table from s, and returns the Destination
+// a client is directed to by a Service.
+//
+// For persistent services, the persistence template covering the client
+// is found by masking its address with the Service's Netmask. Otherwise,
+// the client's connections are used, reporting the one which expires last.
+func WhereIs(s *Scanner, svc ipvs.Service, client netip.Addr) (Pin, error) {
+	persistent := svc.Flags&ipvs.ServicePersistent != 0
+
+	match := ForService(svc)
+	masked := maskAddr(client.Unmap(), svc.Netmask)
+
+	var pin Pin
+	var found bool
+	for s.Scan() {
+		e := s.Entry()
+		if e.Template() != persistent || !match(e) {
+			continue
+		}
+
+		addr := e.Client.Addr().Unmap()
+		if persistent && addr != masked || !persistent && addr != client.Unmap() {
+			continue
+		}
+
+		if !found || e.Expires > pin.Remaining {
+			pin = Pin{
+				Destination: e.Destination,
+				Remaining:   e.Expires,
+				Template:    e.Template(),
+			}
+			found = true
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return Pin{}, err
+	}
+
+	if !found {
+		return Pin{}, ErrNotPinned
+	}
+
+	return pin, nil
+}
+
+// maskAddr masks addr with mask, as IPVS does for the client address
+// of persistence templates. An invalid mask leaves addr unchanged.
+func maskAddr(addr netip.Addr, mask netmask.Mask) netip.Addr {
+	switch {
+	case addr.Is4() && mask.Is4():
+		a, m := addr.As4(), mask.AsSlice()
+		for i := range a {
+			a[i] &= m[i]
+		}
+		return netip.AddrFrom4(a)
+	case addr.Is6() && mask.Is6():
+		p, err := addr.Prefix(mask.Bits())
+		if err != nil {
+			return addr
+		}
+		return p.Addr()
+	}
+
+	return addr
+}
@@ -0,0 +1,108 @@
+package conntable
+
+import (
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/netmask"
+	"gotest.tools/v3/assert"
+)
+
+func TestWhereIs(t *testing.T) {
+	type testCase struct {
+		name     string
+		svc      ipvs.Service
+		client   string
+		expected Pin
+		err      error
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		f, err := os.Open("testdata/ip_vs_conn")
+		assert.NilError(t, err)
+		defer f.Close()
+
+		pin, err := WhereIs(NewScanner(f), tc.svc, netip.MustParseAddr(tc.client))
+		if tc.err != nil {
+			assert.ErrorIs(t, err, tc.err)
+			return
+		}
+
+		assert.NilError(t, err)
+		assert.Equal(t, pin, tc.expected)
+	}
+
+	web := ipvs.Service{
+		Address:  netip.MustParseAddr("192.0.2.1"),
+		Port:     80,
+		Family:   ipvs.INET,
+		Protocol: ipvs.TCP,
+		Flags:    ipvs.ServicePersistent,
+		Netmask:  netmask.MaskFrom(24, 32),
+	}
+	fwm := ipvs.Service{
+		FWMark:  42,
+		Family:  ipvs.INET,
+		Flags:   ipvs.ServicePersistent,
+		Netmask: netmask.MaskFrom(24, 32),
+	}
+	dns := ipvs.Service{
+		Address:  netip.MustParseAddr("192.0.2.2"),
+		Port:     53,
+		Family:   ipvs.INET,
+		Protocol: ipvs.UDP,
+	}
+
+	testCases := []testCase{
+		{
+			name:   "persistent",
+			svc:    web,
+			client: "192.168.10.200",
+			expected: Pin{
+				Destination: netip.MustParseAddrPort("198.51.100.1:8080"),
+				Remaining:   299 * time.Second,
+				Template:    true,
+			},
+		},
+		{
+			name:   "persistent fwmark",
+			svc:    fwm,
+			client: "::ffff:192.168.11.7",
+			expected: Pin{
+				Destination: netip.MustParseAddrPort("198.51.100.4:0"),
+				Remaining:   120 * time.Second,
+				Template:    true,
+			},
+		},
+		{
+			name:   "persistent other network",
+			svc:    web,
+			client: "192.168.12.1",
+			err:    ErrNotPinned,
+		},
+		{
+			name:   "connection",
+			svc:    dns,
+			client: "192.168.10.3",
+			expected: Pin{
+				Destination: netip.MustParseAddrPort("198.51.100.3:53"),
+				Remaining:   183 * time.Second,
+			},
+		},
+		{
+			name:   "no connection",
+			svc:    dns,
+			client: "192.168.10.4",
+			err:    ErrNotPinned,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package ipvs
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// DaemonClient is implemented by Clients which can manage the connection
+// synchronization daemons, which replicate connections between a master
+// and its backups. Use a type assertion to check for support.
+type DaemonClient interface {
+	// Daemons returns the running daemons, which is empty if none run.
+	Daemons() ([]Daemon, error)
+	CreateDaemon(Daemon) error
+	// RemoveDaemon stops the daemon in the State of the given Daemon.
+	RemoveDaemon(Daemon) error
+}
+
+// DaemonState is the role of a synchronization daemon.
+type DaemonState uint32
+
+// Synchronization daemon states, as in linux/ip_vs.h. At most one daemon
+// of each state runs at a time.
+const (
+	DaemonMaster DaemonState = 0x1
+	DaemonBackup DaemonState = 0x2
+)
+
+// String returns "master" or "backup".
+func (s DaemonState) String() string {
+	switch s {
+	case DaemonMaster:
+		return "master"
+	case DaemonBackup:
+		return "backup"
+	}
+
+	return "DaemonState(" + strconv.FormatUint(uint64(s), 10) + ")"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s DaemonState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *DaemonState) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "master":
+		*s = DaemonMaster
+	case "backup":
+		*s = DaemonBackup
+	default:
+		return fmt.Errorf("ipvs: unknown daemon state %q", text)
+	}
+
+	return nil
+}
+
+// Daemon is a connection synchronization daemon.
+//
+// The zero values of the optional fields (MaxLen, Group, Port and TTL)
+// select the kernel's defaults.
+type Daemon struct {
+	State     DaemonState `json:"state"`
+	Interface string      `json:"interface"`
+	SyncID    uint32      `json:"syncID,omitzero"`
+	MaxLen    uint16      `json:"maxLen,omitzero"`
+	Group     netip.Addr  `json:"group,omitzero"`
+	Port      uint16      `json:"port,omitzero"`
+	TTL       uint8       `json:"ttl,omitzero"`
+}
+
+// Defaults used by the kernel for the optional fields of a Daemon.
+const (
+	DefaultDaemonPort = 8848
+	DefaultDaemonTTL  = 1
+)
+
+// DefaultDaemonGroup is the multicast group used by the kernel when a
+// Daemon has no Group.
+var DefaultDaemonGroup = netip.AddrFrom4([4]byte{224, 0, 0, 81})
+
+// WithDefaults returns d with its unset Group, Port and TTL set to the
+// kernel's defaults, as reported for a running daemon. MaxLen is left
+// unset, as its default depends on the MTU of the Interface.
+func (d Daemon) WithDefaults() Daemon {
+	if !d.Group.IsValid() {
+		d.Group = DefaultDaemonGroup
+	}
+	if d.Port == 0 {
+		d.Port = DefaultDaemonPort
+	}
+	if d.TTL == 0 {
+		d.TTL = DefaultDaemonTTL
+	}
+
+	return d
+}
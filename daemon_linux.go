@@ -0,0 +1,160 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"net/netip"
+	"os"
+
+	"github.com/cloudflare/ipvs/internal/cipvs"
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/netlink"
+)
+
+var _ DaemonClient = (*client)(nil)
+
+// Daemons returns the running synchronization daemons.
+func (c *client) Daemons() ([]Daemon, error) {
+	msg := genetlink.Message{
+		Header: genetlink.Header{
+			Command: cipvs.CmdGetDaemon,
+			Version: cipvs.GenlVersion,
+		},
+	}
+	flags := netlink.Request | netlink.Dump
+
+	msgs, err := c.execute(msg, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	daemons := make([]Daemon, 0, len(msgs))
+	for _, msg := range msgs {
+		var d Daemon
+		ad, err := netlink.NewAttributeDecoder(msg.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		for ad.Next() {
+			if ad.Type() == cipvs.CmdAttrDaemon {
+				ad.Do(unpackDaemon(&d))
+			}
+		}
+
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+
+		daemons = append(daemons, d)
+	}
+
+	return daemons, nil
+}
+
+// CreateDaemon starts a synchronization daemon.
+func (c *client) CreateDaemon(d Daemon) error {
+	return c.daemon(cipvs.CmdNewDaemon, packDaemon(d))
+}
+
+// RemoveDaemon stops the synchronization daemon in the state of d.
+func (c *client) RemoveDaemon(d Daemon) error {
+	return c.daemon(cipvs.CmdDelDaemon, func() ([]byte, error) {
+		ae := netlink.NewAttributeEncoder()
+		ae.Uint32(cipvs.DaemonAttrState, uint32(d.State))
+		return ae.Encode()
+	})
+}
+
+// daemon sends cmd with the daemon attributes encoded by pack.
+func (c *client) daemon(cmd uint8, pack func() ([]byte, error)) error {
+	ae := netlink.NewAttributeEncoder()
+	ae.Do(cipvs.CmdAttrDaemon, pack)
+	b, err := ae.Encode()
+	if err != nil {
+		return err
+	}
+
+	msg := genetlink.Message{
+		Header: genetlink.Header{
+			Command: cmd,
+			Version: cipvs.GenlVersion,
+		},
+		Data: b,
+	}
+	flags := netlink.Request | netlink.Acknowledge
+
+	r, err := c.execute(msg, flags)
+	if err != nil {
+		return err
+	}
+
+	if len(r) == 0 {
+		return os.ErrInvalid
+	}
+
+	return nil
+}
+
+// packDaemon encodes the daemon attributes, leaving out the optional
+// attributes which are unset.
+func packDaemon(d Daemon) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		ae := netlink.NewAttributeEncoder()
+		ae.Uint32(cipvs.DaemonAttrState, uint32(d.State))
+		ae.String(cipvs.DaemonAttrMcastIfn, d.Interface)
+		ae.Uint32(cipvs.DaemonAttrSyncId, d.SyncID)
+
+		if d.MaxLen != 0 {
+			ae.Uint16(cipvs.DaemonAttrSyncMaxlen, d.MaxLen)
+		}
+		switch {
+		case d.Group.Is4():
+			ae.Bytes(cipvs.DaemonAttrMcastGroup, d.Group.AsSlice())
+		case d.Group.Is6():
+			ae.Bytes(cipvs.DaemonAttrMcastGroup6, d.Group.AsSlice())
+		}
+		if d.Port != 0 {
+			ae.Uint16(cipvs.DaemonAttrMcastPort, d.Port)
+		}
+		if d.TTL != 0 {
+			ae.Uint8(cipvs.DaemonAttrMcastTtl, d.TTL)
+		}
+
+		return ae.Encode()
+	}
+}
+
+// unpackDaemon unpacks a Daemon from a netlink-encoded message
+func unpackDaemon(d *Daemon) func(b []byte) error {
+	return func(b []byte) error {
+		ad, err := netlink.NewAttributeDecoder(b)
+		if err != nil {
+			return err
+		}
+
+		for ad.Next() {
+			switch ad.Type() {
+			case cipvs.DaemonAttrState:
+				d.State = DaemonState(ad.Uint32())
+			case cipvs.DaemonAttrMcastIfn:
+				d.Interface = ad.String()
+			case cipvs.DaemonAttrSyncId:
+				d.SyncID = ad.Uint32()
+			case cipvs.DaemonAttrSyncMaxlen:
+				d.MaxLen = ad.Uint16()
+			case cipvs.DaemonAttrMcastGroup, cipvs.DaemonAttrMcastGroup6:
+				if addr, ok := netip.AddrFromSlice(ad.Bytes()); ok {
+					d.Group = addr
+				}
+			case cipvs.DaemonAttrMcastPort:
+				d.Port = ad.Uint16()
+			case cipvs.DaemonAttrMcastTtl:
+				d.TTL = ad.Uint8()
+			}
+		}
+
+		return ad.Err()
+	}
+}
@@ -0,0 +1,70 @@
+//go:build linux
+// +build linux
+
+package ipvs
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/cloudflare/ipvs/internal/cipvs"
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/genetlink/genltest"
+	"github.com/mdlayher/netlink"
+	"gotest.tools/v3/assert"
+)
+
+func TestDaemons_PackUnpack(t *testing.T) {
+	daemons := []Daemon{
+		{State: DaemonMaster, Interface: "eth0", SyncID: 7},
+		{
+			State:     DaemonBackup,
+			Interface: "eth1",
+			SyncID:    8,
+			MaxLen:    1472,
+			Group:     netip.MustParseAddr("ff02::8765"),
+			Port:      8848,
+			TTL:       2,
+		},
+	}
+
+	var sent []genetlink.Message
+	create := func(gerq genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+		sent = append(sent, genetlink.Message{Data: gerq.Data})
+		return []genetlink.Message{{}}, nil
+	}
+	client := testClient(t, genltest.CheckRequest(familyID, cipvs.CmdNewDaemon, netlink.Request|netlink.Acknowledge, create))
+	for _, d := range daemons {
+		assert.NilError(t, client.CreateDaemon(d))
+	}
+
+	dump := func(genetlink.Message, netlink.Message) ([]genetlink.Message, error) {
+		return sent, nil
+	}
+	client = testClient(t, genltest.CheckRequest(familyID, cipvs.CmdGetDaemon, netlink.Request|netlink.Dump, dump))
+
+	got, err := client.Daemons()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, daemons, cmp.Comparer(NetipAddrCompare))
+}
+
+func TestRemoveDaemon(t *testing.T) {
+	fn := func(gerq genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+		var d Daemon
+		ad, err := netlink.NewAttributeDecoder(gerq.Data)
+		assert.NilError(t, err)
+		for ad.Next() {
+			if ad.Type() == cipvs.CmdAttrDaemon {
+				ad.Do(unpackDaemon(&d))
+			}
+		}
+		assert.NilError(t, ad.Err())
+		assert.DeepEqual(t, d, Daemon{State: DaemonBackup}, cmp.Comparer(NetipAddrCompare))
+
+		return []genetlink.Message{{}}, nil
+	}
+	client := testClient(t, genltest.CheckRequest(familyID, cipvs.CmdDelDaemon, netlink.Request|netlink.Acknowledge, fn))
+
+	assert.NilError(t, client.RemoveDaemon(Daemon{State: DaemonBackup, Interface: "eth1"}))
+}
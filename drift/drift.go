@@ -0,0 +1,363 @@
+// Package drift reports how the configuration of IPVS differs between
+// hosts, or from a desired configuration, as described by snapshots.
+package drift
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/snapshot"
+)
+
+// Severity ranks the impact of a Difference.
+type Severity int
+
+// Severities, from least to most severe.
+const (
+	// Info differences do not change which destinations receive traffic,
+	// such as connection timeouts or non-zero weights.
+	Info Severity = iota
+
+	// Warning differences change how traffic is balanced, such as the
+	// scheduler, or a destination drained on only some hosts.
+	Warning
+
+	// Critical differences break forwarding, such as a missing service,
+	// or destinations forwarded to with different methods.
+	Critical
+)
+
+var severities = []string{"info", "warning", "critical"}
+
+// String returns the name of the severity, such as "warning".
+func (s Severity) String() string {
+	if s >= 0 && int(s) < len(severities) {
+		return severities[s]
+	}
+
+	return strconv.Itoa(int(s))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Severity) UnmarshalText(text []byte) error {
+	i := slices.Index(severities, strings.ToLower(string(text)))
+	if i < 0 {
+		return fmt.Errorf("drift: unknown severity %q", text)
+	}
+
+	*s = Severity(i)
+	return nil
+}
+
+// Kind classifies a Difference.
+type Kind string
+
+// Kinds of differences.
+const (
+	MissingService     Kind = "missing-service"
+	ExtraService       Kind = "extra-service"
+	MissingDestination Kind = "missing-destination"
+	ExtraDestination   Kind = "extra-destination"
+	ServiceField       Kind = "service-field"
+	DestinationField   Kind = "destination-field"
+	ConfigField        Kind = "config-field"
+	MissingDaemon      Kind = "missing-daemon"
+	ExtraDaemon        Kind = "extra-daemon"
+	DaemonField        Kind = "daemon-field"
+)
+
+// Difference is a single difference between a wanted and an actual
+// configuration.
+type Difference struct {
+	Kind     Kind     `json:"kind"`
+	Severity Severity `json:"severity"`
+
+	// Host is the host with the actual configuration, when comparing hosts.
+	Host string `json:"host,omitempty"`
+
+	Service     ipvs.ServiceKey     `json:"service,omitzero"`
+	Destination ipvs.DestinationKey `json:"destination,omitzero"`
+
+	// Daemon is the state of the synchronization daemon, for the *Daemon
+	// kinds.
+	Daemon ipvs.DaemonState `json:"daemon,omitzero"`
+
+	// Field is the name of the differing field, such as "Weight", and
+	// Want and Got its formatted values, for the *Field kinds.
+	Field string `json:"field,omitempty"`
+	Want  string `json:"want,omitempty"`
+	Got   string `json:"got,omitempty"`
+}
+
+// String describes the difference, such as
+// "tcp:192.0.2.1:80 198.51.100.1:8080: Weight is 0, want 10".
+func (d Difference) String() string {
+	var b strings.Builder
+	if d.Host != "" {
+		b.WriteString(d.Host + ": ")
+	}
+
+	switch d.Kind {
+	case ConfigField:
+		b.WriteString("config")
+	case MissingDaemon, ExtraDaemon, DaemonField:
+		b.WriteString("daemon " + d.Daemon.String())
+	default:
+		b.WriteString(d.Service.String())
+		if d.Destination.IsValid() {
+			b.WriteString(" " + d.Destination.String())
+		}
+	}
+
+	switch d.Kind {
+	case MissingService, MissingDestination, MissingDaemon:
+		b.WriteString(": missing")
+	case ExtraService, ExtraDestination, ExtraDaemon:
+		b.WriteString(": unexpected")
+	default:
+		fmt.Fprintf(&b, ": %s is %s, want %s", d.Field, d.Got, d.Want)
+	}
+
+	return b.String()
+}
+
+// Report lists the differences found, ordered by service and destination.
+type Report []Difference
+
+// Max returns the highest severity in r, or -1 if r is empty.
+func (r Report) Max() Severity {
+	m := Severity(-1)
+	for _, d := range r {
+		m = max(m, d.Severity)
+	}
+
+	return m
+}
+
+// AtLeast returns the differences of r with at least severity min.
+func (r Report) AtLeast(min Severity) Report {
+	return slices.DeleteFunc(slices.Clone(r), func(d Difference) bool {
+		return d.Severity < min
+	})
+}
+
+// Compare reports how the configuration got differs from want. Services
+// are matched by key, so their address family must resolve, as it does
+// for snapshots returned by snapshot.Take and snapshot.Decode.
+func Compare(want, got *snapshot.Snapshot) Report {
+	var r Report
+
+	if want.Config != nil && got.Config != nil {
+		r.config(*want.Config, *got.Config)
+	}
+
+	// Daemons are nil in snapshots of Clients which cannot report them.
+	if want.Daemons != nil && got.Daemons != nil {
+		r.daemons(want.Daemons, got.Daemons)
+	}
+
+	for _, w := range want.Services {
+		g, ok := got.Service(w.Key())
+		if !ok {
+			r = append(r, Difference{Kind: MissingService, Severity: Critical, Service: w.Key()})
+			continue
+		}
+		r.service(w, g)
+	}
+
+	for _, g := range got.Services {
+		if _, ok := want.Service(g.Key()); !ok {
+			r = append(r, Difference{Kind: ExtraService, Severity: Warning, Service: g.Key()})
+		}
+	}
+
+	slices.SortStableFunc(r, func(a, b Difference) int {
+		return cmp.Or(
+			a.Service.Compare(b.Service),
+			a.Destination.Compare(b.Destination),
+		)
+	})
+	return r
+}
+
+// CompareHosts reports how the configuration of each host differs from
+// that of the baseline host, which must be in snapshots.
+func CompareHosts(snapshots map[string]*snapshot.Snapshot, baseline string) (Report, error) {
+	want, ok := snapshots[baseline]
+	if !ok {
+		return nil, fmt.Errorf("drift: no snapshot of baseline host %q", baseline)
+	}
+
+	var r Report
+	hosts := make([]string, 0, len(snapshots))
+	for host := range snapshots {
+		hosts = append(hosts, host)
+	}
+	slices.Sort(hosts)
+
+	for _, host := range hosts {
+		if host == baseline {
+			continue
+		}
+
+		for _, d := range Compare(want, snapshots[host]) {
+			d.Host = host
+			r = append(r, d)
+		}
+	}
+
+	return r, nil
+}
+
+// config compares the timeouts.
+func (r *Report) config(want, got ipvs.Config) {
+	fields := []struct {
+		name      string
+		want, got uint32
+	}{
+		{"TCPTimeout", want.TCPTimeout, got.TCPTimeout},
+		{"TCPFinTimeout", want.TCPFinTimeout, got.TCPFinTimeout},
+		{"UDPTimeout", want.UDPTimeout, got.UDPTimeout},
+	}
+
+	for _, f := range fields {
+		if f.want != f.got {
+			*r = append(*r, Difference{
+				Kind:     ConfigField,
+				Severity: Info,
+				Field:    f.name,
+				Want:     strconv.FormatUint(uint64(f.want), 10),
+				Got:      strconv.FormatUint(uint64(f.got), 10),
+			})
+		}
+	}
+}
+
+// daemons compares the synchronization daemons, with the kernel's
+// defaults filled in.
+func (r *Report) daemons(want, got []ipvs.Daemon) {
+	for _, w := range want {
+		i := slices.IndexFunc(got, func(d ipvs.Daemon) bool { return d.State == w.State })
+		if i < 0 {
+			*r = append(*r, Difference{Kind: MissingDaemon, Severity: Warning, Daemon: w.State})
+			continue
+		}
+		r.daemon(w.WithDefaults(), got[i].WithDefaults())
+	}
+
+	for _, g := range got {
+		if !slices.ContainsFunc(want, func(d ipvs.Daemon) bool { return d.State == g.State }) {
+			*r = append(*r, Difference{Kind: ExtraDaemon, Severity: Warning, Daemon: g.State})
+		}
+	}
+}
+
+// daemon compares a synchronization daemon.
+func (r *Report) daemon(want, got ipvs.Daemon) {
+	field := func(severity Severity, name string, w, g any) {
+		if w != g {
+			*r = append(*r, Difference{
+				Kind:     DaemonField,
+				Severity: severity,
+				Daemon:   want.State,
+				Field:    name,
+				Want:     fmt.Sprint(w),
+				Got:      fmt.Sprint(g),
+			})
+		}
+	}
+
+	// Connections are only synchronized between daemons which agree on
+	// where to send them.
+	field(Warning, "Interface", want.Interface, got.Interface)
+	field(Warning, "SyncID", want.SyncID, got.SyncID)
+	field(Warning, "Group", want.Group, got.Group)
+	field(Warning, "Port", want.Port, got.Port)
+	field(Info, "TTL", want.TTL, got.TTL)
+
+	// The default MaxLen depends on the MTU of the interface.
+	if want.MaxLen != 0 && got.MaxLen != 0 {
+		field(Info, "MaxLen", want.MaxLen, got.MaxLen)
+	}
+}
+
+// service compares a Service and its Destinations.
+func (r *Report) service(want, got snapshot.Service) {
+	field := func(severity Severity, name string, w, g any) {
+		if w != g {
+			*r = append(*r, Difference{
+				Kind:     ServiceField,
+				Severity: severity,
+				Service:  want.Key(),
+				Field:    name,
+				Want:     fmt.Sprint(w),
+				Got:      fmt.Sprint(g),
+			})
+		}
+	}
+
+	field(Warning, "Scheduler", want.Scheduler, got.Scheduler)
+	field(Warning, "Flags", want.Flags&^ipvs.ServiceHashed, got.Flags&^ipvs.ServiceHashed)
+	field(Warning, "Timeout", want.Timeout, got.Timeout)
+	// A Service configured without a Netmask is reported with the
+	// kernel's default.
+	field(Warning, "Netmask", want.WithDefaults().Netmask, got.WithDefaults().Netmask)
+
+	for _, w := range want.Destinations {
+		i := slices.IndexFunc(got.Destinations, func(d ipvs.Destination) bool { return d.Key() == w.Key() })
+		if i < 0 {
+			*r = append(*r, Difference{Kind: MissingDestination, Severity: Warning, Service: want.Key(), Destination: w.Key()})
+			continue
+		}
+		r.destination(want.Key(), w, got.Destinations[i])
+	}
+
+	for _, g := range got.Destinations {
+		if !slices.ContainsFunc(want.Destinations, func(d ipvs.Destination) bool { return d.Key() == g.Key() }) {
+			*r = append(*r, Difference{Kind: ExtraDestination, Severity: Warning, Service: want.Key(), Destination: g.Key()})
+		}
+	}
+}
+
+// destination compares a Destination of the Service svc.
+func (r *Report) destination(svc ipvs.ServiceKey, want, got ipvs.Destination) {
+	field := func(severity Severity, name string, w, g any) {
+		if w != g {
+			*r = append(*r, Difference{
+				Kind:        DestinationField,
+				Severity:    severity,
+				Service:     svc,
+				Destination: want.Key(),
+				Field:       name,
+				Want:        fmt.Sprint(w),
+				Got:         fmt.Sprint(g),
+			})
+		}
+	}
+
+	// A destination drained on only some hosts changes where traffic goes.
+	weight := Info
+	if (want.Weight == 0) != (got.Weight == 0) {
+		weight = Warning
+	}
+	field(weight, "Weight", want.Weight, got.Weight)
+
+	field(Critical, "FwdMethod", want.FwdMethod, got.FwdMethod)
+	field(Info, "UpperThreshold", want.UpperThreshold, got.UpperThreshold)
+	field(Info, "LowerThreshold", want.LowerThreshold, got.LowerThreshold)
+
+	if want.FwdMethod == ipvs.Tunnel && got.FwdMethod == ipvs.Tunnel {
+		field(Critical, "TunnelType", want.TunnelType, got.TunnelType)
+		field(Critical, "TunnelPort", want.TunnelPort, got.TunnelPort)
+		field(Critical, "TunnelFlags", want.TunnelFlags, got.TunnelFlags)
+	}
+}
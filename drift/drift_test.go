@@ -0,0 +1,234 @@
+package drift
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/netmask"
+	"github.com/cloudflare/ipvs/snapshot"
+	"gotest.tools/v3/assert"
+)
+
+var (
+	web = ipvs.Service{
+		Address:   netip.MustParseAddr("192.0.2.1"),
+		Port:      80,
+		Family:    ipvs.INET,
+		Protocol:  ipvs.TCP,
+		Scheduler: ipvs.WeightedRoundRobin,
+	}
+	dns = ipvs.Service{
+		Address:   netip.MustParseAddr("192.0.2.53"),
+		Port:      53,
+		Family:    ipvs.INET,
+		Protocol:  ipvs.UDP,
+		Scheduler: ipvs.RoundRobin,
+	}
+	backend1 = ipvs.Destination{
+		Address: netip.MustParseAddr("198.51.100.1"),
+		Port:    8080,
+		Family:  ipvs.INET,
+		Weight:  10,
+	}
+	backend2 = ipvs.Destination{
+		Address: netip.MustParseAddr("198.51.100.2"),
+		Port:    8080,
+		Family:  ipvs.INET,
+		Weight:  10,
+	}
+)
+
+// with returns a copy of v changed by f.
+func with[T any](v T, f func(*T)) T {
+	f(&v)
+	return v
+}
+
+func TestCompare(t *testing.T) {
+	type testCase struct {
+		name     string
+		got      *snapshot.Snapshot
+		expected []string
+		max      Severity
+	}
+
+	want := &snapshot.Snapshot{
+		Config: &ipvs.Config{TCPTimeout: 900},
+		Services: []snapshot.Service{
+			{Service: web, Destinations: []ipvs.Destination{backend1, backend2}},
+			{Service: dns},
+		},
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		r := Compare(want, tc.got)
+
+		var got []string
+		for _, d := range r {
+			got = append(got, d.String())
+		}
+		assert.DeepEqual(t, got, tc.expected)
+		assert.Equal(t, r.Max(), tc.max)
+	}
+
+	testCases := []testCase{
+		{
+			name:     "equal",
+			got:      want,
+			expected: nil,
+			max:      -1,
+		},
+		{
+			name: "missing and extra",
+			got: &snapshot.Snapshot{
+				Services: []snapshot.Service{
+					{Service: web, Destinations: []ipvs.Destination{backend2, with(backend1, func(d *ipvs.Destination) { d.Port = 8081 })}},
+				},
+			},
+			expected: []string{
+				"tcp:192.0.2.1:80 198.51.100.1:8080: missing",
+				"tcp:192.0.2.1:80 198.51.100.1:8081: unexpected",
+				"udp:192.0.2.53:53: missing",
+			},
+			max: Critical,
+		},
+		{
+			name: "fields",
+			got: &snapshot.Snapshot{
+				Config: &ipvs.Config{TCPTimeout: 60},
+				Services: []snapshot.Service{
+					{
+						Service: with(web, func(s *ipvs.Service) {
+							s.Scheduler = ipvs.LeastConnection
+							s.Flags = ipvs.ServiceHashed
+						}),
+						Destinations: []ipvs.Destination{
+							with(backend1, func(d *ipvs.Destination) { d.Weight = 20 }),
+							with(backend2, func(d *ipvs.Destination) { d.Weight = 0 }),
+						},
+					},
+					{Service: dns},
+				},
+			},
+			expected: []string{
+				"config: TCPTimeout is 60, want 900",
+				"tcp:192.0.2.1:80: Scheduler is lc, want wrr",
+				"tcp:192.0.2.1:80 198.51.100.1:8080: Weight is 20, want 10",
+				"tcp:192.0.2.1:80 198.51.100.2:8080: Weight is 0, want 10",
+			},
+			max: Warning,
+		},
+		{
+			name: "forwarding",
+			got: &snapshot.Snapshot{
+				Services: []snapshot.Service{
+					{
+						Service: web,
+						Destinations: []ipvs.Destination{
+							backend1,
+							with(backend2, func(d *ipvs.Destination) { d.FwdMethod = ipvs.DirectRoute }),
+						},
+					},
+					{Service: dns},
+				},
+			},
+			expected: []string{
+				"tcp:192.0.2.1:80 198.51.100.2:8080: FwdMethod is DirectRoute, want Masquerade",
+			},
+			max: Critical,
+		},
+		{
+			name: "default netmask",
+			got: &snapshot.Snapshot{
+				Config: &ipvs.Config{TCPTimeout: 900},
+				Services: []snapshot.Service{
+					{
+						Service:      with(web, func(s *ipvs.Service) { s.Netmask = netmask.MaskFrom(32, 32) }),
+						Destinations: []ipvs.Destination{backend1, backend2},
+					},
+					{Service: with(dns, func(s *ipvs.Service) { s.Netmask = netmask.MaskFrom(24, 32) })},
+				},
+			},
+			expected: []string{
+				"udp:192.0.2.53:53: Netmask is 255.255.255.0, want 255.255.255.255",
+			},
+			max: Warning,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestCompare_Daemons(t *testing.T) {
+	master := ipvs.Daemon{State: ipvs.DaemonMaster, Interface: "eth0", SyncID: 1}
+	backup := ipvs.Daemon{State: ipvs.DaemonBackup, Interface: "eth0", SyncID: 1}
+
+	// The kernel reports its defaults for the fields left unset.
+	running := with(master.WithDefaults(), func(d *ipvs.Daemon) { d.MaxLen = 1472 })
+
+	r := Compare(
+		&snapshot.Snapshot{Daemons: []ipvs.Daemon{master}},
+		&snapshot.Snapshot{Daemons: []ipvs.Daemon{running}},
+	)
+	assert.Equal(t, len(r), 0)
+
+	r = Compare(
+		&snapshot.Snapshot{Daemons: []ipvs.Daemon{master, backup}},
+		&snapshot.Snapshot{Daemons: []ipvs.Daemon{with(running, func(d *ipvs.Daemon) { d.Port = 8849 })}},
+	)
+	var got []string
+	for _, d := range r {
+		got = append(got, d.String())
+	}
+	assert.DeepEqual(t, got, []string{
+		"daemon master: Port is 8849, want 8848",
+		"daemon backup: missing",
+	})
+	assert.Equal(t, r.Max(), Warning)
+
+	// Snapshots of Clients which cannot report daemons are not compared.
+	r = Compare(&snapshot.Snapshot{Daemons: []ipvs.Daemon{master}}, &snapshot.Snapshot{})
+	assert.Equal(t, len(r), 0)
+}
+
+func TestCompareHosts(t *testing.T) {
+	a := &snapshot.Snapshot{Services: []snapshot.Service{{Service: web}}}
+	b := &snapshot.Snapshot{Services: []snapshot.Service{{Service: web}, {Service: dns}}}
+
+	r, err := CompareHosts(map[string]*snapshot.Snapshot{"a": a, "b": b, "c": a}, "a")
+	assert.NilError(t, err)
+	assert.Equal(t, len(r), 1)
+	assert.Equal(t, r[0].String(), "b: udp:192.0.2.53:53: unexpected")
+
+	_, err = CompareHosts(map[string]*snapshot.Snapshot{"a": a}, "z")
+	assert.ErrorContains(t, err, `baseline host "z"`)
+}
+
+func TestReport_JSON(t *testing.T) {
+	r := Report{{
+		Kind:        DestinationField,
+		Severity:    Warning,
+		Service:     web.Key(),
+		Destination: backend1.Key(),
+		Field:       "Weight",
+		Want:        "10",
+		Got:         "0",
+	}}
+
+	b, err := json.Marshal(r)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), `[{"kind":"destination-field","severity":"warning","service":"tcp:192.0.2.1:80",`+
+		`"destination":"198.51.100.1:8080","field":"Weight","want":"10","got":"0"}]`)
+
+	var decoded Report
+	assert.NilError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, len(decoded), 1)
+	assert.Equal(t, decoded[0], r[0])
+	assert.Equal(t, len(r.AtLeast(Critical)), 0)
+}
@@ -0,0 +1,96 @@
+package ipvs
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/cloudflare/ipvs/netmask"
+)
+
+// ResolveFamily returns svc with its Family inferred from its Address when
+// unset. IPv4-mapped IPv6 addresses are unmapped, unless Family is INET6.
+//
+// A Family which contradicts the Address is reported as a *FieldError, as
+// is a missing Family on a firewall-mark Service, which has no Address to
+// infer it from.
+func (svc Service) ResolveFamily() (Service, error) {
+	if svc.FWMark != 0 {
+		if svc.Family == 0 {
+			return svc, &FieldError{Field: "Family", Err: errors.New("is required for firewall-mark services")}
+		}
+		return svc, nil
+	}
+
+	family, addr, err := resolveFamily(svc.Family, svc.Address)
+	if err != nil {
+		return svc, err
+	}
+
+	svc.Family, svc.Address = family, addr
+	return svc, nil
+}
+
+// WithDefaults returns svc with its Netmask set to the host mask IPVS uses
+// when none is given, 255.255.255.255 for INET and /128 for INET6, as
+// reported for a configured Service. The Netmask of a Service whose
+// Family cannot be resolved is left unset.
+func (svc Service) WithDefaults() Service {
+	if svc.Netmask.IsValid() {
+		return svc
+	}
+
+	resolved, err := svc.ResolveFamily()
+	if err != nil {
+		return svc
+	}
+
+	switch resolved.Family {
+	case INET:
+		svc.Netmask = netmask.MaskFrom(32, 32)
+	case INET6:
+		svc.Netmask = netmask.MaskFrom(128, 128)
+	}
+
+	return svc
+}
+
+// ResolveFamily returns dest with its Family inferred from its Address when
+// unset. IPv4-mapped IPv6 addresses are unmapped, unless Family is INET6.
+//
+// The Family of a Destination may differ from its Service when using the
+// Tunnel forwarding method, so it is never inferred from the Service.
+// A Family which contradicts the Address is reported as a *FieldError.
+func (dest Destination) ResolveFamily() (Destination, error) {
+	family, addr, err := resolveFamily(dest.Family, dest.Address)
+	if err != nil {
+		return dest, err
+	}
+
+	dest.Family, dest.Address = family, addr
+	return dest, nil
+}
+
+// resolveFamily infers the address family of addr when family is zero,
+// and checks that they agree.
+func resolveFamily(family AddressFamily, addr netip.Addr) (AddressFamily, netip.Addr, error) {
+	if !addr.IsValid() {
+		return family, addr, nil
+	}
+
+	if addr.Is4In6() && family != INET6 {
+		addr = addr.Unmap()
+	}
+
+	inferred := INET6
+	if addr.Is4() {
+		inferred = INET
+	}
+
+	switch family {
+	case 0, inferred:
+		return inferred, addr, nil
+	}
+
+	return family, addr, &FieldError{Field: "Family", Err: fmt.Errorf("%s does not match the address %s", family, addr)}
+}
@@ -0,0 +1,458 @@
+// Package fleet applies the same IPVS configuration to many hosts, such as
+// the directors of an anycast cluster, through a MultiClient.
+package fleet
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/snapshot"
+)
+
+// Host is a member of the fleet.
+type Host struct {
+	// Name identifies the host in results and errors.
+	Name string
+
+	// Client configures the host. It may be local, remote, or fake.
+	Client ipvs.Client
+}
+
+// Mode determines when a change to the fleet succeeds.
+type Mode int
+
+const (
+	// AllOrNothing requires a change to succeed on every host. If it fails
+	// on any, it is rolled back on the hosts where it succeeded.
+	AllOrNothing Mode = iota
+
+	// Quorum requires a change to succeed on at least Options.Quorum hosts.
+	// If it does, failures on other hosts are reported to Options.OnError,
+	// and the change is kept. Otherwise it is rolled back.
+	Quorum
+)
+
+// Options configures a MultiClient.
+type Options struct {
+	// Concurrency limits the hosts changed at once. Defaults to every host.
+	Concurrency int
+
+	// Mode determines when a change succeeds.
+	Mode Mode
+
+	// Quorum is the number of hosts a change must succeed on in the Quorum
+	// mode. Defaults to a majority of the hosts.
+	Quorum int
+
+	// OnError, if set, is called for each host which failed a change that
+	// succeeded on a quorum of hosts.
+	OnError func(host string, err error)
+}
+
+// MultiClient is an ipvs.Client which applies every change to each Host.
+//
+// Reads through the ipvs.Client methods are answered by the first host
+// which succeeds, in order. Gather returns the results of every host, and
+// Divergence reports hosts whose configuration differs.
+type MultiClient struct {
+	hosts []Host
+	opts  Options
+}
+
+var (
+	_ ipvs.Client             = (*MultiClient)(nil)
+	_ ipvs.CapabilitiesClient = (*MultiClient)(nil)
+)
+
+// New returns a MultiClient for hosts.
+func New(hosts []Host, opts Options) *MultiClient {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = len(hosts)
+	}
+	if opts.Quorum <= 0 {
+		opts.Quorum = len(hosts)/2 + 1
+	}
+
+	return &MultiClient{hosts: hosts, opts: opts}
+}
+
+// Hosts returns the names of the hosts, in order.
+func (m *MultiClient) Hosts() []string {
+	names := make([]string, 0, len(m.hosts))
+	for _, h := range m.hosts {
+		names = append(names, h.Name)
+	}
+
+	return names
+}
+
+// Result is the result of reading a single host.
+type Result[T any] struct {
+	Host  string
+	Value T
+	Err   error
+}
+
+// Gather calls read for every host of m concurrently, returning the
+// results in the order of the hosts.
+func Gather[T any](m *MultiClient, read func(ipvs.Client) (T, error)) []Result[T] {
+	results := make([]Result[T], len(m.hosts))
+	m.each(func(i int, h Host) {
+		v, err := read(h.Client)
+		results[i] = Result[T]{Host: h.Name, Value: v, Err: err}
+	})
+
+	return results
+}
+
+// Divergence groups hosts by their configuration.
+type Divergence struct {
+	// Groups lists the names of the hosts sharing each configuration,
+	// largest group first. A fleet in agreement has a single group.
+	Groups [][]string
+
+	// Snapshots holds the configuration of each group.
+	Snapshots []*snapshot.Snapshot
+
+	// Errors holds the hosts which could not be read.
+	Errors map[string]error
+}
+
+// Diverged reports whether the hosts do not share a single configuration,
+// or any could not be read.
+func (d Divergence) Diverged() bool {
+	return len(d.Groups) > 1 || len(d.Errors) > 0
+}
+
+// Divergence reads the configuration of every host, and groups the hosts
+// with equal configurations.
+func (m *MultiClient) Divergence() Divergence {
+	type group struct {
+		hosts []string
+		snap  *snapshot.Snapshot
+	}
+
+	d := Divergence{Errors: make(map[string]error)}
+
+	var groups []*group
+	byDigest := make(map[string]*group)
+	for _, r := range Gather(m, snapshot.Take) {
+		if r.Err != nil {
+			d.Errors[r.Host] = r.Err
+			continue
+		}
+
+		digest := r.Value.Digest()
+		g, ok := byDigest[digest]
+		if !ok {
+			g = &group{snap: r.Value}
+			byDigest[digest] = g
+			groups = append(groups, g)
+		}
+		g.hosts = append(g.hosts, r.Host)
+	}
+
+	slices.SortStableFunc(groups, func(a, b *group) int {
+		return len(b.hosts) - len(a.hosts)
+	})
+	for _, g := range groups {
+		d.Groups = append(d.Groups, g.hosts)
+		d.Snapshots = append(d.Snapshots, g.snap)
+	}
+
+	return d
+}
+
+// Error reports a change which failed on some hosts.
+type Error struct {
+	// Op is the method of the change, such as "CreateService".
+	Op string
+
+	// Failed holds the error of each host the change failed on.
+	Failed map[string]error
+
+	// RolledBack lists the hosts the change was undone on.
+	RolledBack []string
+
+	// RollbackFailed holds the error of each host the change could not
+	// be undone on, which are left changed.
+	RollbackFailed map[string]error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "fleet: %s failed: %s", e.Op, joinErrors(e.Failed))
+	if len(e.RollbackFailed) > 0 {
+		fmt.Fprintf(&b, "; rollback failed: %s", joinErrors(e.RollbackFailed))
+	}
+
+	return b.String()
+}
+
+// Unwrap returns the errors of the failed hosts.
+func (e *Error) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, host := range slices.Sorted(maps.Keys(e.Failed)) {
+		errs = append(errs, e.Failed[host])
+	}
+
+	return errs
+}
+
+// joinErrors formats errors by host, ordered by host.
+func joinErrors(errs map[string]error) string {
+	var parts []string
+	for _, host := range slices.Sorted(maps.Keys(errs)) {
+		parts = append(parts, host+": "+errs[host].Error())
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// each calls f for every host, at most Concurrency at once, and waits for
+// them to return.
+func (m *MultiClient) each(f func(i int, h Host)) {
+	sem := make(chan struct{}, max(m.opts.Concurrency, 1))
+
+	var wg sync.WaitGroup
+	for i, h := range m.hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			f(i, h)
+		}()
+	}
+	wg.Wait()
+}
+
+// A change applies a change to a host, returning a function which undoes it.
+type change func(c ipvs.Client) (undo func() error, err error)
+
+// apply applies ch to every host, rolling it back unless it succeeds
+// according to the Mode.
+func (m *MultiClient) apply(op string, ch change) error {
+	undos := make([]func() error, len(m.hosts))
+	errs := make([]error, len(m.hosts))
+	m.each(func(i int, h Host) {
+		undos[i], errs[i] = ch(h.Client)
+	})
+
+	e := &Error{Op: op, Failed: make(map[string]error)}
+	for i, h := range m.hosts {
+		if errs[i] != nil {
+			e.Failed[h.Name] = errs[i]
+		}
+	}
+
+	switch {
+	case len(e.Failed) == 0:
+		return nil
+	case m.opts.Mode == Quorum && len(m.hosts)-len(e.Failed) >= m.opts.Quorum:
+		if m.opts.OnError != nil {
+			for _, host := range slices.Sorted(maps.Keys(e.Failed)) {
+				m.opts.OnError(host, e.Failed[host])
+			}
+		}
+		return nil
+	}
+
+	var mu sync.Mutex
+	m.each(func(i int, h Host) {
+		if errs[i] != nil {
+			return
+		}
+
+		err := undos[i]()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if e.RollbackFailed == nil {
+				e.RollbackFailed = make(map[string]error)
+			}
+			e.RollbackFailed[h.Name] = err
+			return
+		}
+		e.RolledBack = append(e.RolledBack, h.Name)
+	})
+	slices.Sort(e.RolledBack)
+
+	return e
+}
+
+// first returns the result of the first host read succeeds on.
+func first[T any](m *MultiClient, read func(ipvs.Client) (T, error)) (T, error) {
+	var errs []error
+	for _, h := range m.hosts {
+		v, err := read(h.Client)
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			return v, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", h.Name, err))
+	}
+
+	var zero T
+	if len(errs) == 0 {
+		return zero, errors.New("fleet: no hosts")
+	}
+
+	return zero, errors.Join(errs...)
+}
+
+// Info returns the Info of the first host which answers.
+func (m *MultiClient) Info() (ipvs.Info, error) {
+	return first(m, ipvs.Client.Info)
+}
+
+// Capabilities returns the Capabilities of the first host which answers.
+// Hosts whose Client does not implement ipvs.CapabilitiesClient report
+// an error wrapping errors.ErrUnsupported.
+func (m *MultiClient) Capabilities() (ipvs.Capabilities, error) {
+	return first(m, func(c ipvs.Client) (ipvs.Capabilities, error) {
+		cc, ok := c.(ipvs.CapabilitiesClient)
+		if !ok {
+			return ipvs.Capabilities{}, errors.ErrUnsupported
+		}
+
+		return cc.Capabilities()
+	})
+}
+
+// Config returns the Config of the first host which answers.
+func (m *MultiClient) Config() (ipvs.Config, error) {
+	return first(m, ipvs.Client.Config)
+}
+
+// SetConfig changes the Config of every host.
+func (m *MultiClient) SetConfig(config ipvs.Config) error {
+	return m.apply("SetConfig", func(c ipvs.Client) (func() error, error) {
+		prev, err := c.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		return func() error { return c.SetConfig(prev) }, c.SetConfig(config)
+	})
+}
+
+// Services returns the Services of the first host which answers.
+func (m *MultiClient) Services() ([]ipvs.ServiceExtended, error) {
+	return first(m, ipvs.Client.Services)
+}
+
+// Service returns the Service from the first host which answers.
+func (m *MultiClient) Service(svc ipvs.Service) (ipvs.ServiceExtended, error) {
+	return first(m, func(c ipvs.Client) (ipvs.ServiceExtended, error) {
+		return c.Service(svc)
+	})
+}
+
+// CreateService creates svc on every host.
+func (m *MultiClient) CreateService(svc ipvs.Service) error {
+	return m.apply("CreateService", func(c ipvs.Client) (func() error, error) {
+		return func() error { return c.RemoveService(svc) }, c.CreateService(svc)
+	})
+}
+
+// UpdateService updates svc on every host.
+func (m *MultiClient) UpdateService(svc ipvs.Service) error {
+	return m.apply("UpdateService", func(c ipvs.Client) (func() error, error) {
+		prev, err := c.Service(svc)
+		if err != nil {
+			return nil, err
+		}
+
+		return func() error { return c.UpdateService(prev.Service) }, c.UpdateService(svc)
+	})
+}
+
+// RemoveService removes svc, and its Destinations, from every host. If
+// rolled back, the Service is recreated with its Destinations.
+func (m *MultiClient) RemoveService(svc ipvs.Service) error {
+	return m.apply("RemoveService", func(c ipvs.Client) (func() error, error) {
+		prev, err := c.Service(svc)
+		if err != nil {
+			return nil, err
+		}
+		dests, err := c.Destinations(svc)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		undo := func() error {
+			if err := c.CreateService(prev.Service); err != nil {
+				return err
+			}
+			for _, d := range dests {
+				if err := c.CreateDestination(prev.Service, d.Destination); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		return undo, c.RemoveService(svc)
+	})
+}
+
+// Destinations returns the Destinations of svc from the first host which answers.
+func (m *MultiClient) Destinations(svc ipvs.Service) ([]ipvs.DestinationExtended, error) {
+	return first(m, func(c ipvs.Client) ([]ipvs.DestinationExtended, error) {
+		return c.Destinations(svc)
+	})
+}
+
+// CreateDestination creates dest on every host.
+func (m *MultiClient) CreateDestination(svc ipvs.Service, dest ipvs.Destination) error {
+	return m.apply("CreateDestination", func(c ipvs.Client) (func() error, error) {
+		return func() error { return c.RemoveDestination(svc, dest) }, c.CreateDestination(svc, dest)
+	})
+}
+
+// UpdateDestination updates dest on every host.
+func (m *MultiClient) UpdateDestination(svc ipvs.Service, dest ipvs.Destination) error {
+	return m.apply("UpdateDestination", func(c ipvs.Client) (func() error, error) {
+		prev, err := destination(c, svc, dest)
+		if err != nil {
+			return nil, err
+		}
+
+		return func() error { return c.UpdateDestination(svc, prev) }, c.UpdateDestination(svc, dest)
+	})
+}
+
+// RemoveDestination removes dest from every host.
+func (m *MultiClient) RemoveDestination(svc ipvs.Service, dest ipvs.Destination) error {
+	return m.apply("RemoveDestination", func(c ipvs.Client) (func() error, error) {
+		prev, err := destination(c, svc, dest)
+		if err != nil {
+			return nil, err
+		}
+
+		return func() error { return c.CreateDestination(svc, prev) }, c.RemoveDestination(svc, dest)
+	})
+}
+
+// destination returns the current configuration of dest.
+func destination(c ipvs.Client, svc ipvs.Service, dest ipvs.Destination) (ipvs.Destination, error) {
+	dests, err := c.Destinations(svc)
+	if err != nil {
+		return ipvs.Destination{}, err
+	}
+
+	for _, d := range dests {
+		if d.Key() == dest.Key() {
+			return d.Destination, nil
+		}
+	}
+
+	return ipvs.Destination{}, fs.ErrNotExist
+}
@@ -0,0 +1,178 @@
+package fleet
+
+import (
+	"errors"
+	"io/fs"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/ipvstest"
+	"gotest.tools/v3/assert"
+)
+
+var (
+	svc = ipvs.Service{
+		Address:   netip.MustParseAddr("192.0.2.1"),
+		Port:      80,
+		Family:    ipvs.INET,
+		Protocol:  ipvs.TCP,
+		Scheduler: ipvs.RoundRobin,
+	}
+	dest = ipvs.Destination{
+		Address: netip.MustParseAddr("198.51.100.1"),
+		Port:    8080,
+		Family:  ipvs.INET,
+		Weight:  10,
+	}
+)
+
+var errDown = errors.New("host is down")
+
+// downClient fails every change.
+type downClient struct {
+	ipvs.Client
+}
+
+func (downClient) CreateService(ipvs.Service) error { return errDown }
+
+func (downClient) UpdateDestination(ipvs.Service, ipvs.Destination) error { return errDown }
+
+// newFleet returns a MultiClient for n fake hosts, the last down of which
+// fail every change.
+func newFleet(n, down int, opts Options) (*MultiClient, []*ipvstest.Client) {
+	var hosts []Host
+	var fakes []*ipvstest.Client
+	for i := range n {
+		c := ipvstest.New()
+		fakes = append(fakes, c)
+
+		var client ipvs.Client = c
+		if i >= n-down {
+			client = downClient{c}
+		}
+		hosts = append(hosts, Host{Name: string(rune('a' + i)), Client: client})
+	}
+
+	return New(hosts, opts), fakes
+}
+
+func TestMultiClient(t *testing.T) {
+	m, fakes := newFleet(3, 0, Options{Concurrency: 2})
+	assert.DeepEqual(t, m.Hosts(), []string{"a", "b", "c"})
+
+	assert.NilError(t, m.CreateService(svc))
+	assert.NilError(t, m.CreateDestination(svc, dest))
+
+	updated := dest
+	updated.Weight = 20
+	assert.NilError(t, m.UpdateDestination(svc, updated))
+
+	for _, c := range fakes {
+		dests, err := c.Destinations(svc)
+		assert.NilError(t, err)
+		assert.Equal(t, dests[0].Weight, uint32(20))
+	}
+
+	assert.ErrorIs(t, m.CreateService(svc), fs.ErrExist)
+
+	assert.NilError(t, m.RemoveService(svc))
+	_, err := m.Service(svc)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestMultiClient_AllOrNothing(t *testing.T) {
+	m, fakes := newFleet(3, 1, Options{})
+
+	err := m.CreateService(svc)
+	assert.ErrorIs(t, err, errDown)
+
+	var e *Error
+	assert.Assert(t, errors.As(err, &e))
+	assert.Equal(t, e.Op, "CreateService")
+	assert.DeepEqual(t, e.RolledBack, []string{"a", "b"})
+	assert.Error(t, err, "fleet: CreateService failed: c: host is down")
+
+	for _, c := range fakes {
+		_, err := c.Service(svc)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	}
+}
+
+func TestMultiClient_RollbackUpdate(t *testing.T) {
+	m, fakes := newFleet(3, 1, Options{})
+	for _, c := range fakes {
+		assert.NilError(t, c.CreateService(svc))
+		assert.NilError(t, c.CreateDestination(svc, dest))
+	}
+
+	updated := dest
+	updated.Weight = 0
+	assert.ErrorIs(t, m.UpdateDestination(svc, updated), errDown)
+
+	for _, c := range fakes {
+		dests, err := c.Destinations(svc)
+		assert.NilError(t, err)
+		assert.Equal(t, dests[0].Weight, dest.Weight)
+	}
+}
+
+func TestMultiClient_Quorum(t *testing.T) {
+	var reported atomic.Int32
+	opts := Options{
+		Mode: Quorum,
+		OnError: func(host string, err error) {
+			assert.Equal(t, host, "e")
+			assert.ErrorIs(t, err, errDown)
+			reported.Add(1)
+		},
+	}
+
+	m, fakes := newFleet(5, 1, opts)
+	assert.NilError(t, m.CreateService(svc))
+	assert.Equal(t, reported.Load(), int32(1))
+	for _, c := range fakes[:4] {
+		_, err := c.Service(svc)
+		assert.NilError(t, err)
+	}
+
+	m, fakes = newFleet(5, 3, opts)
+	var e *Error
+	assert.Assert(t, errors.As(m.CreateService(svc), &e))
+	assert.Equal(t, len(e.Failed), 3)
+	assert.DeepEqual(t, e.RolledBack, []string{"a", "b"})
+	for _, c := range fakes {
+		_, err := c.Service(svc)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	}
+}
+
+func TestGather(t *testing.T) {
+	m, fakes := newFleet(2, 0, Options{})
+	assert.NilError(t, fakes[1].CreateService(svc))
+
+	results := Gather(m, ipvs.Client.Services)
+	assert.Equal(t, len(results), 2)
+	assert.Equal(t, results[0].Host, "a")
+	assert.ErrorIs(t, results[0].Err, fs.ErrNotExist)
+	assert.Equal(t, results[1].Host, "b")
+	assert.NilError(t, results[1].Err)
+	assert.Equal(t, len(results[1].Value), 1)
+}
+
+func TestDivergence(t *testing.T) {
+	m, fakes := newFleet(3, 0, Options{})
+	assert.NilError(t, m.CreateService(svc))
+
+	d := m.Divergence()
+	assert.Assert(t, !d.Diverged())
+	assert.DeepEqual(t, d.Groups, [][]string{{"a", "b", "c"}})
+
+	assert.NilError(t, fakes[1].CreateDestination(svc, dest))
+
+	d = m.Divergence()
+	assert.Assert(t, d.Diverged())
+	assert.DeepEqual(t, d.Groups, [][]string{{"a", "c"}, {"b"}})
+	assert.Equal(t, len(d.Snapshots[1].Services[0].Destinations), 1)
+}
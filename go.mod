@@ -6,8 +6,10 @@ require (
 	github.com/google/go-cmp v0.6.0
 	github.com/mdlayher/genetlink v1.3.2
 	github.com/mdlayher/netlink v1.8.0
+	golang.org/x/sys v0.43.0
 	gotest.tools/v3 v3.4.0
 	pgregory.net/rapid v1.1.0
+	sigs.k8s.io/yaml v1.6.0
 )
 
 require (
@@ -17,10 +19,10 @@ require (
 	github.com/tj/go-spin v1.1.0 // indirect
 	github.com/xlab/c-for-go v1.3.0 // indirect
 	github.com/xlab/pkgconfig v0.0.0-20170226114623-cea12a0fd245 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
 	golang.org/x/mod v0.35.0 // indirect
 	golang.org/x/net v0.53.0 // indirect
 	golang.org/x/sync v0.20.0 // indirect
-	golang.org/x/sys v0.43.0 // indirect
 	golang.org/x/tools v0.44.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	modernc.org/cc/v4 v4.21.4 // indirect
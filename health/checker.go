@@ -0,0 +1,230 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// Checker probes a Destination. A nil error means the Destination is healthy.
+//
+// Check must return promptly once ctx is done.
+type Checker interface {
+	Check(ctx context.Context, dest ipvs.Destination) error
+}
+
+// CheckerFunc adapts a function to the Checker interface.
+type CheckerFunc func(ctx context.Context, dest ipvs.Destination) error
+
+// Check calls f(ctx, dest).
+func (f CheckerFunc) Check(ctx context.Context, dest ipvs.Destination) error {
+	return f(ctx, dest)
+}
+
+// TCPChecker reports a Destination as healthy when a TCP connection
+// can be established.
+type TCPChecker struct {
+	// Port overrides the port of the Destination, if set.
+	Port uint16
+}
+
+// Check implements Checker.
+func (c TCPChecker) Check(ctx context.Context, dest ipvs.Destination) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address(dest, c.Port))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// HTTPChecker reports a Destination as healthy when a GET request returns
+// the expected status code and body.
+//
+// Connections are kept open between checks. The fields must not be
+// changed once the HTTPChecker is in use.
+type HTTPChecker struct {
+	// Port overrides the port of the Destination, if set.
+	Port uint16
+
+	// TLS enables HTTPS, using TLSConfig if set.
+	TLS       bool
+	TLSConfig *tls.Config
+
+	// Path is the request path. It defaults to "/".
+	Path string
+
+	// Host overrides the Host header, and the server name of TLS
+	// connections unless TLSConfig sets one.
+	Host string
+
+	// Status is the expected status code. It defaults to http.StatusOK.
+	Status int
+
+	// Body, if set, must be contained in the response body.
+	Body []byte
+
+	once   sync.Once
+	client *http.Client
+}
+
+// maxBody limits how much of a response HTTPChecker reads.
+const maxBody = 1 << 20
+
+// httpIdleTimeout is how long an HTTPChecker keeps an idle connection.
+const httpIdleTimeout = 2 * DefaultInterval
+
+// init builds the client of c, the first time it is used.
+func (c *HTTPChecker) init() {
+	c.once.Do(func() {
+		config := c.TLSConfig
+		if c.TLS && c.Host != "" && (config == nil || config.ServerName == "") {
+			if config == nil {
+				config = &tls.Config{}
+			} else {
+				config = config.Clone()
+			}
+			config.ServerName = c.Host
+			if host, _, err := net.SplitHostPort(c.Host); err == nil {
+				config.ServerName = host
+			}
+		}
+
+		c.client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: config,
+				IdleConnTimeout: httpIdleTimeout,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	})
+}
+
+// CloseIdleConnections closes the connections kept open between checks.
+func (c *HTTPChecker) CloseIdleConnections() {
+	c.init()
+	c.client.CloseIdleConnections()
+}
+
+// Check implements Checker.
+func (c *HTTPChecker) Check(ctx context.Context, dest ipvs.Destination) error {
+	c.init()
+
+	scheme := "http"
+	if c.TLS {
+		scheme = "https"
+	}
+
+	path := c.Path
+	if path == "" {
+		path = "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+address(dest, c.Port)+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.Host != "" {
+		req.Host = c.Host
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Draining the body lets the connection be reused.
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxBody))
+		resp.Body.Close()
+	}()
+
+	status := c.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if resp.StatusCode != status {
+		return fmt.Errorf("health: unexpected status %d, want %d", resp.StatusCode, status)
+	}
+
+	if len(c.Body) > 0 {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Contains(body, c.Body) {
+			return errors.New("health: response body does not contain expected content")
+		}
+	}
+
+	return nil
+}
+
+// UDPChecker reports a Destination as healthy when it responds to
+// a request datagram.
+type UDPChecker struct {
+	// Port overrides the port of the Destination, if set.
+	Port uint16
+
+	// Request is the payload sent to the Destination.
+	Request []byte
+
+	// Response, if set, must be contained in the reply.
+	Response []byte
+}
+
+// Check implements Checker.
+func (c UDPChecker) Check(ctx context.Context, dest ipvs.Destination) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", address(dest, c.Port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	if _, err := conn.Write(c.Request); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64*1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	if len(c.Response) > 0 && !bytes.Contains(buf[:n], c.Response) {
+		return errors.New("health: response does not contain expected content")
+	}
+
+	return nil
+}
+
+// address returns the host:port of dest, with port taking precedence
+// over the port of dest if set.
+func address(dest ipvs.Destination, port uint16) string {
+	if port == 0 {
+		port = dest.Port
+	}
+
+	return netip.AddrPortFrom(dest.Address.Unmap(), port).String()
+}
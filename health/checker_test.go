@@ -0,0 +1,185 @@
+package health
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"gotest.tools/v3/assert"
+)
+
+func TestTCPChecker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	dest := destinationOf(t, ln.Addr().String())
+	assert.NilError(t, TCPChecker{}.Check(context.Background(), dest))
+
+	ln.Close()
+	assert.Assert(t, TCPChecker{}.Check(context.Background(), dest) != nil)
+}
+
+func TestHTTPChecker(t *testing.T) {
+	type testCase struct {
+		name    string
+		checker *HTTPChecker
+		ok      bool
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			io.WriteString(w, "status: ok\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tls := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(tls.Close)
+
+	run := func(t *testing.T, tc testCase, addr string) {
+		err := tc.checker.Check(context.Background(), destinationOf(t, addr))
+		if tc.ok {
+			assert.NilError(t, err)
+		} else {
+			assert.Assert(t, err != nil)
+		}
+	}
+
+	testCases := []testCase{
+		{
+			name:    "status",
+			checker: &HTTPChecker{Path: "/healthz"},
+			ok:      true,
+		},
+		{
+			name:    "body",
+			checker: &HTTPChecker{Path: "/healthz", Body: []byte("status: ok")},
+			ok:      true,
+		},
+		{
+			name:    "unexpected body",
+			checker: &HTTPChecker{Path: "/healthz", Body: []byte("status: degraded")},
+		},
+		{
+			name:    "unexpected status",
+			checker: &HTTPChecker{Path: "/missing"},
+		},
+		{
+			name:    "expected status",
+			checker: &HTTPChecker{Path: "/missing", Status: http.StatusNotFound},
+			ok:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc, srv.Listener.Addr().String())
+		})
+	}
+
+	t.Run("https", func(t *testing.T) {
+		run(t, testCase{
+			checker: &HTTPChecker{
+				TLS:       true,
+				TLSConfig: tls.Client().Transport.(*http.Transport).TLSClientConfig,
+			},
+			ok: true,
+		}, tls.Listener.Addr().String())
+	})
+}
+
+func TestHTTPChecker_Connections(t *testing.T) {
+	var conns atomic.Int32
+	var serverName atomic.Value
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverName.Store(r.TLS.ServerName)
+		io.WriteString(w, "ok")
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	// The certificate of the test server is valid for example.com.
+	checker := &HTTPChecker{
+		TLS:       true,
+		TLSConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig,
+		Host:      "example.com:443",
+	}
+	t.Cleanup(checker.CloseIdleConnections)
+
+	dest := destinationOf(t, srv.Listener.Addr().String())
+	for range 3 {
+		assert.NilError(t, checker.Check(context.Background(), dest))
+	}
+	assert.Equal(t, serverName.Load(), "example.com")
+	assert.Equal(t, conns.Load(), int32(1))
+}
+
+func TestUDPChecker(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == "ping" {
+				conn.WriteTo([]byte("pong"), addr)
+			}
+		}
+	}()
+
+	dest := destinationOf(t, conn.LocalAddr().String())
+
+	checker := UDPChecker{Request: []byte("ping"), Response: []byte("pong")}
+	assert.NilError(t, checker.Check(context.Background(), dest))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	checker = UDPChecker{Request: []byte("hello")}
+	assert.ErrorIs(t, checker.Check(ctx, dest), context.DeadlineExceeded)
+}
+
+func destinationOf(t *testing.T, addr string) ipvs.Destination {
+	t.Helper()
+
+	ap, err := netip.ParseAddrPort(addr)
+	assert.NilError(t, err)
+
+	return ipvs.Destination{
+		Address: ap.Addr(),
+		Port:    ap.Port(),
+		Family:  ipvs.INET,
+		Weight:  1,
+	}
+}
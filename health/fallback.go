@@ -0,0 +1,288 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// FallbackOptions configures a Fallback.
+type FallbackOptions struct {
+	// Interval between evaluations of a Service. Defaults to DefaultInterval.
+	Interval time.Duration
+
+	// Healthy, if set, reports whether a Destination is healthy, such as
+	// Monitor.Healthy. Destinations with a weight of zero are never
+	// counted as capacity, so a Monitor which inhibits unhealthy
+	// Destinations needs no Healthy function.
+	Healthy func(ipvs.Service, ipvs.Destination) bool
+
+	// Fall is the number of consecutive evaluations without capacity
+	// before the fallback is added. Defaults to 1.
+	Fall int
+
+	// Rise is the number of consecutive evaluations with capacity before
+	// the fallback is removed. Defaults to 1.
+	Rise int
+
+	// Hold is the least time the fallback is kept once added, even if
+	// capacity returns.
+	Hold time.Duration
+
+	// OnChange, if set, is called whenever a fallback is added or removed.
+	OnChange func(FallbackStatus)
+
+	// OnError, if set, is called when a fallback could not be added or
+	// removed. The change is retried after the next evaluation.
+	OnError func(FallbackStatus, error)
+}
+
+// FallbackTarget is a Service and its fallback Destination, like the
+// sorry_server of keepalived.
+type FallbackTarget struct {
+	Service ipvs.Service
+
+	// Destination is added when the Service has no capacity. A weight of
+	// zero is added as one.
+	Destination ipvs.Destination
+
+	// Local forwards to the Destination with Local forwarding, such as
+	// for a maintenance page served by the load balancer itself.
+	Local bool
+}
+
+// FallbackStatus is the state of a FallbackTarget.
+type FallbackStatus struct {
+	FallbackTarget
+
+	// Active is set while the fallback Destination is configured.
+	Active bool
+
+	// Since is when Active last changed.
+	Since time.Time
+}
+
+// Fallback adds a fallback Destination to Services when every other
+// Destination is unhealthy or has a weight of zero, and removes it once
+// capacity returns.
+//
+// A fallback found configured when the Fallback starts is considered
+// active, so it is removed once the Service has capacity.
+type Fallback struct {
+	client ipvs.Client
+	opts   FallbackOptions
+
+	mu     sync.Mutex
+	status map[ipvs.ServiceKey]*FallbackStatus
+}
+
+// NewFallback returns a Fallback applying changes with c.
+func NewFallback(c ipvs.Client, opts FallbackOptions) *Fallback {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.Rise <= 0 {
+		opts.Rise = 1
+	}
+	if opts.Fall <= 0 {
+		opts.Fall = 1
+	}
+
+	return &Fallback{
+		client: c,
+		opts:   opts,
+		status: make(map[ipvs.ServiceKey]*FallbackStatus),
+	}
+}
+
+// Run evaluates each of the targets until ctx is done, then returns
+// ctx.Err(). Active fallbacks are left in place. If the address family of
+// the Service of a target cannot be resolved, Run returns an error without
+// evaluating any.
+func (f *Fallback) Run(ctx context.Context, targets []FallbackTarget) error {
+	for i, t := range targets {
+		if _, err := ipvs.KeyOf(t.Service); err != nil {
+			return fmt.Errorf("health: target %d: %w", i, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		t.Destination = t.destination()
+
+		f.mu.Lock()
+		f.status[t.Service.Key()] = &FallbackStatus{FallbackTarget: t, Since: time.Now()}
+		f.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.run(ctx, t)
+		}()
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Status returns the FallbackStatus of a Service.
+func (f *Fallback) Status(svc ipvs.Service) (FallbackStatus, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.status[svc.Key()]
+	if !ok {
+		return FallbackStatus{}, false
+	}
+
+	return *s, true
+}
+
+// Active reports whether the fallback of a Service is active.
+func (f *Fallback) Active(svc ipvs.Service) bool {
+	s, ok := f.Status(svc)
+	return ok && s.Active
+}
+
+// destination returns the fallback Destination to configure.
+func (t FallbackTarget) destination() ipvs.Destination {
+	dest := t.Destination
+	if dest.Weight == 0 {
+		dest.Weight = 1
+	}
+	if t.Local {
+		dest.FwdMethod = ipvs.Local
+	}
+
+	return dest
+}
+
+// run evaluates t every interval until ctx is done.
+func (f *Fallback) run(ctx context.Context, t FallbackTarget) {
+	ticker := time.NewTicker(f.opts.Interval)
+	defer ticker.Stop()
+
+	var h hysteresis
+	for {
+		f.evaluate(t, &h)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// hysteresis tracks consecutive evaluations of a FallbackTarget.
+type hysteresis struct {
+	up   int
+	down int
+}
+
+// evaluate checks the capacity of the Service of t, and adds or removes
+// its fallback.
+func (f *Fallback) evaluate(t FallbackTarget, h *hysteresis) {
+	present, capacity, err := f.capacity(t)
+	if err != nil {
+		f.fail(t, err)
+		return
+	}
+
+	if capacity {
+		h.up++
+		h.down = 0
+	} else {
+		h.down++
+		h.up = 0
+	}
+
+	f.mu.Lock()
+	s := f.status[t.Service.Key()]
+	if present != s.Active {
+		// The table changed under us, or we have just started.
+		s.Active = present
+		s.Since = time.Now()
+	}
+	held := time.Since(s.Since) < f.opts.Hold
+	f.mu.Unlock()
+
+	switch {
+	case !present && h.down >= f.opts.Fall:
+		err = f.add(t)
+	case present && h.up >= f.opts.Rise && !held:
+		err = f.remove(t)
+	default:
+		return
+	}
+	if err != nil {
+		f.fail(t, err)
+		return
+	}
+
+	f.mu.Lock()
+	s.Active = !present
+	s.Since = time.Now()
+	status := *s
+	f.mu.Unlock()
+
+	if f.opts.OnChange != nil {
+		f.opts.OnChange(status)
+	}
+}
+
+// capacity reports whether the fallback of t is configured, and whether
+// any other Destination of its Service is healthy with a weight.
+func (f *Fallback) capacity(t FallbackTarget) (present, capacity bool, err error) {
+	dests, err := f.client.Destinations(t.Service)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, false, err
+	}
+
+	for _, d := range dests {
+		switch {
+		case d.Key() == t.Destination.Key():
+			present = true
+		case d.Weight == 0:
+		case f.opts.Healthy == nil || f.opts.Healthy(t.Service, d.Destination):
+			capacity = true
+		}
+	}
+
+	return present, capacity, nil
+}
+
+// add configures the fallback of t.
+func (f *Fallback) add(t FallbackTarget) error {
+	err := f.client.CreateDestination(t.Service, t.Destination)
+	if errors.Is(err, fs.ErrExist) {
+		return f.client.UpdateDestination(t.Service, t.Destination)
+	}
+
+	return err
+}
+
+// remove deletes the fallback of t.
+func (f *Fallback) remove(t FallbackTarget) error {
+	err := f.client.RemoveDestination(t.Service, t.Destination)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// fail reports err for t.
+func (f *Fallback) fail(t FallbackTarget, err error) {
+	if f.opts.OnError == nil {
+		return
+	}
+
+	status, _ := f.Status(t.Service)
+	f.opts.OnError(status, err)
+}
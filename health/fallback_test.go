@@ -0,0 +1,170 @@
+package health
+
+import (
+	"context"
+	"io/fs"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/ipvstest"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+var sorryDestination = ipvs.Destination{
+	Address: netip.MustParseAddr("203.0.113.1"),
+	Port:    8080,
+	Family:  ipvs.INET,
+}
+
+// fallbackTable returns a Client with testService and testDestination.
+func fallbackTable(t *testing.T) *ipvstest.Client {
+	c := ipvstest.New()
+	assert.NilError(t, c.CreateService(testService))
+	assert.NilError(t, c.CreateDestination(testService, testDestination))
+	return c
+}
+
+// newFallback returns a Fallback for the target, ready to evaluate it.
+func newFallback(c ipvs.Client, target FallbackTarget, opts FallbackOptions) (*Fallback, FallbackTarget) {
+	f := NewFallback(c, opts)
+	target.Destination = target.destination()
+	f.status[target.Service.Key()] = &FallbackStatus{FallbackTarget: target}
+	return f, target
+}
+
+// setWeight changes the weight of testDestination in c.
+func setWeight(t *testing.T, c *ipvstest.Client, weight uint32) {
+	dest := testDestination
+	dest.Weight = weight
+	assert.NilError(t, c.UpdateDestination(testService, dest))
+}
+
+// fallbackPresent returns the fallback Destination configured in c, if any.
+func fallbackPresent(t *testing.T, c *ipvstest.Client) (ipvs.Destination, bool) {
+	dests, err := c.Destinations(testService)
+	assert.NilError(t, err)
+	for _, d := range dests {
+		if d.Key() == sorryDestination.Key() {
+			return d.Destination, true
+		}
+	}
+
+	return ipvs.Destination{}, false
+}
+
+func TestFallback_Hysteresis(t *testing.T) {
+	c := fallbackTable(t)
+
+	var changes []bool
+	f, target := newFallback(c, FallbackTarget{Service: testService, Destination: sorryDestination, Local: true}, FallbackOptions{
+		Rise:     3,
+		Fall:     2,
+		OnChange: func(s FallbackStatus) { changes = append(changes, s.Active) },
+	})
+
+	var h hysteresis
+	step := func(weight uint32, active bool) {
+		t.Helper()
+		setWeight(t, c, weight)
+		f.evaluate(target, &h)
+		_, present := fallbackPresent(t, c)
+		assert.Equal(t, present, active)
+		assert.Equal(t, f.Active(testService), active)
+	}
+
+	step(10, false)
+	step(0, false)
+	step(0, true)
+
+	dest, _ := fallbackPresent(t, c)
+	assert.Equal(t, dest.FwdMethod, ipvs.Local)
+	assert.Equal(t, dest.Weight, uint32(1))
+
+	// A brief return of capacity does not remove the fallback.
+	step(10, true)
+	step(10, true)
+	step(0, true)
+	step(10, true)
+	step(10, true)
+	step(10, false)
+
+	assert.DeepEqual(t, changes, []bool{true, false})
+}
+
+func TestFallback_Healthy(t *testing.T) {
+	c := fallbackTable(t)
+
+	healthy := false
+	f, target := newFallback(c, FallbackTarget{Service: testService, Destination: sorryDestination}, FallbackOptions{
+		Healthy: func(ipvs.Service, ipvs.Destination) bool { return healthy },
+	})
+
+	var h hysteresis
+	f.evaluate(target, &h)
+	_, present := fallbackPresent(t, c)
+	assert.Assert(t, present)
+
+	healthy = true
+	f.evaluate(target, &h)
+	_, present = fallbackPresent(t, c)
+	assert.Assert(t, !present)
+}
+
+func TestFallback_Hold(t *testing.T) {
+	c := fallbackTable(t)
+	setWeight(t, c, 0)
+
+	var errs []error
+	f, target := newFallback(c, FallbackTarget{Service: testService, Destination: sorryDestination}, FallbackOptions{
+		Hold:    time.Hour,
+		OnError: func(_ FallbackStatus, err error) { errs = append(errs, err) },
+	})
+
+	var h hysteresis
+	f.evaluate(target, &h)
+	setWeight(t, c, 10)
+	f.evaluate(target, &h)
+	assert.Assert(t, f.Active(testService))
+
+	// Failures to add the fallback are reported.
+	assert.NilError(t, c.RemoveService(testService))
+	f.evaluate(target, &h)
+	assert.Equal(t, len(errs), 1)
+	assert.ErrorIs(t, errs[0], fs.ErrNotExist)
+}
+
+func TestFallback_Run(t *testing.T) {
+	c := fallbackTable(t)
+
+	// A fallback left configured is removed once capacity is seen.
+	assert.NilError(t, c.CreateDestination(testService, sorryDestination))
+
+	f := NewFallback(c, FallbackOptions{Interval: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- f.Run(ctx, []FallbackTarget{{Service: testService, Destination: sorryDestination}})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+	})
+
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		if _, present := fallbackPresent(t, c); present {
+			return poll.Continue("fallback not removed")
+		}
+		return poll.Success()
+	})
+
+	setWeight(t, c, 0)
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		if !f.Active(testService) {
+			return poll.Continue("fallback not added")
+		}
+		return poll.Success()
+	})
+}
@@ -0,0 +1,330 @@
+// Package health actively checks IPVS destinations, and reflects the
+// results into their weights through an ipvs.Client.
+//
+// A Destination which fails Fall consecutive checks is inhibited (its
+// weight is set to zero) or removed from its Service. Once it passes Rise
+// consecutive checks, it is restored with its configured weight.
+//
+// A Fallback adds a sorry server to a Service which has no healthy
+// Destinations left, and removes it once capacity returns. A Quorum
+// withdraws or drains a Service with too few healthy Destinations, and
+// runs hooks when it loses or regains quorum.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// State is the health of a Destination.
+type State int
+
+// Destination health states.
+const (
+	Unknown State = iota
+	Healthy
+	Unhealthy
+)
+
+// String returns a human readable representation of the state.
+func (s State) String() string {
+	switch s {
+	case Unknown:
+		return "unknown"
+	case Healthy:
+		return "healthy"
+	case Unhealthy:
+		return "unhealthy"
+	}
+
+	return "invalid"
+}
+
+// Action determines what happens to an unhealthy Destination.
+type Action int
+
+const (
+	// Inhibit keeps the Destination, with its weight set to zero. Existing
+	// connections continue to be served, but no new connections are scheduled.
+	Inhibit Action = iota
+
+	// Remove deletes the Destination from its Service.
+	Remove
+)
+
+// Default Options values.
+const (
+	DefaultInterval = 5 * time.Second
+	DefaultTimeout  = 2 * time.Second
+)
+
+// Options configures a Monitor.
+type Options struct {
+	// Interval between checks of a Destination. Defaults to DefaultInterval.
+	Interval time.Duration
+
+	// Timeout of a single check. Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	// Rise is the number of consecutive successful checks before an
+	// unhealthy Destination is restored. Defaults to 1.
+	Rise int
+
+	// Fall is the number of consecutive failed checks before a healthy
+	// Destination is inhibited or removed. Defaults to 1.
+	Fall int
+
+	// Action taken on unhealthy Destinations.
+	Action Action
+
+	// Drained, if set, reports whether a Service is drained by another
+	// controller, such as Quorum.Drained, which owns the weights of its
+	// Destinations meanwhile. Changes of State are applied once it is no
+	// longer drained.
+	Drained func(ipvs.Service) bool
+
+	// OnChange, if set, is called whenever a Destination changes State.
+	OnChange func(Status)
+
+	// OnError, if set, is called when the result of a check could not be
+	// applied through the Client. The change is retried after the next check.
+	OnError func(Status, error)
+}
+
+// Target is a Destination to be checked.
+type Target struct {
+	Service ipvs.Service
+
+	// Destination is the configuration restored when the Destination is
+	// healthy, including its weight.
+	Destination ipvs.Destination
+
+	Checker Checker
+}
+
+// Status is the result of checking a Target.
+type Status struct {
+	Target
+
+	State State
+
+	// Err is the error returned by the most recent check.
+	Err error
+
+	// Since is when State last changed.
+	Since time.Time
+}
+
+// Monitor checks Targets, and updates their weights through a Client.
+type Monitor struct {
+	client ipvs.Client
+	opts   Options
+
+	mu     sync.Mutex
+	status map[targetKey]*Status
+}
+
+// NewMonitor returns a Monitor applying changes with c.
+func NewMonitor(c ipvs.Client, opts Options) *Monitor {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Rise <= 0 {
+		opts.Rise = 1
+	}
+	if opts.Fall <= 0 {
+		opts.Fall = 1
+	}
+
+	return &Monitor{
+		client: c,
+		opts:   opts,
+		status: make(map[targetKey]*Status),
+	}
+}
+
+// Run checks each of the targets until ctx is done, then returns ctx.Err().
+// If the address family of the Service of a target cannot be resolved,
+// Run returns an error without checking any.
+func (m *Monitor) Run(ctx context.Context, targets []Target) error {
+	for i, t := range targets {
+		if _, err := ipvs.KeyOf(t.Service); err != nil {
+			return fmt.Errorf("health: target %d: %w", i, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		m.mu.Lock()
+		m.status[keyOf(t.Service, t.Destination)] = &Status{Target: t, Since: time.Now()}
+		m.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.run(ctx, t)
+		}()
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Status returns the Status of the Destination of a Service.
+func (m *Monitor) Status(svc ipvs.Service, dest ipvs.Destination) (Status, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.status[keyOf(svc, dest)]
+	if !ok {
+		return Status{}, false
+	}
+
+	return *s, true
+}
+
+// Statuses returns the Status of every Target.
+func (m *Monitor) Statuses() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]Status, 0, len(m.status))
+	for _, s := range m.status {
+		statuses = append(statuses, *s)
+	}
+
+	return statuses
+}
+
+// Healthy reports whether the Destination of a Service is Healthy.
+func (m *Monitor) Healthy(svc ipvs.Service, dest ipvs.Destination) bool {
+	s, ok := m.Status(svc, dest)
+	return ok && s.State == Healthy
+}
+
+// run checks t every interval until ctx is done.
+func (m *Monitor) run(ctx context.Context, t Target) {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+
+	var p probe
+	for {
+		cctx, cancel := context.WithTimeout(ctx, m.opts.Timeout)
+		err := t.Checker.Check(cctx, t.Destination)
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.observe(t, &p, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe tracks consecutive results for a Target.
+type probe struct {
+	successes int
+	failures  int
+
+	// pending is set when the current State has not been applied.
+	pending bool
+}
+
+// observe records the result of a check, and applies any change of State.
+func (m *Monitor) observe(t Target, p *probe, err error) {
+	if err == nil {
+		p.successes++
+		p.failures = 0
+	} else {
+		p.failures++
+		p.successes = 0
+	}
+
+	key := keyOf(t.Service, t.Destination)
+
+	m.mu.Lock()
+	s := m.status[key]
+	s.Err = err
+
+	state := s.State
+	switch {
+	case err == nil && state != Healthy && p.successes >= m.opts.Rise:
+		state = Healthy
+	case err != nil && state != Unhealthy && p.failures >= m.opts.Fall:
+		state = Unhealthy
+	}
+
+	changed := state != s.State
+	if changed {
+		s.State = state
+		s.Since = time.Now()
+		p.pending = true
+	}
+	status := *s
+	m.mu.Unlock()
+
+	if changed && m.opts.OnChange != nil {
+		m.opts.OnChange(status)
+	}
+
+	if !p.pending || m.opts.Drained != nil && m.opts.Drained(t.Service) {
+		return
+	}
+
+	if err := m.apply(t, state); err != nil {
+		if m.opts.OnError != nil {
+			m.opts.OnError(status, err)
+		}
+		return
+	}
+
+	p.pending = false
+}
+
+// apply reflects state into the weight of the Destination.
+func (m *Monitor) apply(t Target, state State) error {
+	switch state {
+	case Healthy:
+		return ipvs.ApplyDestination(m.client, t.Service, t.Destination)
+	case Unhealthy:
+		if m.opts.Action == Remove {
+			err := m.client.RemoveDestination(t.Service, t.Destination)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		dest := t.Destination
+		dest.Weight = 0
+		return ipvs.ApplyDestination(m.client, t.Service, dest)
+	}
+
+	return nil
+}
+
+// targetKey identifies the Destination of a Service.
+type targetKey struct {
+	svc  ipvs.ServiceKey
+	dest ipvs.DestinationKey
+}
+
+// keyOf returns the targetKey of dest within svc.
+func keyOf(svc ipvs.Service, dest ipvs.Destination) targetKey {
+	return targetKey{svc.Key(), dest.Key()}
+}
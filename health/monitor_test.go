@@ -0,0 +1,145 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/ipvstest"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+var (
+	testService = ipvs.Service{
+		Address:   netip.MustParseAddr("192.0.2.1"),
+		Port:      80,
+		Family:    ipvs.INET,
+		Protocol:  ipvs.TCP,
+		Scheduler: "wlc",
+	}
+	testDestination = ipvs.Destination{
+		Address:   netip.MustParseAddr("198.51.100.1"),
+		Port:      8080,
+		Family:    ipvs.INET,
+		FwdMethod: ipvs.DirectRoute,
+		Weight:    10,
+	}
+)
+
+func TestMonitor(t *testing.T) {
+	type testCase struct {
+		name   string
+		action Action
+
+		// down is the expected Destination after failing,
+		// or nil if it is expected to be removed.
+		down *ipvs.Destination
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		c := ipvstest.New()
+		assert.NilError(t, c.CreateService(testService))
+		assert.NilError(t, c.CreateDestination(testService, testDestination))
+
+		var healthy atomic.Bool
+		healthy.Store(true)
+		checker := CheckerFunc(func(context.Context, ipvs.Destination) error {
+			if healthy.Load() {
+				return nil
+			}
+			return errors.New("unhealthy")
+		})
+
+		var changes atomic.Int32
+		m := NewMonitor(c, Options{
+			Interval: time.Millisecond,
+			Rise:     2,
+			Fall:     3,
+			Action:   tc.action,
+			OnChange: func(Status) { changes.Add(1) },
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- m.Run(ctx, []Target{{Service: testService, Destination: testDestination, Checker: checker}})
+		}()
+		t.Cleanup(func() {
+			cancel()
+			assert.ErrorIs(t, <-done, context.Canceled)
+		})
+
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			if m.Healthy(testService, testDestination) {
+				return poll.Success()
+			}
+			return poll.Continue("waiting for destination to become healthy")
+		})
+
+		healthy.Store(false)
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			s, _ := m.Status(testService, testDestination)
+			if s.State != Unhealthy {
+				return poll.Continue("state is %s", s.State)
+			}
+
+			dests, err := c.Destinations(testService)
+			switch {
+			case tc.down == nil && err == nil:
+				return poll.Continue("destination not removed")
+			case tc.down != nil && (err != nil || dests[0].Destination != *tc.down):
+				return poll.Continue("destination not inhibited")
+			}
+			return poll.Success()
+		})
+
+		healthy.Store(true)
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			dests, err := c.Destinations(testService)
+			if err != nil || dests[0].Destination != testDestination {
+				return poll.Continue("destination not restored")
+			}
+			return poll.Success()
+		})
+
+		assert.Equal(t, changes.Load(), int32(3))
+	}
+
+	inhibited := testDestination
+	inhibited.Weight = 0
+
+	testCases := []testCase{
+		{
+			name:   "inhibit",
+			action: Inhibit,
+			down:   &inhibited,
+		},
+		{
+			name:   "remove",
+			action: Remove,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestMonitor_Family(t *testing.T) {
+	m := NewMonitor(ipvstest.New(), Options{})
+	err := m.Run(context.Background(), []Target{
+		{Service: testService, Destination: testDestination},
+		{Service: ipvs.Service{FWMark: 42}, Destination: testDestination},
+	})
+	assert.ErrorContains(t, err, "health: target 1: ipvs: Family: is required for firewall-mark services")
+
+	_, ok := m.Status(testService, testDestination)
+	assert.Assert(t, !ok)
+}
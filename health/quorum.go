@@ -0,0 +1,474 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// QuorumAction determines what happens to a Service which loses quorum.
+type QuorumAction int
+
+const (
+	// Notify leaves the Service unchanged, only running the hooks.
+	Notify QuorumAction = iota
+
+	// ZeroWeights sets the weights of every Destination to zero, and
+	// restores the weights of the healthy ones once quorum is regained.
+	ZeroWeights
+
+	// Withdraw removes the Service, and recreates it with its healthy
+	// Destinations once quorum is regained.
+	Withdraw
+)
+
+// QuorumOptions configures a Quorum.
+type QuorumOptions struct {
+	// Interval between evaluations of a Service. Defaults to DefaultInterval.
+	Interval time.Duration
+
+	// Healthy reports whether a Destination is healthy, such as
+	// Monitor.Healthy. If nil, Destinations with a non-zero weight are
+	// healthy, which only suits the Notify action: the other actions
+	// change the weights themselves.
+	Healthy func(ipvs.Service, ipvs.Destination) bool
+
+	// Action taken on Services which lose quorum.
+	Action QuorumAction
+
+	// Fall is the number of consecutive evaluations without quorum before
+	// it is lost. Defaults to 1.
+	Fall int
+
+	// Rise is the number of consecutive evaluations with quorum before it
+	// is regained. Defaults to 1.
+	Rise int
+
+	// OnUp and OnDown, if set, are called when a Service regains or
+	// loses quorum, after the Action is taken.
+	OnUp   func(QuorumStatus)
+	OnDown func(QuorumStatus)
+
+	// OnError, if set, is called when the Action could not be taken, or
+	// a command failed. Actions are retried after the next evaluation.
+	OnError func(QuorumStatus, error)
+}
+
+// QuorumTarget is a Service and its quorum policy.
+//
+// The Service has quorum when at least MinHealthy of its Destinations are
+// healthy, or when the weights of its healthy Destinations total at least
+// MinWeight. If both are zero, one healthy Destination is a quorum.
+type QuorumTarget struct {
+	// Service is the configuration restored by the Withdraw action, if the
+	// Service was not seen before it was withdrawn.
+	Service ipvs.Service
+
+	// Destinations, if set, are the configured Destinations of the
+	// Service. They are restored by the ZeroWeights and Withdraw actions,
+	// rather than the Destinations seen when quorum was lost, and counted
+	// while the Service is withdrawn, including when it is absent as the
+	// Quorum starts.
+	Destinations []ipvs.Destination
+
+	MinHealthy int
+	MinWeight  uint32
+
+	// UpCommand and DownCommand, if set, are run when the Service regains
+	// or loses quorum, like keepalived's quorum_up and quorum_down. They
+	// are run without a shell, with IPVS_SERVICE set to the key of the
+	// Service and IPVS_QUORUM set to "up" or "down" in their environment.
+	UpCommand   []string
+	DownCommand []string
+}
+
+// QuorumStatus is the state of a QuorumTarget.
+type QuorumStatus struct {
+	QuorumTarget
+
+	// Up is set while the Service has quorum.
+	Up bool
+
+	// Healthy and Weight are the number of healthy Destinations, and the
+	// total of their weights, at the last evaluation.
+	Healthy int
+	Weight  uint32
+
+	// Since is when Up last changed.
+	Since time.Time
+
+	// drained is set from before the Action is taken on losing quorum
+	// until it is taken on regaining it.
+	drained bool
+}
+
+// Quorum evaluates whether Services have enough healthy Destinations,
+// withdrawing or draining those which do not.
+//
+// Services are assumed to have quorum when the Quorum starts, except that
+// with the Withdraw action, a Service which is absent is considered
+// withdrawn, such as by an earlier run, and is created once it has quorum.
+//
+// While a Service is drained, the Quorum owns the weights of its
+// Destinations. A Monitor reflecting health into the same Destinations
+// must hold its changes meanwhile, by setting its Options.Drained to
+// Quorum.Drained.
+type Quorum struct {
+	client ipvs.Client
+	opts   QuorumOptions
+
+	mu     sync.Mutex
+	status map[ipvs.ServiceKey]*QuorumStatus
+}
+
+// NewQuorum returns a Quorum applying changes with c.
+func NewQuorum(c ipvs.Client, opts QuorumOptions) *Quorum {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.Rise <= 0 {
+		opts.Rise = 1
+	}
+	if opts.Fall <= 0 {
+		opts.Fall = 1
+	}
+
+	return &Quorum{
+		client: c,
+		opts:   opts,
+		status: make(map[ipvs.ServiceKey]*QuorumStatus),
+	}
+}
+
+// Run evaluates each of the targets until ctx is done, then returns
+// ctx.Err(). Services which lost quorum are left as they are. If the
+// address family of the Service of a target cannot be resolved, Run
+// returns an error without evaluating any.
+func (q *Quorum) Run(ctx context.Context, targets []QuorumTarget) error {
+	for i, t := range targets {
+		if _, err := ipvs.KeyOf(t.Service); err != nil {
+			return fmt.Errorf("health: target %d: %w", i, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		q.mu.Lock()
+		q.status[t.Service.Key()] = &QuorumStatus{QuorumTarget: t, Up: true, Since: time.Now()}
+		q.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.run(ctx, t)
+		}()
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Status returns the QuorumStatus of a Service.
+func (q *Quorum) Status(svc ipvs.Service) (QuorumStatus, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s, ok := q.status[svc.Key()]
+	if !ok {
+		return QuorumStatus{}, false
+	}
+
+	return *s, true
+}
+
+// Up reports whether a Service has quorum.
+func (q *Quorum) Up(svc ipvs.Service) bool {
+	s, ok := q.Status(svc)
+	return ok && s.Up
+}
+
+// Drained reports whether the Action for a Service losing quorum is in
+// effect, so that the weights of its Destinations must be left alone. It
+// is always false for the Notify action.
+func (q *Quorum) Drained(svc ipvs.Service) bool {
+	s, ok := q.Status(svc)
+	return ok && s.drained
+}
+
+// run evaluates t every interval until ctx is done.
+func (q *Quorum) run(ctx context.Context, t QuorumTarget) {
+	ticker := time.NewTicker(q.opts.Interval)
+	defer ticker.Stop()
+
+	w := &watch{service: t.Service, dests: slices.Clone(t.Destinations)}
+	for {
+		q.evaluate(ctx, t, w)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watch tracks a QuorumTarget between evaluations.
+type watch struct {
+	hysteresis
+
+	// service and dests are the configuration last seen while the
+	// Service had quorum, restored once it regains it.
+	service ipvs.Service
+	dests   []ipvs.Destination
+
+	// present is set if the Service was configured when last seen.
+	present bool
+
+	// pending is set when the Action for the current state has not been
+	// taken.
+	pending bool
+}
+
+// evaluate counts the healthy Destinations of t, and takes the Action if
+// quorum changed.
+func (q *Quorum) evaluate(ctx context.Context, t QuorumTarget, w *watch) {
+	q.mu.Lock()
+	s := q.status[t.Service.Key()]
+	up := s.Up
+	q.mu.Unlock()
+
+	// While the Action is in effect, the table reflects it rather than
+	// the configuration.
+	if up || q.opts.Action == Notify {
+		if err := q.observe(w); err != nil {
+			q.fail(t, err)
+			return
+		}
+
+		// A Service withdrawn before it was seen, such as by an earlier
+		// run, is recreated once it has quorum.
+		if up && !w.present && q.opts.Action == Withdraw {
+			q.mu.Lock()
+			s.Up, s.Since, s.drained = false, time.Now(), true
+			q.mu.Unlock()
+			up = false
+		}
+	}
+
+	healthy, weight := q.count(w)
+	if t.quorate(healthy, weight) {
+		w.up++
+		w.down = 0
+	} else {
+		w.down++
+		w.up = 0
+	}
+
+	changed := false
+	switch {
+	case up && w.down >= q.opts.Fall:
+		up, changed = false, true
+	case !up && w.up >= q.opts.Rise:
+		up, changed = true, true
+	}
+
+	q.mu.Lock()
+	s.Healthy, s.Weight = healthy, weight
+	if changed {
+		s.Up = up
+		s.Since = time.Now()
+		w.pending = true
+	}
+	if !up && q.opts.Action != Notify {
+		s.drained = true
+	}
+	status := *s
+	q.mu.Unlock()
+
+	if !w.pending {
+		return
+	}
+
+	if err := q.apply(t, w, up); err != nil {
+		q.fail(t, err)
+		return
+	}
+	w.pending = false
+
+	if up {
+		q.mu.Lock()
+		s.drained = false
+		q.mu.Unlock()
+	}
+
+	hook, command := q.opts.OnDown, t.DownCommand
+	if up {
+		hook, command = q.opts.OnUp, t.UpCommand
+	}
+	if hook != nil {
+		hook(status)
+	}
+	if len(command) > 0 {
+		if err := runCommand(ctx, command, t.Service, up); err != nil {
+			q.fail(t, err)
+		}
+	}
+}
+
+// observe records the configuration of the Service of w. The
+// Destinations last seen are kept if the Service is not configured.
+func (q *Quorum) observe(w *watch) error {
+	se, err := q.client.Service(w.service)
+	if errors.Is(err, fs.ErrNotExist) {
+		w.present = false
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dests, err := q.client.Destinations(w.service)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	w.present = true
+	w.service = se.Service
+	w.service.Flags &^= ipvs.ServiceHashed
+	w.dests = w.dests[:0]
+	for _, d := range dests {
+		w.dests = append(w.dests, d.Destination)
+	}
+
+	return nil
+}
+
+// count returns the number of healthy Destinations of w, and the total
+// of their weights. A Service which is not configured has none, unless
+// it was withdrawn.
+func (q *Quorum) count(w *watch) (healthy int, weight uint32) {
+	if !w.present && q.opts.Action != Withdraw {
+		return 0, 0
+	}
+
+	for _, d := range w.dests {
+		if q.healthy(w.service, d) {
+			healthy++
+			weight += d.Weight
+		}
+	}
+
+	return healthy, weight
+}
+
+// healthy reports whether dest is healthy.
+func (q *Quorum) healthy(svc ipvs.Service, dest ipvs.Destination) bool {
+	if q.opts.Healthy == nil {
+		return dest.Weight > 0
+	}
+
+	return q.opts.Healthy(svc, dest)
+}
+
+// restore returns the Destinations of t to restore once it regains
+// quorum: the configured ones if set, or those of w. Destinations which
+// are unhealthy, or had no weight, are left to the Monitor.
+func (q *Quorum) restore(t QuorumTarget, w *watch) []ipvs.Destination {
+	dests := w.dests
+	if len(t.Destinations) > 0 {
+		dests = t.Destinations
+	}
+
+	var restore []ipvs.Destination
+	for _, d := range dests {
+		if d.Weight > 0 && q.healthy(w.service, d) {
+			restore = append(restore, d)
+		}
+	}
+
+	return restore
+}
+
+// quorate reports whether healthy Destinations with a total weight of
+// weight are a quorum.
+func (t QuorumTarget) quorate(healthy int, weight uint32) bool {
+	if t.MinHealthy == 0 && t.MinWeight == 0 {
+		return healthy > 0
+	}
+
+	return (t.MinHealthy > 0 && healthy >= t.MinHealthy) ||
+		(t.MinWeight > 0 && weight >= t.MinWeight)
+}
+
+// apply takes the Action for the Service of t gaining or losing quorum.
+func (q *Quorum) apply(t QuorumTarget, w *watch, up bool) error {
+	switch q.opts.Action {
+	case ZeroWeights:
+		dests := w.dests
+		if up {
+			dests = q.restore(t, w)
+		}
+
+		for _, d := range dests {
+			if !up {
+				d.Weight = 0
+			}
+			if err := q.client.UpdateDestination(w.service, d); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("destination %s: %w", d.Key(), err)
+			}
+		}
+	case Withdraw:
+		if !up {
+			err := q.client.RemoveService(w.service)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if err := q.client.CreateService(w.service); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		for _, d := range q.restore(t, w) {
+			if err := q.client.CreateDestination(w.service, d); err != nil && !errors.Is(err, fs.ErrExist) {
+				return fmt.Errorf("destination %s: %w", d.Key(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// runCommand runs a quorum hook command for svc.
+func runCommand(ctx context.Context, command []string, svc ipvs.Service, up bool) error {
+	state := "down"
+	if up {
+		state = "up"
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), "IPVS_SERVICE="+svc.Key().String(), "IPVS_QUORUM="+state)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("quorum %s command %q: %w: %s", state, command[0], err, out)
+	}
+
+	return nil
+}
+
+// fail reports err for t.
+func (q *Quorum) fail(t QuorumTarget, err error) {
+	if q.opts.OnError == nil {
+		return
+	}
+
+	status, _ := q.Status(t.Service)
+	q.opts.OnError(status, err)
+}
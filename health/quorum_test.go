@@ -0,0 +1,358 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/ipvstest"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+var quorumDestinations = []ipvs.Destination{
+	testDestination,
+	{
+		Address:   netip.MustParseAddr("198.51.100.2"),
+		Port:      8080,
+		Family:    ipvs.INET,
+		FwdMethod: ipvs.DirectRoute,
+		Weight:    20,
+	},
+}
+
+// quorumTable returns a Client with testService and quorumDestinations.
+func quorumTable(t *testing.T) *ipvstest.Client {
+	c := ipvstest.New()
+	assert.NilError(t, c.CreateService(testService))
+	for _, d := range quorumDestinations {
+		assert.NilError(t, c.CreateDestination(testService, d))
+	}
+	return c
+}
+
+// newQuorum returns a Quorum for the target, ready to evaluate it.
+func newQuorum(target QuorumTarget, opts QuorumOptions) (*Quorum, *watch) {
+	q := NewQuorum(nil, opts)
+	q.status[target.Service.Key()] = &QuorumStatus{QuorumTarget: target, Up: true}
+	return q, &watch{service: target.Service, dests: slices.Clone(target.Destinations)}
+}
+
+func TestQuorumTarget_Quorate(t *testing.T) {
+	type testCase struct {
+		name     string
+		target   QuorumTarget
+		healthy  int
+		weight   uint32
+		expected bool
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		assert.Equal(t, tc.target.quorate(tc.healthy, tc.weight), tc.expected)
+	}
+
+	testCases := []testCase{
+		{name: "default", healthy: 1, weight: 1, expected: true},
+		{name: "default none healthy", expected: false},
+		{name: "count", target: QuorumTarget{MinHealthy: 2}, healthy: 2, weight: 2, expected: true},
+		{name: "count short", target: QuorumTarget{MinHealthy: 2}, healthy: 1, weight: 100, expected: false},
+		{name: "weight", target: QuorumTarget{MinWeight: 30}, healthy: 1, weight: 30, expected: true},
+		{name: "weight short", target: QuorumTarget{MinWeight: 30}, healthy: 5, weight: 29, expected: false},
+		{name: "either", target: QuorumTarget{MinHealthy: 3, MinWeight: 30}, healthy: 1, weight: 30, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestQuorum_Actions(t *testing.T) {
+	type testCase struct {
+		name   string
+		action QuorumAction
+
+		// down checks the table once quorum is lost.
+		down func(t *testing.T, c *ipvstest.Client)
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		c := quorumTable(t)
+
+		healthy := map[ipvs.DestinationKey]bool{}
+		for _, d := range quorumDestinations {
+			healthy[d.Key()] = true
+		}
+
+		var events []string
+		q, w := newQuorum(QuorumTarget{Service: testService, MinWeight: 25}, QuorumOptions{
+			Action:  tc.action,
+			Healthy: func(_ ipvs.Service, d ipvs.Destination) bool { return healthy[d.Key()] },
+			Fall:    2,
+			OnUp:    func(s QuorumStatus) { events = append(events, "up") },
+			OnDown:  func(s QuorumStatus) { events = append(events, "down") },
+			OnError: func(_ QuorumStatus, err error) { t.Error(err) },
+		})
+		q.client = c
+		ctx := context.Background()
+
+		q.evaluate(ctx, q.status[testService.Key()].QuorumTarget, w)
+		assert.Assert(t, q.Up(testService))
+
+		// 20 of the 25 required remain.
+		healthy[testDestination.Key()] = false
+		q.evaluate(ctx, q.status[testService.Key()].QuorumTarget, w)
+		assert.Assert(t, q.Up(testService))
+		q.evaluate(ctx, q.status[testService.Key()].QuorumTarget, w)
+		assert.Assert(t, !q.Up(testService))
+
+		s, _ := q.Status(testService)
+		assert.Equal(t, s.Healthy, 1)
+		assert.Equal(t, s.Weight, uint32(20))
+		tc.down(t, c)
+
+		healthy[testDestination.Key()] = true
+		q.evaluate(ctx, q.status[testService.Key()].QuorumTarget, w)
+		assert.Assert(t, q.Up(testService))
+		assert.DeepEqual(t, events, []string{"down", "up"})
+
+		dests, err := c.Destinations(testService)
+		assert.NilError(t, err)
+		for i, d := range dests {
+			assert.Equal(t, d.Destination, quorumDestinations[i])
+		}
+	}
+
+	testCases := []testCase{
+		{
+			name:   "notify",
+			action: Notify,
+			down: func(t *testing.T, c *ipvstest.Client) {
+				dests, err := c.Destinations(testService)
+				assert.NilError(t, err)
+				assert.Equal(t, dests[1].Weight, uint32(20))
+			},
+		},
+		{
+			name:   "zero weights",
+			action: ZeroWeights,
+			down: func(t *testing.T, c *ipvstest.Client) {
+				dests, err := c.Destinations(testService)
+				assert.NilError(t, err)
+				for _, d := range dests {
+					assert.Equal(t, d.Weight, uint32(0))
+				}
+			},
+		},
+		{
+			name:   "withdraw",
+			action: Withdraw,
+			down: func(t *testing.T, c *ipvstest.Client) {
+				_, err := c.Service(testService)
+				assert.ErrorIs(t, err, fs.ErrNotExist)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestQuorum_Run(t *testing.T) {
+	c := quorumTable(t)
+	log := filepath.Join(t.TempDir(), "quorum.log")
+	hook := []string{"sh", "-c", `echo "$IPVS_QUORUM $IPVS_SERVICE" >> "$0"`, log}
+
+	q := NewQuorum(c, QuorumOptions{
+		Interval: time.Millisecond,
+		OnError:  func(_ QuorumStatus, err error) { t.Error(err) },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- q.Run(ctx, []QuorumTarget{{Service: testService, MinHealthy: 2, UpCommand: hook, DownCommand: hook}})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+	})
+
+	drained := testDestination
+	drained.Weight = 0
+	assert.NilError(t, c.UpdateDestination(testService, drained))
+
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		b, _ := os.ReadFile(log)
+		if !strings.Contains(string(b), "down tcp:192.0.2.1:80") {
+			return poll.Continue("down command not run")
+		}
+		return poll.Success()
+	})
+
+	assert.NilError(t, c.UpdateDestination(testService, testDestination))
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		b, _ := os.ReadFile(log)
+		if string(b) != "down tcp:192.0.2.1:80\nup tcp:192.0.2.1:80\n" {
+			return poll.Continue("up command not run: %q", b)
+		}
+		return poll.Success()
+	})
+}
+
+func TestQuorum_Monitor(t *testing.T) {
+	c := quorumTable(t)
+
+	var healthy [2]atomic.Bool
+	var checks [2]atomic.Int64
+	index := func(d ipvs.Destination) int {
+		return slices.IndexFunc(quorumDestinations, func(qd ipvs.Destination) bool { return qd.Key() == d.Key() })
+	}
+	healthy[0].Store(true)
+	healthy[1].Store(true)
+
+	checker := CheckerFunc(func(_ context.Context, d ipvs.Destination) error {
+		i := index(d)
+		checks[i].Add(1)
+		if !healthy[i].Load() {
+			return errors.New("unhealthy")
+		}
+		return nil
+	})
+
+	// The Quorum owns the weights while the Service is drained.
+	var q *Quorum
+	m := NewMonitor(c, Options{
+		Interval: time.Millisecond,
+		Drained:  func(svc ipvs.Service) bool { return q.Drained(svc) },
+		OnError:  func(_ Status, err error) { t.Error(err) },
+	})
+	q = NewQuorum(c, QuorumOptions{
+		Interval: time.Millisecond,
+		Action:   ZeroWeights,
+		Healthy:  m.Healthy,
+		OnError:  func(_ QuorumStatus, err error) { t.Error(err) },
+	})
+
+	var targets []Target
+	for _, d := range quorumDestinations {
+		targets = append(targets, Target{Service: testService, Destination: d, Checker: checker})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 2)
+	go func() { done <- m.Run(ctx, targets) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+		assert.ErrorIs(t, <-done, context.Canceled)
+	})
+
+	waitHealthy := func(i int, ok bool) {
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			if m.Healthy(testService, quorumDestinations[i]) != ok {
+				return poll.Continue("destination %d not changed", i)
+			}
+			return poll.Success()
+		})
+	}
+	waitWeights := func(expected ...uint32) {
+		poll.WaitOn(t, func(poll.LogT) poll.Result {
+			dests, err := c.Destinations(testService)
+			if err != nil {
+				return poll.Error(err)
+			}
+			var weights []uint32
+			for _, d := range dests {
+				weights = append(weights, d.Weight)
+			}
+			if !slices.Equal(weights, expected) {
+				return poll.Continue("weights are %v, want %v", weights, expected)
+			}
+			return poll.Success()
+		})
+	}
+
+	waitHealthy(0, true)
+	waitHealthy(1, true)
+	go func() { done <- q.Run(ctx, []QuorumTarget{{Service: testService, MinHealthy: 2}}) }()
+
+	healthy[0].Store(false)
+	waitWeights(0, 0)
+	assert.Assert(t, q.Drained(testService))
+
+	// Destinations turning healthy while quorum is lost keep no weight.
+	healthy[1].Store(false)
+	waitHealthy(1, false)
+	healthy[0].Store(true)
+	waitHealthy(0, true)
+	n := checks[0].Load()
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		if checks[0].Load() < n+2 {
+			return poll.Continue("destination not checked again")
+		}
+		return poll.Success()
+	})
+	waitWeights(0, 0)
+	assert.Assert(t, !q.Up(testService))
+
+	// Once quorum is regained, both are restored, including the one
+	// inhibited when it was lost.
+	healthy[1].Store(true)
+	waitWeights(10, 20)
+	assert.Assert(t, q.Up(testService))
+	assert.Assert(t, !q.Drained(testService))
+}
+
+func TestQuorum_Absent(t *testing.T) {
+	c := ipvstest.New()
+
+	var events []string
+	q := NewQuorum(c, QuorumOptions{
+		Interval: time.Millisecond,
+		Action:   Withdraw,
+		OnUp:     func(QuorumStatus) { events = append(events, "up") },
+		OnDown:   func(QuorumStatus) { events = append(events, "down") },
+		OnError:  func(_ QuorumStatus, err error) { t.Error(err) },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- q.Run(ctx, []QuorumTarget{{Service: testService, Destinations: quorumDestinations}})
+	}()
+
+	// The Service, withdrawn before the Quorum started, is created from
+	// its configured Destinations.
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		dests, err := c.Destinations(testService)
+		if err != nil || len(dests) != len(quorumDestinations) {
+			return poll.Continue("service not created")
+		}
+		return poll.Success()
+	})
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Assert(t, q.Up(testService))
+	assert.DeepEqual(t, events, []string{"up"})
+
+	dests, err := c.Destinations(testService)
+	assert.NilError(t, err)
+	for i, d := range dests {
+		assert.Equal(t, d.Destination, quorumDestinations[i])
+	}
+}
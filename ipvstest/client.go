@@ -0,0 +1,382 @@
+// Package ipvstest provides an in-memory implementation of ipvs.Client
+// for use in tests.
+package ipvstest
+
+import (
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// Client is an in-memory ipvs.Client. Services and Destinations are
+// identified the same way IPVS identifies them, and missing or duplicate
+// entries are reported with os.ErrNotExist and os.ErrExist.
+//
+// The zero value is an empty table, ready to use.
+type Client struct {
+	mu       sync.Mutex
+	info     ipvs.Info
+	caps     ipvs.Capabilities
+	config   ipvs.Config
+	daemons  []ipvs.Daemon
+	sysctls  ipvs.Sysctls
+	services []*service
+}
+
+type service struct {
+	svc   ipvs.ServiceExtended
+	dests []ipvs.DestinationExtended
+}
+
+var (
+	_ ipvs.Client             = (*Client)(nil)
+	_ ipvs.CapabilitiesClient = (*Client)(nil)
+	_ ipvs.DaemonClient       = (*Client)(nil)
+	_ ipvs.SysctlClient       = (*Client)(nil)
+)
+
+// New returns an empty Client.
+func New() *Client {
+	return &Client{
+		info: ipvs.Info{
+			Version:             [3]int{1, 2, 1},
+			ConnectionTableSize: 4096,
+		},
+		caps: ipvs.Capabilities{
+			Version:            [3]int{1, 2, 1},
+			MixedFamily:        true,
+			Stats64:            true,
+			TunnelTypes:        []ipvs.TunnelType{ipvs.IPIP, ipvs.GUE, ipvs.GRE},
+			TunnelFlags:        true,
+			PersistenceEngines: []string{"sip"},
+			Schedulers:         ipvs.Schedulers,
+		},
+	}
+}
+
+// Info returns the Info set with SetInfo.
+func (c *Client) Info() (ipvs.Info, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.info, nil
+}
+
+// SetInfo changes the Info reported by the Client.
+func (c *Client) SetInfo(info ipvs.Info) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.info = info
+}
+
+// Capabilities returns the Capabilities set with SetCapabilities. By
+// default, every feature is reported as supported. The Client itself
+// does not refuse unsupported features.
+func (c *Client) Capabilities() (ipvs.Capabilities, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.caps, nil
+}
+
+// SetCapabilities changes the Capabilities reported by the Client.
+func (c *Client) SetCapabilities(caps ipvs.Capabilities) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.caps = caps
+}
+
+// Config returns the current timeout values.
+func (c *Client) Config() (ipvs.Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.config, nil
+}
+
+// SetConfig changes the timeout values.
+func (c *Client) SetConfig(config ipvs.Config) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config = config
+	return nil
+}
+
+// Sysctls returns the sysctls set with SetSysctls.
+func (c *Client) Sysctls() (ipvs.Sysctls, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sysctls, nil
+}
+
+// SetSysctls changes the sysctls which are set in s, leaving the others
+// unchanged.
+func (c *Client) SetSysctls(s ipvs.Sysctls) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	have := reflect.ValueOf(&c.sysctls).Elem()
+	want := reflect.ValueOf(s)
+	for i := range want.NumField() {
+		if f := want.Field(i); !f.IsNil() {
+			v := reflect.New(f.Type().Elem())
+			v.Elem().Set(f.Elem())
+			have.Field(i).Set(v)
+		}
+	}
+
+	return nil
+}
+
+// Daemons returns the running synchronization daemons.
+func (c *Client) Daemons() ([]ipvs.Daemon, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.daemons), nil
+}
+
+// CreateDaemon starts a synchronization daemon. Like IPVS, one daemon of
+// each state may run.
+func (c *Client) CreateDaemon(d ipvs.Daemon) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if slices.ContainsFunc(c.daemons, func(have ipvs.Daemon) bool { return have.State == d.State }) {
+		return os.ErrExist
+	}
+
+	c.daemons = append(c.daemons, d)
+	return nil
+}
+
+// RemoveDaemon stops the synchronization daemon in the state of d.
+func (c *Client) RemoveDaemon(d ipvs.Daemon) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := slices.IndexFunc(c.daemons, func(have ipvs.Daemon) bool { return have.State == d.State })
+	if i < 0 {
+		return os.ErrNotExist
+	}
+
+	c.daemons = slices.Delete(c.daemons, i, i+1)
+	return nil
+}
+
+// Services returns every Service. Like the netlink client, an empty
+// table is reported as os.ErrNotExist.
+func (c *Client) Services() ([]ipvs.ServiceExtended, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.services) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	svcs := make([]ipvs.ServiceExtended, 0, len(c.services))
+	for _, s := range c.services {
+		svcs = append(svcs, s.svc)
+	}
+
+	return svcs, nil
+}
+
+// Service returns a single Service.
+func (c *Client) Service(svc ipvs.Service) (ipvs.ServiceExtended, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.lookup(svc)
+	if s == nil {
+		return ipvs.ServiceExtended{}, os.ErrNotExist
+	}
+
+	return s.svc, nil
+}
+
+// CreateService adds a Service.
+func (c *Client) CreateService(svc ipvs.Service) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	svc, err := svc.ResolveFamily()
+	if err != nil {
+		return err
+	}
+
+	if c.lookup(svc) != nil {
+		return os.ErrExist
+	}
+
+	c.services = append(c.services, &service{
+		svc: ipvs.ServiceExtended{Service: svc},
+	})
+	return nil
+}
+
+// UpdateService replaces the configuration of a Service.
+func (c *Client) UpdateService(svc ipvs.Service) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	svc, err := svc.ResolveFamily()
+	if err != nil {
+		return err
+	}
+
+	s := c.lookup(svc)
+	if s == nil {
+		return os.ErrNotExist
+	}
+
+	s.svc.Service = svc
+	return nil
+}
+
+// RemoveService removes a Service and its Destinations.
+func (c *Client) RemoveService(svc ipvs.Service) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, s := range c.services {
+		if s.svc.Key() == svc.Key() {
+			c.services = append(c.services[:i], c.services[i+1:]...)
+			return nil
+		}
+	}
+
+	return os.ErrNotExist
+}
+
+// Destinations returns the Destinations of a Service. Like the netlink
+// client, a Service without Destinations is reported as os.ErrNotExist.
+func (c *Client) Destinations(svc ipvs.Service) ([]ipvs.DestinationExtended, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.lookup(svc)
+	if s == nil || len(s.dests) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	return append([]ipvs.DestinationExtended(nil), s.dests...), nil
+}
+
+// CreateDestination adds a Destination to a Service.
+func (c *Client) CreateDestination(svc ipvs.Service, dest ipvs.Destination) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dest, err := dest.ResolveFamily()
+	if err != nil {
+		return err
+	}
+
+	s := c.lookup(svc)
+	if s == nil {
+		return os.ErrNotExist
+	}
+	if s.dest(dest) != nil {
+		return os.ErrExist
+	}
+
+	s.dests = append(s.dests, ipvs.DestinationExtended{Destination: dest})
+	return nil
+}
+
+// UpdateDestination replaces the configuration of a Destination.
+func (c *Client) UpdateDestination(svc ipvs.Service, dest ipvs.Destination) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dest, err := dest.ResolveFamily()
+	if err != nil {
+		return err
+	}
+
+	s := c.lookup(svc)
+	if s == nil {
+		return os.ErrNotExist
+	}
+
+	d := s.dest(dest)
+	if d == nil {
+		return os.ErrNotExist
+	}
+
+	d.Destination = dest
+	return nil
+}
+
+// RemoveDestination removes a Destination from a Service.
+func (c *Client) RemoveDestination(svc ipvs.Service, dest ipvs.Destination) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.lookup(svc)
+	if s == nil {
+		return os.ErrNotExist
+	}
+
+	for i := range s.dests {
+		if s.dests[i].Key() == dest.Key() {
+			s.dests = append(s.dests[:i], s.dests[i+1:]...)
+			return nil
+		}
+	}
+
+	return os.ErrNotExist
+}
+
+// ModifyDestination calls fn with the stored DestinationExtended, allowing
+// tests to set fields such as connection counts and statistics which are
+// only reported by the kernel.
+func (c *Client) ModifyDestination(svc ipvs.Service, dest ipvs.Destination, fn func(*ipvs.DestinationExtended)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.lookup(svc)
+	if s == nil {
+		return os.ErrNotExist
+	}
+
+	d := s.dest(dest)
+	if d == nil {
+		return os.ErrNotExist
+	}
+
+	fn(d)
+	return nil
+}
+
+// lookup returns the stored service identified by svc, or nil.
+// Like the netlink client, the address family of svc is inferred
+// from its address if unset.
+func (c *Client) lookup(svc ipvs.Service) *service {
+	for _, s := range c.services {
+		if s.svc.Key() == svc.Key() {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// dest returns the stored destination identified by dest, or nil.
+func (s *service) dest(dest ipvs.Destination) *ipvs.DestinationExtended {
+	for i := range s.dests {
+		if s.dests[i].Key() == dest.Key() {
+			return &s.dests[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package ipvstest
+
+import (
+	"io/fs"
+	"net/netip"
+	"testing"
+
+	"github.com/cloudflare/ipvs"
+	"gotest.tools/v3/assert"
+)
+
+func TestClient(t *testing.T) {
+	c := New()
+
+	_, err := c.Services()
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	svc := ipvs.Service{
+		Address:   netip.MustParseAddr("192.0.2.1"),
+		Port:      80,
+		Family:    ipvs.INET,
+		Protocol:  ipvs.TCP,
+		Scheduler: "rr",
+	}
+	fwm := ipvs.Service{
+		FWMark:    1,
+		Family:    ipvs.INET,
+		Scheduler: "rr",
+	}
+	assert.NilError(t, c.CreateService(svc))
+	assert.NilError(t, c.CreateService(fwm))
+	assert.ErrorIs(t, c.CreateService(svc), fs.ErrExist)
+
+	svc.Scheduler = "wlc"
+	assert.NilError(t, c.UpdateService(svc))
+
+	got, err := c.Service(ipvs.Service{
+		Address:  svc.Address,
+		Port:     svc.Port,
+		Family:   svc.Family,
+		Protocol: svc.Protocol,
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, got.Service, svc)
+
+	dest := ipvs.Destination{
+		Address: netip.MustParseAddr("198.51.100.1"),
+		Port:    80,
+		Weight:  1,
+	}
+	_, err = c.Destinations(svc)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	assert.NilError(t, c.CreateDestination(svc, dest))
+	assert.ErrorIs(t, c.CreateDestination(svc, dest), fs.ErrExist)
+
+	assert.NilError(t, c.ModifyDestination(svc, dest, func(d *ipvs.DestinationExtended) {
+		d.ActiveConnections = 5
+	}))
+
+	dests, err := c.Destinations(svc)
+	assert.NilError(t, err)
+	assert.Equal(t, len(dests), 1)
+	assert.Equal(t, dests[0].Family, ipvs.INET)
+	assert.Equal(t, dests[0].ActiveConnections, uint32(5))
+
+	mapped := ipvs.Destination{
+		Address: netip.MustParseAddr("::ffff:198.51.100.1"),
+		Port:    80,
+	}
+	assert.ErrorIs(t, c.CreateDestination(svc, mapped), fs.ErrExist)
+	assert.ErrorContains(t, c.CreateDestination(svc, ipvs.Destination{
+		Address: netip.MustParseAddr("2001:db8::1"),
+		Family:  ipvs.INET,
+	}), "does not match the address")
+
+	assert.NilError(t, c.RemoveDestination(svc, dest))
+	assert.ErrorIs(t, c.RemoveDestination(svc, dest), fs.ErrNotExist)
+
+	assert.NilError(t, c.RemoveService(svc))
+	assert.ErrorIs(t, c.UpdateService(svc), fs.ErrNotExist)
+
+	svcs, err := c.Services()
+	assert.NilError(t, err)
+	assert.Equal(t, len(svcs), 1)
+	assert.Equal(t, svcs[0].Service, fwm)
+}
@@ -0,0 +1,545 @@
+// Package keepalived imports the virtual servers of a keepalived.conf as
+// ipvs Services and Destinations, so that a fleet configured by keepalived
+// can be migrated to a controller built on this module.
+//
+// Only the LVS configuration is imported: virtual_server and
+// virtual_server_group blocks, and within them the lb_algo, lb_kind,
+// persistence_timeout, persistence_granularity, protocol, sorry_server
+// and real_server directives, and the weight of real servers. Other
+// directives within those blocks, such as health checkers, are skipped
+// with a Diagnostic. Top-level blocks unrelated to LVS, such as
+// vrrp_instance, are skipped silently.
+package keepalived
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/health"
+	"github.com/cloudflare/ipvs/netmask"
+	"github.com/cloudflare/ipvs/snapshot"
+)
+
+// defaultPersistenceTimeout is the timeout used by keepalived for a
+// persistence_timeout directive without a value, in seconds.
+const defaultPersistenceTimeout = 360
+
+// Diagnostic reports a directive which was skipped, or, when returned as
+// an error, which could not be imported.
+type Diagnostic struct {
+	Line      int
+	Directive string
+	Message   string
+}
+
+// Error returns the diagnostic, such as
+// "keepalived: line 12: delay_loop: unsupported directive".
+func (d *Diagnostic) Error() string {
+	if d.Directive == "" {
+		return fmt.Sprintf("keepalived: line %d: %s", d.Line, d.Message)
+	}
+
+	return fmt.Sprintf("keepalived: line %d: %s: %s", d.Line, d.Directive, d.Message)
+}
+
+// Config is the LVS configuration of a keepalived.conf.
+type Config struct {
+	// VirtualServers are in the order of the file. A virtual_server of a
+	// virtual_server_group is imported once for every member.
+	VirtualServers []VirtualServer
+
+	// Diagnostics report the directives which were skipped.
+	Diagnostics []*Diagnostic
+}
+
+// VirtualServer is a Service and its Destinations.
+type VirtualServer struct {
+	// Line is the line of the virtual_server directive.
+	Line int
+
+	// Group is the name of the virtual_server_group the Service is a
+	// member of, if any.
+	Group string
+
+	Service      ipvs.Service
+	Destinations []ipvs.Destination
+
+	// Sorry is the sorry_server, which keepalived adds when every real
+	// server is down, if any.
+	Sorry *ipvs.Destination
+}
+
+// Parse reads a keepalived.conf. Included files are not read.
+func Parse(r io.Reader) (*Config, error) {
+	tokens, err := lex(r)
+	if err != nil {
+		return nil, fmt.Errorf("keepalived: %w", err)
+	}
+
+	nodes, err := parse(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	groups := make(map[string][]ipvs.Service)
+
+	for _, n := range nodes {
+		switch n.name {
+		case "virtual_server_group":
+			if len(n.args) != 1 {
+				return nil, n.errorf("expected a name")
+			}
+
+			members, err := c.group(n)
+			if err != nil {
+				return nil, err
+			}
+			groups[n.args[0]] = members
+		case "include":
+			c.skip(n, "included files are not read")
+		}
+	}
+
+	for _, n := range nodes {
+		if n.name == "virtual_server" {
+			if err := c.virtualServer(n, groups); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// Snapshot returns the Services and Destinations of c as a snapshot, which
+// can be restored with snapshot.Restore. Sorry servers are only added when
+// every real server is down, so they are left out; run them with a
+// health.Fallback of FallbackTargets.
+func (c *Config) Snapshot() *snapshot.Snapshot {
+	s := &snapshot.Snapshot{Version: snapshot.Version}
+	for _, vs := range c.VirtualServers {
+		s.Services = append(s.Services, snapshot.Service{
+			Service:      vs.Service,
+			Destinations: vs.Destinations,
+		})
+	}
+
+	return s
+}
+
+// FallbackTargets returns the sorry servers of c, in the order of the
+// file, as targets of a health.Fallback.
+func (c *Config) FallbackTargets() []health.FallbackTarget {
+	var targets []health.FallbackTarget
+	for _, vs := range c.VirtualServers {
+		if vs.Sorry != nil {
+			targets = append(targets, health.FallbackTarget{Service: vs.Service, Destination: *vs.Sorry})
+		}
+	}
+
+	return targets
+}
+
+// errorf returns a Diagnostic for n.
+func (n *node) errorf(format string, args ...any) *Diagnostic {
+	return &Diagnostic{Line: n.line, Directive: n.name, Message: fmt.Sprintf(format, args...)}
+}
+
+// skip records that n was skipped.
+func (c *Config) skip(n *node, format string, args ...any) {
+	c.Diagnostics = append(c.Diagnostics, n.errorf(format, args...))
+}
+
+// group returns the members of a virtual_server_group: "address port",
+// "address-last port", a range ending in last, or "fwmark mark".
+func (c *Config) group(n *node) ([]ipvs.Service, error) {
+	var members []ipvs.Service
+
+	for _, m := range n.children {
+		if m.name == "fwmark" {
+			if len(m.args) != 1 {
+				return nil, m.errorf("expected a firewall mark")
+			}
+			mark, err := strconv.ParseUint(m.args[0], 0, 32)
+			if err != nil {
+				return nil, m.errorf("invalid firewall mark %q", m.args[0])
+			}
+
+			members = append(members, ipvs.Service{FWMark: uint32(mark)})
+			continue
+		}
+
+		if len(m.args) != 1 || m.block {
+			c.skip(m, "unsupported group member")
+			continue
+		}
+
+		port, err := parsePort(m.args[0])
+		if err != nil {
+			return nil, m.errorf("%v", err)
+		}
+
+		addrs, err := parseRange(m.name)
+		if err != nil {
+			return nil, m.errorf("%v", err)
+		}
+		for _, addr := range addrs {
+			members = append(members, ipvs.Service{Address: addr, Port: port})
+		}
+	}
+
+	return members, nil
+}
+
+// virtualServer imports a virtual_server: "address port", "fwmark mark"
+// or "group name".
+func (c *Config) virtualServer(n *node, groups map[string][]ipvs.Service) error {
+	var members []ipvs.Service
+	var group string
+
+	switch {
+	case len(n.args) == 2 && n.args[0] == "group":
+		group = n.args[1]
+		var ok bool
+		if members, ok = groups[group]; !ok {
+			return n.errorf("unknown virtual_server_group %q", group)
+		}
+	case len(n.args) == 2 && n.args[0] == "fwmark":
+		mark, err := strconv.ParseUint(n.args[1], 0, 32)
+		if err != nil {
+			return n.errorf("invalid firewall mark %q", n.args[1])
+		}
+		members = []ipvs.Service{{FWMark: uint32(mark)}}
+	case len(n.args) == 2:
+		addr, err := netip.ParseAddr(n.args[0])
+		if err != nil || addr.Zone() != "" {
+			return n.errorf("invalid address %q", n.args[0])
+		}
+		port, err := parsePort(n.args[1])
+		if err != nil {
+			return n.errorf("%v", err)
+		}
+		members = []ipvs.Service{{Address: addr, Port: port}}
+	default:
+		return n.errorf("expected an address and port, fwmark or group")
+	}
+
+	vs, err := c.settings(n)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		svc := vs.Service
+		svc.Address, svc.Port, svc.FWMark = m.Address, m.Port, m.FWMark
+		if svc.FWMark != 0 {
+			svc.Protocol = 0
+		}
+		if err := resolve(&svc, vs.Destinations, vs.granularity); err != nil {
+			return n.errorf("%v", err)
+		}
+
+		imported := VirtualServer{
+			Line:         n.line,
+			Group:        group,
+			Service:      svc,
+			Destinations: slices.Clone(vs.Destinations),
+		}
+		if vs.Sorry != nil {
+			sorry := *vs.Sorry
+			imported.Sorry = &sorry
+		}
+		c.VirtualServers = append(c.VirtualServers, imported)
+	}
+
+	return nil
+}
+
+// settings are the directives of a virtual_server block.
+type settings struct {
+	VirtualServer
+	granularity *node
+}
+
+// settings imports the directives of the virtual_server block n, except
+// for the identity of the Service.
+func (c *Config) settings(n *node) (*settings, error) {
+	vs := &settings{}
+	vs.Service.Protocol = ipvs.TCP
+
+	// The forwarding method of the virtual server applies to the real
+	// servers which do not set their own, wherever it appears.
+	fwd := ipvs.Masquerade
+	var sorryFwd *ipvs.ForwardType
+	var destFwd []*ipvs.ForwardType
+
+	for _, d := range n.children {
+		switch d.name {
+		case "lb_algo", "lvs_sched":
+			if len(d.args) != 1 {
+				return nil, d.errorf("expected a scheduler")
+			}
+			vs.Service.Scheduler = d.args[0]
+		case "lb_kind", "lvs_method":
+			method, err := c.forwardType(d)
+			if err != nil {
+				return nil, err
+			}
+			fwd = method
+		case "persistence_timeout":
+			vs.Service.Flags |= ipvs.ServicePersistent
+			vs.Service.Timeout = defaultPersistenceTimeout
+			if len(d.args) > 0 {
+				timeout, err := strconv.ParseUint(d.args[0], 10, 32)
+				if err != nil {
+					return nil, d.errorf("invalid timeout %q", d.args[0])
+				}
+				vs.Service.Timeout = uint32(timeout)
+			}
+		case "persistence_granularity":
+			if len(d.args) != 1 {
+				return nil, d.errorf("expected a netmask")
+			}
+			vs.granularity = d
+		case "protocol":
+			if len(d.args) != 1 {
+				return nil, d.errorf("expected a protocol")
+			}
+			proto, ok := protocols[strings.ToUpper(d.args[0])]
+			if !ok {
+				return nil, d.errorf("unknown protocol %q", d.args[0])
+			}
+			vs.Service.Protocol = proto
+		case "sorry_server":
+			dest, err := parseDestination(d)
+			if err != nil {
+				return nil, err
+			}
+			dest.Weight = 1
+			vs.Sorry = &dest
+		case "sorry_server_lvs_method":
+			method, err := c.forwardType(d)
+			if err != nil {
+				return nil, err
+			}
+			sorryFwd = &method
+		case "real_server":
+			dest, method, err := c.realServer(d)
+			if err != nil {
+				return nil, err
+			}
+			vs.Destinations = append(vs.Destinations, dest)
+			destFwd = append(destFwd, method)
+		default:
+			c.skip(d, "unsupported directive")
+		}
+	}
+
+	for i := range vs.Destinations {
+		vs.Destinations[i].FwdMethod = fwd
+		if destFwd[i] != nil {
+			vs.Destinations[i].FwdMethod = *destFwd[i]
+		}
+	}
+	if vs.Sorry != nil {
+		vs.Sorry.FwdMethod = fwd
+		if sorryFwd != nil {
+			vs.Sorry.FwdMethod = *sorryFwd
+		}
+	}
+
+	return vs, nil
+}
+
+// protocols are the protocols of virtual servers, by name.
+var protocols = map[string]ipvs.Protocol{
+	"TCP":  ipvs.TCP,
+	"UDP":  ipvs.UDP,
+	"SCTP": ipvs.SCTP,
+}
+
+// forwardTypes are the forwarding methods of lb_kind, by name.
+var forwardTypes = map[string]ipvs.ForwardType{
+	"NAT": ipvs.Masquerade,
+	"DR":  ipvs.DirectRoute,
+	"TUN": ipvs.Tunnel,
+}
+
+// forwardType imports an lb_kind directive. The options of tunnels are
+// skipped.
+func (c *Config) forwardType(d *node) (ipvs.ForwardType, error) {
+	if len(d.args) == 0 {
+		return 0, d.errorf("expected a forwarding method")
+	}
+
+	method, ok := forwardTypes[strings.ToUpper(d.args[0])]
+	if !ok {
+		return 0, d.errorf("unknown forwarding method %q", d.args[0])
+	}
+	if len(d.args) > 1 {
+		c.skip(d, "unsupported options %s", strings.Join(d.args[1:], " "))
+	}
+
+	return method, nil
+}
+
+// realServer imports a real_server block, returning its forwarding
+// method if it sets one.
+func (c *Config) realServer(n *node) (ipvs.Destination, *ipvs.ForwardType, error) {
+	dest, err := parseDestination(n)
+	if err != nil {
+		return ipvs.Destination{}, nil, err
+	}
+	dest.Weight = 1
+
+	var fwd *ipvs.ForwardType
+	for _, d := range n.children {
+		switch d.name {
+		case "weight":
+			if len(d.args) != 1 {
+				return ipvs.Destination{}, nil, d.errorf("expected a weight")
+			}
+			weight, err := strconv.ParseUint(d.args[0], 10, 31)
+			if err != nil {
+				return ipvs.Destination{}, nil, d.errorf("invalid weight %q", d.args[0])
+			}
+			dest.Weight = uint32(weight)
+		case "lb_kind", "lvs_method":
+			method, err := c.forwardType(d)
+			if err != nil {
+				return ipvs.Destination{}, nil, err
+			}
+			fwd = &method
+		default:
+			c.skip(d, "unsupported directive")
+		}
+	}
+
+	return dest, fwd, nil
+}
+
+// parseDestination parses the "address [port]" arguments of a real or
+// sorry server.
+func parseDestination(n *node) (ipvs.Destination, error) {
+	if len(n.args) != 1 && len(n.args) != 2 {
+		return ipvs.Destination{}, n.errorf("expected an address and port")
+	}
+
+	addr, err := netip.ParseAddr(n.args[0])
+	if err != nil || addr.Zone() != "" {
+		return ipvs.Destination{}, n.errorf("invalid address %q", n.args[0])
+	}
+
+	var port uint16
+	if len(n.args) == 2 {
+		if port, err = parsePort(n.args[1]); err != nil {
+			return ipvs.Destination{}, n.errorf("%v", err)
+		}
+	}
+
+	return ipvs.Destination{Address: addr, Port: port, Family: family(addr)}, nil
+}
+
+// resolve sets the Family and Netmask of svc. The family of firewall-mark
+// services is that of their destinations, as in keepalived.
+func resolve(svc *ipvs.Service, dests []ipvs.Destination, granularity *node) error {
+	switch {
+	case svc.FWMark == 0:
+		svc.Family = family(svc.Address)
+	case len(dests) > 0:
+		svc.Family = dests[0].Family
+	default:
+		svc.Family = ipvs.INET
+	}
+
+	if granularity == nil {
+		return nil
+	}
+
+	mask := granularity.args[0]
+	if svc.Family == ipvs.INET {
+		addr, err := netip.ParseAddr(mask)
+		if err != nil || !addr.Is4() {
+			return fmt.Errorf("invalid persistence_granularity %q", mask)
+		}
+		m, ok := netmask.MaskFromSlice(addr.AsSlice())
+		if !ok {
+			return fmt.Errorf("invalid persistence_granularity %q", mask)
+		}
+		svc.Netmask = m
+		return nil
+	}
+
+	ones, err := strconv.Atoi(mask)
+	if err != nil || ones < 0 || ones > 128 {
+		return fmt.Errorf("invalid persistence_granularity %q", mask)
+	}
+	svc.Netmask = netmask.MaskFrom(ones, 128)
+	return nil
+}
+
+// family returns the address family of addr.
+func family(addr netip.Addr) ipvs.AddressFamily {
+	if addr.Is4() {
+		return ipvs.INET
+	}
+
+	return ipvs.INET6
+}
+
+// parsePort parses a port number.
+func parsePort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+
+	return uint16(port), nil
+}
+
+// maxRange is the most addresses a group range may expand to.
+const maxRange = 256
+
+// parseRange parses an address, or a range of addresses such as
+// "192.0.2.1-10" or "2001:db8::1-ff", where the last octet of an IPv4
+// address, or the last group of an IPv6 address, ends the range.
+func parseRange(s string) ([]netip.Addr, error) {
+	s, last, isRange := strings.Cut(s, "-")
+
+	first, err := netip.ParseAddr(s)
+	if err != nil || first.Zone() != "" {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	if !isRange {
+		return []netip.Addr{first}, nil
+	}
+
+	b := first.AsSlice()
+	var from, to uint64
+	if first.Is4() {
+		from = uint64(b[3])
+		to, err = strconv.ParseUint(last, 10, 8)
+	} else {
+		from = uint64(b[14])<<8 | uint64(b[15])
+		to, err = strconv.ParseUint(last, 16, 16)
+	}
+	if err != nil || to < from || to-from >= maxRange {
+		return nil, fmt.Errorf("invalid range %q", s+"-"+last)
+	}
+
+	var addrs []netip.Addr
+	for addr := first; ; addr = addr.Next() {
+		addrs = append(addrs, addr)
+		if len(addrs) > int(to-from) {
+			break
+		}
+	}
+
+	return addrs, nil
+}
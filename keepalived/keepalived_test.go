@@ -0,0 +1,168 @@
+package keepalived
+
+import (
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/health"
+	"github.com/cloudflare/ipvs/ipvstest"
+	"github.com/cloudflare/ipvs/netmask"
+	"github.com/cloudflare/ipvs/snapshot"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+)
+
+var cmpAddr = cmp.Comparer(func(x, y netip.Addr) bool { return x == y })
+
+func TestParse(t *testing.T) {
+	f, err := os.Open("testdata/keepalived.conf")
+	assert.NilError(t, err)
+	defer f.Close()
+
+	c, err := Parse(f)
+	assert.NilError(t, err)
+
+	web := ipvs.Service{
+		Address:   netip.MustParseAddr("192.0.2.1"),
+		Port:      80,
+		Family:    ipvs.INET,
+		Protocol:  ipvs.TCP,
+		Scheduler: ipvs.WeightedRoundRobin,
+		Flags:     ipvs.ServicePersistent,
+		Timeout:   300,
+		Netmask:   netmask.MaskFrom(24, 32),
+	}
+	dns := ipvs.Service{Port: 53, Protocol: ipvs.UDP, Scheduler: ipvs.RoundRobin}
+	dnsDest := []ipvs.Destination{{Address: netip.MustParseAddr("198.51.100.53"), Port: 53, Family: ipvs.INET, Weight: 1}}
+
+	expected := []VirtualServer{
+		{
+			Line:    20,
+			Service: web,
+			Destinations: []ipvs.Destination{
+				{Address: netip.MustParseAddr("198.51.100.1"), Port: 8080, Family: ipvs.INET, Weight: 10},
+				{Address: netip.MustParseAddr("198.51.100.2"), Port: 8080, Family: ipvs.INET, FwdMethod: ipvs.DirectRoute},
+			},
+			Sorry: &ipvs.Destination{Address: netip.MustParseAddr("203.0.113.1"), Port: 80, Family: ipvs.INET, Weight: 1},
+		},
+		{Line: 47, Group: "dns", Service: with(dns, "192.0.2.53"), Destinations: dnsDest},
+		{Line: 47, Group: "dns", Service: with(dns, "192.0.2.54"), Destinations: dnsDest},
+		{Line: 47, Group: "dns", Service: with(dns, "2001:db8::53"), Destinations: dnsDest},
+		{
+			Line: 54,
+			Service: ipvs.Service{
+				FWMark:    42,
+				Family:    ipvs.INET6,
+				Scheduler: ipvs.LeastConnection,
+				Flags:     ipvs.ServicePersistent,
+				Timeout:   defaultPersistenceTimeout,
+				Netmask:   netmask.MaskFrom(64, 128),
+			},
+			Destinations: []ipvs.Destination{
+				{Address: netip.MustParseAddr("2001:db8::10"), Family: ipvs.INET6, Weight: 1, FwdMethod: ipvs.DirectRoute},
+			},
+		},
+	}
+	assert.DeepEqual(t, c.VirtualServers, expected, cmpAddr)
+
+	var diagnostics []string
+	for _, d := range c.Diagnostics {
+		diagnostics = append(diagnostics, d.Error())
+	}
+	assert.DeepEqual(t, diagnostics, []string{
+		"keepalived: line 21: delay_loop: unsupported directive",
+		"keepalived: line 32: TCP_CHECK: unsupported directive",
+	})
+
+	for _, vs := range c.VirtualServers {
+		assert.NilError(t, vs.Service.Validate())
+	}
+	assert.NilError(t, snapshot.Restore(ipvstest.New(), c.Snapshot()))
+
+	assert.DeepEqual(t, c.FallbackTargets(), []health.FallbackTarget{
+		{Service: web, Destination: *expected[0].Sorry},
+	}, cmpAddr)
+}
+
+func TestLex_Comments(t *testing.T) {
+	tokens, err := lex(strings.NewReader("# comment\nauth_pass s3cr#t! # comment\nnotify /etc/keepalived/notify!.sh\n  !comment {\nlb_algo rr{#comment\n"))
+	assert.NilError(t, err)
+
+	var words []string
+	for _, tok := range tokens {
+		words = append(words, tok.text)
+	}
+	assert.DeepEqual(t, words, []string{"auth_pass", "s3cr#t!", "notify", "/etc/keepalived/notify!.sh", "lb_algo", "rr", "{"})
+}
+
+// with returns svc with its address set to addr.
+func with(svc ipvs.Service, addr string) ipvs.Service {
+	svc.Address = netip.MustParseAddr(addr)
+	svc.Family = family(svc.Address)
+	return svc
+}
+
+func TestParse_Errors(t *testing.T) {
+	type testCase struct {
+		name     string
+		conf     string
+		expected string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		_, err := Parse(strings.NewReader(tc.conf))
+		assert.Error(t, err, tc.expected)
+	}
+
+	testCases := []testCase{
+		{
+			name:     "unterminated",
+			conf:     "virtual_server 192.0.2.1 80 {\n  lb_algo rr\n",
+			expected: "keepalived: line 1: virtual_server: unterminated block",
+		},
+		{
+			name:     "unexpected brace",
+			conf:     "}\n",
+			expected: `keepalived: line 1: unexpected "}"`,
+		},
+		{
+			name:     "address",
+			conf:     "virtual_server 192.0.2.300 80 {\n}\n",
+			expected: `keepalived: line 1: virtual_server: invalid address "192.0.2.300"`,
+		},
+		{
+			name:     "unknown group",
+			conf:     "virtual_server group web {\n}\n",
+			expected: `keepalived: line 1: virtual_server: unknown virtual_server_group "web"`,
+		},
+		{
+			name:     "lb_kind",
+			conf:     "virtual_server 192.0.2.1 80 {\n  lb_kind FOO\n}\n",
+			expected: `keepalived: line 2: lb_kind: unknown forwarding method "FOO"`,
+		},
+		{
+			name:     "weight",
+			conf:     "virtual_server 192.0.2.1 80 {\n  real_server 198.51.100.1 80 {\n    weight -1\n  }\n}\n",
+			expected: `keepalived: line 3: weight: invalid weight "-1"`,
+		},
+		{
+			name:     "granularity",
+			conf:     "virtual_server 2001:db8::1 80 {\n  persistence_granularity 255.255.255.0\n}\n",
+			expected: `keepalived: line 1: virtual_server: invalid persistence_granularity "255.255.255.0"`,
+		},
+		{
+			name:     "range",
+			conf:     "virtual_server_group g {\n  192.0.2.10-5 80\n}\n",
+			expected: `keepalived: line 2: 192.0.2.10-5: invalid range "192.0.2.10-5"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
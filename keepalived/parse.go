@@ -0,0 +1,127 @@
+package keepalived
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// node is a directive, with its arguments and block, if any.
+type node struct {
+	line     int
+	name     string
+	args     []string
+	block    bool
+	children []*node
+}
+
+// token is a word of a configuration file.
+type token struct {
+	line int
+	text string
+}
+
+// lex splits r into words, on whitespace and braces, dropping comments.
+// A word starting with "#" or "!" starts a comment, which runs to the end
+// of the line; elsewhere in a word, such as in a script argument or
+// password, they are kept.
+func lex(r io.Reader) ([]token, error) {
+	var tokens []token
+
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.NewReplacer("{", " { ", "}", " } ").Replace(s.Text())
+		for _, word := range strings.Fields(text) {
+			if strings.HasPrefix(word, "#") || strings.HasPrefix(word, "!") {
+				break
+			}
+			tokens = append(tokens, token{line, word})
+		}
+	}
+
+	return tokens, s.Err()
+}
+
+// parse returns the directives of a configuration file. A directive is
+// the words on a line; a "{" on its line, or starting the next, opens its
+// block.
+func parse(tokens []token) ([]*node, error) {
+	p := &parser{tokens: tokens}
+
+	nodes, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, &Diagnostic{Line: p.tokens[p.pos].line, Message: `unexpected "}"`}
+	}
+
+	return nodes, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// block parses directives until a "}" or the end of the file.
+func (p *parser) block() ([]*node, error) {
+	var nodes []*node
+
+	for p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		switch t.text {
+		case "}":
+			return nodes, nil
+		case "{":
+			if len(nodes) == 0 || nodes[len(nodes)-1].block {
+				return nil, &Diagnostic{Line: t.line, Message: `unexpected "{"`}
+			}
+
+			n := nodes[len(nodes)-1]
+			if err := p.children(n); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		n := &node{line: t.line, name: t.text}
+		p.pos++
+		for p.pos < len(p.tokens) && p.tokens[p.pos].line == t.line {
+			word := p.tokens[p.pos].text
+			if word == "}" {
+				break
+			}
+			if word == "{" {
+				if err := p.children(n); err != nil {
+					return nil, err
+				}
+				break
+			}
+
+			n.args = append(n.args, word)
+			p.pos++
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+// children parses the block of n, starting at its "{".
+func (p *parser) children(n *node) error {
+	p.pos++
+	children, err := p.block()
+	if err != nil {
+		return err
+	}
+	if p.pos == len(p.tokens) {
+		return &Diagnostic{Line: n.line, Directive: n.name, Message: "unterminated block"}
+	}
+
+	p.pos++
+	n.block = true
+	n.children = children
+	return nil
+}
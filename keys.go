@@ -0,0 +1,312 @@
+package ipvs
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ServiceKey identifies a Service, as IPVS does: by its Protocol, Address,
+// and Port, or by its FWMark, along with its address family.
+//
+// Unlike Service, keys are equal whenever they identify the same Service,
+// so they can be compared with == and used as map keys. The zero value
+// identifies no Service.
+type ServiceKey struct {
+	family   AddressFamily
+	protocol Protocol
+	addr     netip.Addr
+	port     uint16
+	fwmark   uint32
+}
+
+// ServiceKeyFrom returns the key of the Service listening on addr with
+// protocol. IPv4-mapped IPv6 addresses are unmapped.
+func ServiceKeyFrom(protocol Protocol, addr netip.AddrPort) ServiceKey {
+	svc := Service{
+		Address:  addr.Addr(),
+		Port:     addr.Port(),
+		Protocol: protocol,
+	}
+
+	return svc.Key()
+}
+
+// FWMarkKey returns the key of the firewall-mark Service for mark and family.
+func FWMarkKey(mark uint32, family AddressFamily) ServiceKey {
+	return ServiceKey{family: family, fwmark: mark}
+}
+
+// Key returns the key identifying svc. The address family is inferred
+// from the Address when it is unset, as with ResolveFamily.
+//
+// If the family cannot be resolved, such as for a firewall-mark Service
+// without a Family, the key is left without one, and may not match the
+// key of the configured Service. Use KeyOf for Services which were not
+// returned by a Client.
+func (svc Service) Key() ServiceKey {
+	k, _ := KeyOf(svc)
+	return k
+}
+
+// KeyOf returns the key identifying svc, as Service.Key does, along with
+// the error of ResolveFamily if the address family cannot be resolved.
+func KeyOf(svc Service) (ServiceKey, error) {
+	svc, err := svc.ResolveFamily()
+	if svc.FWMark != 0 {
+		return FWMarkKey(svc.FWMark, svc.Family), err
+	}
+
+	return ServiceKey{
+		family:   svc.Family,
+		protocol: svc.Protocol,
+		addr:     svc.Address,
+		port:     svc.Port,
+	}, err
+}
+
+// Family returns the address family of the Service.
+func (k ServiceKey) Family() AddressFamily { return k.family }
+
+// Protocol returns the protocol of the Service, or zero for firewall-mark services.
+func (k ServiceKey) Protocol() Protocol { return k.protocol }
+
+// AddrPort returns the address and port of the Service, or the zero
+// AddrPort for firewall-mark services.
+func (k ServiceKey) AddrPort() netip.AddrPort { return netip.AddrPortFrom(k.addr, k.port) }
+
+// FWMark returns the firewall mark of the Service, or zero.
+func (k ServiceKey) FWMark() uint32 { return k.fwmark }
+
+// IsValid reports whether k identifies a Service.
+func (k ServiceKey) IsValid() bool {
+	return k.fwmark != 0 || k.addr.IsValid()
+}
+
+// Service returns a Service with only the identifying fields set, suitable
+// for passing to the methods of a Client.
+func (k ServiceKey) Service() Service {
+	return Service{
+		Address:  k.addr,
+		Port:     k.port,
+		FWMark:   k.fwmark,
+		Family:   k.family,
+		Protocol: k.protocol,
+	}
+}
+
+// Compare returns an integer comparing k and o, ordering firewall-mark
+// services after the others, and then by address family, protocol,
+// address, port, and mark. The result is 0 if k == o, -1 if k < o,
+// and +1 if k > o.
+func (k ServiceKey) Compare(o ServiceKey) int {
+	return cmp.Or(
+		cmp.Compare(min(k.fwmark, 1), min(o.fwmark, 1)),
+		cmp.Compare(k.family, o.family),
+		cmp.Compare(k.protocol, o.protocol),
+		k.addr.Compare(o.addr),
+		cmp.Compare(k.port, o.port),
+		cmp.Compare(k.fwmark, o.fwmark),
+	)
+}
+
+// String returns the key in the form "tcp:192.0.2.1:80", "udp:[2001:db8::1]:53",
+// or "fwm:42" for firewall-mark services. The family of IPv6 firewall-mark
+// services is appended, as in "fwm:42/inet6". The zero key is the empty string.
+//
+// Protocols are written by name, in lower case, or by number.
+func (k ServiceKey) String() string {
+	switch {
+	case k.fwmark != 0:
+		s := "fwm:" + strconv.FormatUint(uint64(k.fwmark), 10)
+		if k.family != INET {
+			s += "/" + strings.ToLower(string(marshalEnum(k.family, addressFamilies)))
+		}
+		return s
+	case !k.addr.IsValid():
+		return ""
+	}
+
+	return strings.ToLower(string(marshalEnum(k.protocol, protocols))) + ":" + k.AddrPort().String()
+}
+
+// ParseServiceKey parses a key in the form returned by String.
+func ParseServiceKey(s string) (ServiceKey, error) {
+	kind, rest, ok := strings.Cut(s, ":")
+	if !ok {
+		return ServiceKey{}, fmt.Errorf("ipvs: invalid service key %q", s)
+	}
+
+	if kind == "fwm" {
+		mark, family, _ := strings.Cut(rest, "/")
+
+		k := FWMarkKey(0, INET)
+		if family != "" {
+			if err := k.family.UnmarshalText([]byte(family)); err != nil {
+				return ServiceKey{}, fmt.Errorf("ipvs: invalid service key %q: %w", s, err)
+			}
+		}
+
+		n, err := strconv.ParseUint(mark, 10, 32)
+		if err != nil || n == 0 {
+			return ServiceKey{}, fmt.Errorf("ipvs: invalid firewall mark in service key %q", s)
+		}
+		k.fwmark = uint32(n)
+
+		return k, nil
+	}
+
+	var protocol Protocol
+	if err := protocol.UnmarshalText([]byte(kind)); err != nil {
+		return ServiceKey{}, fmt.Errorf("ipvs: invalid service key %q: %w", s, err)
+	}
+
+	addr, err := netip.ParseAddrPort(rest)
+	if err != nil {
+		return ServiceKey{}, fmt.Errorf("ipvs: invalid service key %q: %w", s, err)
+	}
+
+	// Unlike ServiceKeyFrom, IPv4-mapped IPv6 addresses are kept as written.
+	svc := Service{
+		Address:  addr.Addr(),
+		Port:     addr.Port(),
+		Family:   familyOf(addr.Addr()),
+		Protocol: protocol,
+	}
+
+	return svc.Key(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler, using the form returned by String.
+func (k ServiceKey) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. Empty text is the zero key.
+func (k *ServiceKey) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*k = ServiceKey{}
+		return nil
+	}
+
+	key, err := ParseServiceKey(string(text))
+	if err != nil {
+		return err
+	}
+
+	*k = key
+	return nil
+}
+
+// DestinationKey identifies a Destination within its Service, as IPVS
+// does: by its Address and Port.
+//
+// Like ServiceKey, keys can be compared with == and used as map keys.
+// To identify a Destination across Services, pair it with a ServiceKey.
+type DestinationKey struct {
+	addr netip.AddrPort
+}
+
+// DestinationKeyFrom returns the key of the Destination at addr.
+// IPv4-mapped IPv6 addresses are unmapped.
+func DestinationKeyFrom(addr netip.AddrPort) DestinationKey {
+	dest := Destination{
+		Address: addr.Addr(),
+		Port:    addr.Port(),
+	}
+
+	return dest.Key()
+}
+
+// Key returns the key identifying dest within its Service. IPv4-mapped
+// IPv6 addresses are unmapped, unless the Family is INET6, as with
+// ResolveFamily.
+func (dest Destination) Key() DestinationKey {
+	dest, _ = dest.ResolveFamily()
+	return DestinationKey{netip.AddrPortFrom(dest.Address, dest.Port)}
+}
+
+// AddrPort returns the address and port of the Destination.
+func (k DestinationKey) AddrPort() netip.AddrPort { return k.addr }
+
+// IsValid reports whether k identifies a Destination.
+func (k DestinationKey) IsValid() bool { return k.addr.Addr().IsValid() }
+
+// Destination returns a Destination with only the identifying fields set,
+// suitable for passing to the methods of a Client.
+func (k DestinationKey) Destination() Destination {
+	return Destination{
+		Address: k.addr.Addr(),
+		Port:    k.addr.Port(),
+		Family:  familyOf(k.addr.Addr()),
+	}
+}
+
+// Compare returns an integer comparing k and o by address, then port.
+// The result is 0 if k == o, -1 if k < o, and +1 if k > o.
+func (k DestinationKey) Compare(o DestinationKey) int {
+	return k.addr.Compare(o.addr)
+}
+
+// String returns the key in the form "192.0.2.1:80" or "[2001:db8::1]:80".
+// The zero key is the empty string.
+func (k DestinationKey) String() string {
+	if !k.IsValid() {
+		return ""
+	}
+
+	return k.addr.String()
+}
+
+// ParseDestinationKey parses a key in the form returned by String.
+func ParseDestinationKey(s string) (DestinationKey, error) {
+	addr, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return DestinationKey{}, fmt.Errorf("ipvs: invalid destination key %q: %w", s, err)
+	}
+
+	// Unlike DestinationKeyFrom, IPv4-mapped IPv6 addresses are kept as written.
+	dest := Destination{
+		Address: addr.Addr(),
+		Port:    addr.Port(),
+		Family:  familyOf(addr.Addr()),
+	}
+
+	return dest.Key(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler, using the form returned by String.
+func (k DestinationKey) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. Empty text is the zero key.
+func (k *DestinationKey) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*k = DestinationKey{}
+		return nil
+	}
+
+	key, err := ParseDestinationKey(string(text))
+	if err != nil {
+		return err
+	}
+
+	*k = key
+	return nil
+}
+
+// familyOf returns the address family of addr, treating IPv4-mapped IPv6
+// addresses as IPv6, or zero for the zero Addr.
+func familyOf(addr netip.Addr) AddressFamily {
+	switch {
+	case addr.Is4():
+		return INET
+	case addr.Is6():
+		return INET6
+	}
+
+	return 0
+}
@@ -0,0 +1,189 @@
+package ipvs
+
+import (
+	"encoding/json"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+	"pgregory.net/rapid"
+)
+
+func TestServiceKey_String(t *testing.T) {
+	type testCase struct {
+		name     string
+		svc      Service
+		expected string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		k := tc.svc.Key()
+		assert.Equal(t, k.String(), tc.expected)
+
+		parsed, err := ParseServiceKey(tc.expected)
+		assert.NilError(t, err)
+		assert.Equal(t, parsed, k)
+	}
+
+	testCases := []testCase{
+		{
+			name:     "ipv4",
+			svc:      Service{Address: netip.MustParseAddr("192.0.2.1"), Port: 80, Protocol: TCP, Scheduler: "rr"},
+			expected: "tcp:192.0.2.1:80",
+		},
+		{
+			name:     "ipv6",
+			svc:      Service{Address: netip.MustParseAddr("::1"), Port: 80, Protocol: TCP, Family: INET6},
+			expected: "tcp:[::1]:80",
+		},
+		{
+			name:     "mapped",
+			svc:      Service{Address: netip.MustParseAddr("::ffff:192.0.2.1"), Port: 53, Protocol: UDP},
+			expected: "udp:192.0.2.1:53",
+		},
+		{
+			name:     "sctp",
+			svc:      Service{Address: netip.MustParseAddr("2001:db8::1"), Port: 3868, Protocol: SCTP},
+			expected: "sctp:[2001:db8::1]:3868",
+		},
+		{
+			name:     "unknown protocol",
+			svc:      Service{Address: netip.MustParseAddr("192.0.2.1"), Protocol: 47},
+			expected: "47:192.0.2.1:0",
+		},
+		{
+			name:     "fwmark",
+			svc:      Service{FWMark: 42, Family: INET, Port: 80},
+			expected: "fwm:42",
+		},
+		{
+			name:     "fwmark ipv6",
+			svc:      Service{FWMark: 42, Family: INET6},
+			expected: "fwm:42/inet6",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestParseServiceKey_Invalid(t *testing.T) {
+	for _, s := range []string{"", "tcp", "icmp:192.0.2.1:80", "tcp:192.0.2.1", "fwm:0", "fwm:x", "fwm:1/ipx"} {
+		_, err := ParseServiceKey(s)
+		assert.ErrorContains(t, err, "ipvs: invalid", s)
+	}
+}
+
+func TestServiceKey_MapKey(t *testing.T) {
+	svc := Service{Address: netip.MustParseAddr("192.0.2.1"), Port: 80, Protocol: TCP, Family: INET}
+	updated := svc
+	updated.Scheduler = MaglevHashing
+	updated.Flags = ServicePersistent
+
+	m := map[ServiceKey]int{svc.Key(): 1}
+	m[updated.Key()]++
+	assert.DeepEqual(t, m, map[ServiceKey]int{svc.Key(): 2})
+
+	b, err := json.Marshal(m)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), `{"tcp:192.0.2.1:80":2}`)
+
+	var out map[ServiceKey]int
+	assert.NilError(t, json.Unmarshal(b, &out))
+	assert.DeepEqual(t, out, m)
+}
+
+var serviceKeyGen = rapid.Custom(func(t *rapid.T) ServiceKey {
+	if rapid.Bool().Draw(t, "fwmark") {
+		family := rapid.SampledFrom([]AddressFamily{INET, INET6}).Draw(t, "Family")
+		return FWMarkKey(rapid.Uint32Min(1).Draw(t, "FWMark"), family)
+	}
+
+	addr := addrGen.Filter(netip.Addr.IsValid).Draw(t, "Address")
+	protocol := Protocol(rapid.Uint16().Draw(t, "Protocol"))
+	svc := Service{
+		Address:  addr,
+		Port:     rapid.Uint16().Draw(t, "Port"),
+		Family:   familyOf(addr),
+		Protocol: protocol,
+	}
+
+	return svc.Key()
+})
+
+func TestServiceKey_RoundTrip(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		k := serviceKeyGen.Draw(t, "k")
+
+		parsed, err := ParseServiceKey(k.String())
+		assert.NilError(t, err)
+		assert.Equal(t, parsed, k)
+		assert.Equal(t, k.Service().Key(), k)
+	})
+}
+
+func TestServiceKey_Compare(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		keys := rapid.SliceOfN(serviceKeyGen, 3, 3).Draw(t, "keys")
+		a, b, c := keys[0], keys[1], keys[2]
+
+		assert.Equal(t, a.Compare(b), -b.Compare(a))
+		assert.Equal(t, a.Compare(b) == 0, a == b)
+		if a.Compare(b) <= 0 && b.Compare(c) <= 0 {
+			assert.Assert(t, a.Compare(c) <= 0)
+		}
+	})
+
+	keys := []ServiceKey{
+		FWMarkKey(1, INET),
+		ServiceKeyFrom(UDP, netip.MustParseAddrPort("192.0.2.1:53")),
+		ServiceKeyFrom(TCP, netip.MustParseAddrPort("[2001:db8::1]:80")),
+		ServiceKeyFrom(TCP, netip.MustParseAddrPort("192.0.2.1:80")),
+	}
+	slices.SortFunc(keys, ServiceKey.Compare)
+	assert.DeepEqual(t, keys, []ServiceKey{
+		ServiceKeyFrom(TCP, netip.MustParseAddrPort("192.0.2.1:80")),
+		ServiceKeyFrom(UDP, netip.MustParseAddrPort("192.0.2.1:53")),
+		ServiceKeyFrom(TCP, netip.MustParseAddrPort("[2001:db8::1]:80")),
+		FWMarkKey(1, INET),
+	}, cmp.Comparer(func(x, y ServiceKey) bool { return x == y }))
+}
+
+func TestDestinationKey(t *testing.T) {
+	dest := Destination{Address: netip.MustParseAddr("::ffff:192.0.2.10"), Port: 8080, Weight: 1}
+	k := dest.Key()
+	assert.Equal(t, k.String(), "192.0.2.10:8080")
+	assert.Equal(t, k, DestinationKeyFrom(netip.MustParseAddrPort("192.0.2.10:8080")))
+	assert.Equal(t, k.Destination().Family, INET)
+
+	mapped := Destination{Address: netip.MustParseAddr("::ffff:192.0.2.10"), Port: 8080, Family: INET6}
+	assert.Assert(t, mapped.Key() != k)
+
+	for _, d := range []Destination{dest, mapped, {Address: netip.MustParseAddr("2001:db8::10"), Port: 80}} {
+		parsed, err := ParseDestinationKey(d.Key().String())
+		assert.NilError(t, err)
+		assert.Equal(t, parsed, d.Key())
+		assert.Equal(t, parsed.Destination().Key(), d.Key())
+	}
+
+	_, err := ParseDestinationKey("192.0.2.10")
+	assert.ErrorContains(t, err, "ipvs: invalid destination key")
+}
+
+func TestKeyOf(t *testing.T) {
+	k, err := KeyOf(Service{FWMark: 42, Family: INET6})
+	assert.NilError(t, err)
+	assert.Equal(t, k, FWMarkKey(42, INET6))
+
+	k, err = KeyOf(Service{Address: netip.MustParseAddr("192.0.2.1"), Port: 80, Protocol: TCP})
+	assert.NilError(t, err)
+	assert.Equal(t, k, ServiceKeyFrom(TCP, netip.MustParseAddrPort("192.0.2.1:80")))
+
+	_, err = KeyOf(Service{FWMark: 42})
+	assert.ErrorContains(t, err, "ipvs: Family: is required for firewall-mark services")
+}
@@ -0,0 +1,222 @@
+package ipvs
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The enumerations, Flags and TunnelFlags implement encoding.TextMarshaler and
+// encoding.TextUnmarshaler, so they are encoded by name in JSON, and by
+// any other encoding which uses them, such as most YAML libraries.
+// Values without a name are encoded as numbers, so every value round-trips.
+
+// enum is implemented by the enumerations generated with stringer.
+type enum interface {
+	~uint8 | ~uint16 | ~uint32
+	String() string
+}
+
+// Named values of each enumeration.
+var (
+	forwardTypes    = []ForwardType{Masquerade, Local, Tunnel, DirectRoute, Bypass}
+	addressFamilies = []AddressFamily{INET, INET6}
+	protocols       = []Protocol{TCP, UDP, SCTP}
+	tunnelTypes     = []TunnelType{IPIP, GUE, GRE}
+)
+
+// marshalEnum returns the name of v, or its number if v is not in named.
+func marshalEnum[T enum](v T, named []T) []byte {
+	if slices.Contains(named, v) {
+		return []byte(v.String())
+	}
+
+	return strconv.AppendUint(nil, uint64(v), 10)
+}
+
+// unmarshalEnum parses the name of a value in named, ignoring case,
+// or a number of at most bits bits, into v. Empty text is the zero value.
+func unmarshalEnum[T enum](text []byte, v *T, named []T, bits int) error {
+	s := string(text)
+	if s == "" {
+		*v = 0
+		return nil
+	}
+
+	for _, n := range named {
+		if strings.EqualFold(n.String(), s) {
+			*v = n
+			return nil
+		}
+	}
+
+	n, err := strconv.ParseUint(s, 0, bits)
+	if err != nil {
+		return fmt.Errorf("ipvs: invalid %T %q", *v, s)
+	}
+
+	*v = T(n)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (i ForwardType) MarshalText() ([]byte, error) {
+	return marshalEnum(i, forwardTypes), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (i *ForwardType) UnmarshalText(text []byte) error {
+	return unmarshalEnum(text, i, forwardTypes, 32)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (i AddressFamily) MarshalText() ([]byte, error) {
+	return marshalEnum(i, addressFamilies), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (i *AddressFamily) UnmarshalText(text []byte) error {
+	return unmarshalEnum(text, i, addressFamilies, 16)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (i Protocol) MarshalText() ([]byte, error) {
+	return marshalEnum(i, protocols), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (i *Protocol) UnmarshalText(text []byte) error {
+	return unmarshalEnum(text, i, protocols, 16)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (i TunnelType) MarshalText() ([]byte, error) {
+	return marshalEnum(i, tunnelTypes), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (i *TunnelType) UnmarshalText(text []byte) error {
+	return unmarshalEnum(text, i, tunnelTypes, 8)
+}
+
+// bitFlags is implemented by the flag types, whose values are a set of
+// named bits.
+type bitFlags interface {
+	~uint16 | ~uint32
+}
+
+// flagName is the name of a flag, as returned by String.
+type flagName[T bitFlags] struct {
+	name string
+	flag T
+}
+
+// Named bits of each flag type.
+var (
+	flagNames = []flagName[Flags]{
+		{"ServicePersistent", ServicePersistent},
+		{"ServiceHashed", ServiceHashed},
+		{"ServiceOnePacket", ServiceOnePacket},
+		{"ServiceSchedulerOpt1", ServiceSchedulerOpt1},
+		{"ServiceSchedulerOpt2", ServiceSchedulerOpt2},
+		{"ServiceSchedulerOpt3", ServiceSchedulerOpt3},
+	}
+	tunnelFlagNames = []flagName[TunnelFlags]{
+		{"TunnelEncapChecksum", TunnelEncapChecksum},
+		{"TunnelEncapRemoteChecksum", TunnelEncapRemoteChecksum},
+	}
+)
+
+// formatFlags returns the names of the bits set in v, and any unnamed bits
+// as a hexadecimal number. alias, if not nil, returns the other names of
+// a bit.
+func formatFlags[T bitFlags](v T, named []flagName[T], alias func(T) []string) []string {
+	flags := []string{}
+
+	var all T
+	for _, f := range named {
+		all |= f.flag
+		if v&f.flag == 0 {
+			continue
+		}
+
+		name := f.name
+		if alias != nil {
+			if aliases := alias(f.flag); len(aliases) > 0 {
+				name += " (" + strings.Join(aliases, "/") + ")"
+			}
+		}
+		flags = append(flags, name)
+	}
+	if j := v &^ all; j != 0 {
+		flags = append(flags, fmt.Sprintf("%#x", uint64(j)))
+	}
+
+	return flags
+}
+
+// unmarshalFlags parses flags separated by "|" or "," into v. Each is the
+// name of a bit in named, ignoring case, a name accepted by alias if it is
+// not nil, or a number of at most bits bits.
+func unmarshalFlags[T bitFlags](text []byte, v *T, named []flagName[T], alias func(string) (T, bool), bits int) error {
+	var flags T
+	for _, s := range strings.FieldsFunc(string(text), func(r rune) bool { return r == '|' || r == ',' }) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		j := slices.IndexFunc(named, func(f flagName[T]) bool {
+			return strings.EqualFold(f.name, s)
+		})
+		if j >= 0 {
+			flags |= named[j].flag
+			continue
+		}
+		if alias != nil {
+			if f, ok := alias(s); ok {
+				flags |= f
+				continue
+			}
+		}
+
+		n, err := strconv.ParseUint(s, 0, bits)
+		if err != nil {
+			return fmt.Errorf("ipvs: invalid flag %q", s)
+		}
+		flags |= T(n)
+	}
+
+	*v = flags
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler. Flags are encoded by name,
+// such as "ServicePersistent | ServiceOnePacket", with unnamed flags as a
+// hexadecimal number.
+func (i Flags) MarshalText() ([]byte, error) {
+	return []byte(strings.Join(formatFlags(i, flagNames, nil), " | ")), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. Flags are separated
+// by "|" or ",", and are either names, ignoring case, the ipvsadm names of
+// scheduler-specific flags, such as "sh-port", or numbers.
+func (i *Flags) UnmarshalText(text []byte) error {
+	return unmarshalFlags(text, i, flagNames, schedulerFlagByName, 32)
+}
+
+// MarshalText implements encoding.TextMarshaler. TunnelFlags are encoded
+// as returned by String, such as
+// "TunnelEncapChecksum | TunnelEncapRemoteChecksum".
+func (i TunnelFlags) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. TunnelFlags are
+// separated by "|" or ",", and are either names, ignoring case, or numbers.
+func (i *TunnelFlags) UnmarshalText(text []byte) error {
+	return unmarshalFlags(text, i, tunnelFlagNames, func(s string) (TunnelFlags, bool) {
+		return TunnelEncapNoChecksum, strings.EqualFold(s, "TunnelEncapNoChecksum")
+	}, 16)
+}
@@ -0,0 +1,200 @@
+package ipvs
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/netip"
+	"testing"
+
+	"github.com/cloudflare/ipvs/netmask"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+	"pgregory.net/rapid"
+)
+
+// textRoundTrip checks that every value drawn from gen survives MarshalText
+// and UnmarshalText.
+func textRoundTrip[T any, P interface {
+	*T
+	encoding.TextUnmarshaler
+}](t *testing.T, gen *rapid.Generator[T]) {
+	rapid.Check(t, func(t *rapid.T) {
+		v := gen.Draw(t, "v")
+
+		text, err := any(v).(encoding.TextMarshaler).MarshalText()
+		assert.NilError(t, err)
+
+		var out T
+		assert.NilError(t, P(&out).UnmarshalText(text))
+		assert.DeepEqual(t, out, v)
+	})
+}
+
+func TestText_RoundTrip(t *testing.T) {
+	t.Run("ForwardType", func(t *testing.T) {
+		textRoundTrip(t, rapid.Map(rapid.Uint32(), func(n uint32) ForwardType { return ForwardType(n) }))
+	})
+	t.Run("AddressFamily", func(t *testing.T) {
+		textRoundTrip(t, rapid.Map(rapid.Uint16(), func(n uint16) AddressFamily { return AddressFamily(n) }))
+	})
+	t.Run("Protocol", func(t *testing.T) {
+		textRoundTrip(t, rapid.Map(rapid.Uint16(), func(n uint16) Protocol { return Protocol(n) }))
+	})
+	t.Run("TunnelType", func(t *testing.T) {
+		textRoundTrip(t, rapid.Map(rapid.Uint8(), func(n uint8) TunnelType { return TunnelType(n) }))
+	})
+	t.Run("TunnelFlags", func(t *testing.T) {
+		textRoundTrip(t, rapid.Map(rapid.Uint16(), func(n uint16) TunnelFlags { return TunnelFlags(n) }))
+	})
+	t.Run("Flags", func(t *testing.T) {
+		textRoundTrip(t, rapid.Map(rapid.Uint32(), func(n uint32) Flags { return Flags(n) }))
+	})
+}
+
+func TestText_Unmarshal(t *testing.T) {
+	var proto Protocol
+	assert.NilError(t, proto.UnmarshalText([]byte("udp")))
+	assert.Equal(t, proto, UDP)
+	assert.NilError(t, proto.UnmarshalText([]byte("0x2f")))
+	assert.Equal(t, proto, Protocol(47))
+	assert.ErrorContains(t, proto.UnmarshalText([]byte("icmp")), `invalid ipvs.Protocol "icmp"`)
+
+	var flags Flags
+	assert.NilError(t, flags.UnmarshalText([]byte("ServicePersistent, serviceonepacket|0x100")))
+	assert.Equal(t, flags, ServicePersistent|ServiceOnePacket|0x100)
+	assert.ErrorContains(t, flags.UnmarshalText([]byte("ServiceSticky")), `invalid flag "ServiceSticky"`)
+
+	both := TunnelEncapChecksum | TunnelEncapRemoteChecksum
+	text, err := both.MarshalText()
+	assert.NilError(t, err)
+	assert.Equal(t, string(text), "TunnelEncapChecksum | TunnelEncapRemoteChecksum")
+
+	var tunnelFlags TunnelFlags
+	assert.NilError(t, tunnelFlags.UnmarshalText(text))
+	assert.Equal(t, tunnelFlags, both)
+	assert.NilError(t, tunnelFlags.UnmarshalText([]byte("TunnelEncapNoChecksum")))
+	assert.Equal(t, tunnelFlags, TunnelEncapNoChecksum)
+	assert.NilError(t, tunnelFlags.UnmarshalText([]byte("tunnelencapchecksum,0x10")))
+	assert.Equal(t, tunnelFlags, TunnelEncapChecksum|0x10)
+	assert.Equal(t, tunnelFlags.String(), "TunnelEncapChecksum | 0x10")
+}
+
+var addrGen = rapid.Custom(func(t *rapid.T) netip.Addr {
+	n := rapid.SampledFrom([]int{0, 4, 16}).Draw(t, "len")
+	addr, _ := netip.AddrFromSlice(rapid.SliceOfN(rapid.Byte(), n, n).Draw(t, "bytes"))
+	return addr
+})
+
+func TestJSON_RoundTrip(t *testing.T) {
+	t.Run("ServiceExtended", func(t *testing.T) {
+		rapid.Check(t, func(t *rapid.T) {
+			var mask netmask.Mask
+			switch rapid.IntRange(0, 2).Draw(t, "mask") {
+			case 1:
+				mask = netmask.MaskFrom4([4]byte(rapid.SliceOfN(rapid.Byte(), 4, 4).Draw(t, "Netmask")))
+			case 2:
+				mask = netmask.MaskFrom(rapid.IntRange(0, 128).Draw(t, "ones"), 128)
+			}
+
+			svc := ServiceExtended{
+				Service: Service{
+					Address:   addrGen.Draw(t, "Address"),
+					Netmask:   mask,
+					Scheduler: rapid.String().Draw(t, "Scheduler"),
+					Timeout:   rapid.Uint32().Draw(t, "Timeout"),
+					Flags:     Flags(rapid.Uint32().Draw(t, "Flags")),
+					Port:      rapid.Uint16().Draw(t, "Port"),
+					FWMark:    rapid.Uint32().Draw(t, "FWMark"),
+					Family:    AddressFamily(rapid.Uint16().Draw(t, "Family")),
+					Protocol:  Protocol(rapid.Uint16().Draw(t, "Protocol")),
+				},
+				Stats:      statsGen.Draw(t, "Stats"),
+				Stats64:    statsGen.Draw(t, "Stats64"),
+				StatsAttrs: StatsAttrs(rapid.Uint8().Draw(t, "StatsAttrs")),
+			}
+
+			b, err := json.Marshal(svc)
+			assert.NilError(t, err)
+
+			var out ServiceExtended
+			assert.NilError(t, json.Unmarshal(b, &out))
+			assert.DeepEqual(t, out, svc, cmp.Comparer(NetipAddrCompare), cmp.Comparer(netmask.Mask.Equal))
+		})
+	})
+
+	t.Run("DestinationExtended", func(t *testing.T) {
+		rapid.Check(t, func(t *rapid.T) {
+			dest := DestinationExtended{
+				Destination: Destination{
+					Address:        addrGen.Draw(t, "Address"),
+					FwdMethod:      ForwardType(rapid.Uint32().Draw(t, "FwdMethod")),
+					Weight:         rapid.Uint32().Draw(t, "Weight"),
+					UpperThreshold: rapid.Uint32().Draw(t, "UpperThreshold"),
+					LowerThreshold: rapid.Uint32().Draw(t, "LowerThreshold"),
+					Port:           rapid.Uint16().Draw(t, "Port"),
+					Family:         AddressFamily(rapid.Uint16().Draw(t, "Family")),
+					TunnelType:     TunnelType(rapid.Uint8().Draw(t, "TunnelType")),
+					TunnelPort:     rapid.Uint16().Draw(t, "TunnelPort"),
+					TunnelFlags:    TunnelFlags(rapid.Uint16().Draw(t, "TunnelFlags")),
+				},
+				ActiveConnections:     rapid.Uint32().Draw(t, "ActiveConnections"),
+				InactiveConnections:   rapid.Uint32().Draw(t, "InactiveConnections"),
+				PersistentConnections: rapid.Uint32().Draw(t, "PersistentConnections"),
+				Stats:                 statsGen.Draw(t, "Stats"),
+				Stats64:               statsGen.Draw(t, "Stats64"),
+				StatsAttrs:            StatsAttrs(rapid.Uint8().Draw(t, "StatsAttrs")),
+			}
+
+			b, err := json.Marshal(dest)
+			assert.NilError(t, err)
+
+			var out DestinationExtended
+			assert.NilError(t, json.Unmarshal(b, &out))
+			assert.DeepEqual(t, out, dest, cmp.Comparer(NetipAddrCompare))
+		})
+	})
+}
+
+var statsGen = rapid.Custom(func(t *rapid.T) Stats {
+	return Stats{
+		Connections:        rapid.Uint64().Draw(t, "Connections"),
+		IncomingPackets:    rapid.Uint64().Draw(t, "IncomingPackets"),
+		OutgoingPackets:    rapid.Uint64().Draw(t, "OutgoingPackets"),
+		IncomingBytes:      rapid.Uint64().Draw(t, "IncomingBytes"),
+		OutgoingBytes:      rapid.Uint64().Draw(t, "OutgoingBytes"),
+		ConnectionRate:     rapid.Uint64().Draw(t, "ConnectionRate"),
+		IncomingPacketRate: rapid.Uint64().Draw(t, "IncomingPacketRate"),
+		OutgoingPacketRate: rapid.Uint64().Draw(t, "OutgoingPacketRate"),
+		IncomingByteRate:   rapid.Uint64().Draw(t, "IncomingByteRate"),
+		OutgoingByteRate:   rapid.Uint64().Draw(t, "OutgoingByteRate"),
+	}
+})
+
+func TestJSON_Format(t *testing.T) {
+	svc := Service{
+		Address:   netip.MustParseAddr("192.0.2.1"),
+		Netmask:   netmask.MaskFrom(24, 32),
+		Scheduler: SourceHashing,
+		Flags:     ServicePersistent | SourceHashPort,
+		Port:      443,
+		Family:    INET,
+		Protocol:  TCP,
+	}
+
+	b, err := json.Marshal(svc)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), `{"address":"192.0.2.1","netmask":"255.255.255.0","scheduler":"sh","flags":"ServicePersistent | ServiceSchedulerOpt2","port":443,"family":"INET","protocol":"TCP"}`)
+
+	dest := Destination{
+		Address:    netip.MustParseAddr("2001:db8::1"),
+		FwdMethod:  Tunnel,
+		Weight:     100,
+		Family:     INET6,
+		TunnelType: GUE,
+		TunnelPort: 6080,
+	}
+
+	b, err = json.Marshal(dest)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), `{"address":"2001:db8::1","fwdMethod":"Tunnel","weight":100,"family":"INET6","tunnelType":"GUE","tunnelPort":6080}`)
+}
@@ -0,0 +1,261 @@
+package ipvs
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/ipvs/netmask"
+)
+
+// serviceFlags maps the ipvsadm options selecting a Service to their
+// protocol, or zero for firewall-mark services.
+var serviceFlags = map[string]Protocol{
+	"-t":               TCP,
+	"--tcp-service":    TCP,
+	"-u":               UDP,
+	"--udp-service":    UDP,
+	"--sctp-service":   SCTP,
+	"-f":               0,
+	"--fwmark-service": 0,
+}
+
+// ParseService parses the identity of a Service, and optionally its
+// persistence netmask. The Family is inferred from the address, unless
+// given explicitly. Services are written as one of:
+//
+//	tcp://192.0.2.1:80
+//	udp://[2001:db8::1]:53
+//	sctp://192.0.2.1:3868/255.255.255.0
+//	tcp://[2001:db8::1]:443/64
+//	fwmark:100
+//	fwmark:100/inet6/64
+//
+// The "://" may be shortened to ":", as returned by ServiceKey.String,
+// and "fwm" may be used for "fwmark". A port may be omitted for
+// persistent services, which then match every port.
+//
+// The ipvsadm options selecting a Service are also accepted, such as
+// "-t 192.0.2.1:80", "--udp-service [2001:db8::1]:53", "--sctp-service
+// 192.0.2.1:3868", and "-f 100".
+func ParseService(s string) (Service, error) {
+	svc, err := parseService(strings.TrimSpace(s))
+	if err != nil {
+		return Service{}, fmt.Errorf("ipvs: invalid service %q: %w", s, err)
+	}
+
+	return svc, nil
+}
+
+// parseService implements ParseService.
+func parseService(s string) (Service, error) {
+	if strings.HasPrefix(s, "-") {
+		flag, value, err := splitFlag(s)
+		if err != nil {
+			return Service{}, err
+		}
+
+		protocol, ok := serviceFlags[flag]
+		if !ok {
+			return Service{}, fmt.Errorf("unknown option %q", flag)
+		}
+		if protocol == 0 {
+			return parseFWMarkService(value)
+		}
+
+		return parseVirtualService(protocol, value)
+	}
+
+	scheme, rest, ok := strings.Cut(s, ":")
+	if !ok {
+		return Service{}, errors.New("missing protocol")
+	}
+	rest = strings.TrimPrefix(rest, "//")
+
+	switch strings.ToLower(scheme) {
+	case "fwm", "fwmark":
+		return parseFWMarkService(rest)
+	}
+
+	var protocol Protocol
+	if err := protocol.UnmarshalText([]byte(scheme)); err != nil {
+		return Service{}, fmt.Errorf("unknown protocol %q", scheme)
+	}
+
+	return parseVirtualService(protocol, rest)
+}
+
+// parseVirtualService parses the address of a Service, followed by its options.
+func parseVirtualService(protocol Protocol, s string) (Service, error) {
+	addr, options, _ := strings.Cut(s, "/")
+
+	ap, err := parseAddrPort(addr)
+	if err != nil {
+		return Service{}, err
+	}
+
+	svc := Service{
+		Address:  ap.Addr(),
+		Port:     ap.Port(),
+		Family:   familyOf(ap.Addr()),
+		Protocol: protocol,
+	}
+
+	if err := svc.parseOptions(options); err != nil {
+		return Service{}, err
+	}
+
+	return svc, nil
+}
+
+// parseFWMarkService parses the mark of a firewall-mark Service, followed by its options.
+func parseFWMarkService(s string) (Service, error) {
+	mark, options, _ := strings.Cut(s, "/")
+
+	n, err := strconv.ParseUint(mark, 0, 32)
+	if err != nil || n == 0 {
+		return Service{}, fmt.Errorf("invalid firewall mark %q", mark)
+	}
+
+	svc := Service{
+		FWMark: uint32(n),
+		Family: INET,
+	}
+
+	if err := svc.parseOptions(options); err != nil {
+		return Service{}, err
+	}
+
+	return svc, nil
+}
+
+// parseOptions parses the "/"-separated address family and netmask
+// following the address, or mark, of a Service.
+func (svc *Service) parseOptions(options string) error {
+	if options == "" {
+		return nil
+	}
+
+	var mask string
+	for _, opt := range strings.Split(options, "/") {
+		var family AddressFamily
+		if err := family.UnmarshalText([]byte(opt)); err == nil && (family == INET || family == INET6) {
+			if svc.FWMark == 0 && family != svc.Family {
+				return fmt.Errorf("address %s is not %s", svc.Address, family)
+			}
+			svc.Family = family
+			continue
+		}
+
+		if mask != "" {
+			return fmt.Errorf("unexpected option %q", opt)
+		}
+		mask = opt
+	}
+
+	if mask == "" {
+		return nil
+	}
+
+	switch {
+	case strings.Contains(mask, "."):
+		if svc.Family != INET {
+			return fmt.Errorf("netmask %s is not %s", mask, svc.Family)
+		}
+		if err := svc.Netmask.UnmarshalText([]byte(mask)); err != nil {
+			return fmt.Errorf("invalid netmask %q", mask)
+		}
+	default:
+		bits := 32
+		if svc.Family == INET6 {
+			bits = 128
+		}
+
+		ones, err := strconv.Atoi(mask)
+		if err != nil || ones < 0 || ones > bits {
+			return fmt.Errorf("invalid netmask %q", mask)
+		}
+		svc.Netmask = netmask.MaskFrom(ones, bits)
+	}
+
+	return nil
+}
+
+// ParseDestination parses the identity of a Destination, such as
+// "192.0.2.10:8080" or "[2001:db8::10]:8080". The Family is inferred from
+// the address. The port may be omitted, as for Destinations of firewall-mark
+// services, which use the port of each connection.
+//
+// The ipvsadm option selecting a Destination is also accepted, such as
+// "-r 192.0.2.10:8080" or "--real-server [2001:db8::10]:8080".
+func ParseDestination(s string) (Destination, error) {
+	dest, err := parseDestination(strings.TrimSpace(s))
+	if err != nil {
+		return Destination{}, fmt.Errorf("ipvs: invalid destination %q: %w", s, err)
+	}
+
+	return dest, nil
+}
+
+// parseDestination implements ParseDestination.
+func parseDestination(s string) (Destination, error) {
+	if strings.HasPrefix(s, "-") {
+		flag, value, err := splitFlag(s)
+		if err != nil {
+			return Destination{}, err
+		}
+
+		switch flag {
+		case "-r", "--real-server":
+		default:
+			return Destination{}, fmt.Errorf("unknown option %q", flag)
+		}
+		s = value
+	}
+
+	ap, err := parseAddrPort(s)
+	if err != nil {
+		return Destination{}, err
+	}
+
+	return Destination{
+		Address: ap.Addr(),
+		Port:    ap.Port(),
+		Family:  familyOf(ap.Addr()),
+	}, nil
+}
+
+// splitFlag splits an option and its value, given as "-t value",
+// "--tcp-service value", or "--tcp-service=value".
+func splitFlag(s string) (flag, value string, err error) {
+	if flag, value, ok := strings.Cut(s, "="); ok && !strings.ContainsAny(flag, " \t") {
+		return flag, value, nil
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("expected an option and its value")
+	}
+
+	return fields[0], fields[1], nil
+}
+
+// parseAddrPort parses an address with an optional port. IPv6 addresses
+// may be enclosed in brackets, which are required when a port is given.
+func parseAddrPort(s string) (netip.AddrPort, error) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap, nil
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if addr.Zone() != "" {
+		return netip.AddrPort{}, fmt.Errorf("address %s has a zone", addr)
+	}
+
+	return netip.AddrPortFrom(addr, 0), nil
+}
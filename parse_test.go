@@ -0,0 +1,186 @@
+package ipvs
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/cloudflare/ipvs/netmask"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+)
+
+func TestParseService(t *testing.T) {
+	type testCase struct {
+		name     string
+		in       string
+		expected Service
+		err      string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		svc, err := ParseService(tc.in)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err)
+			return
+		}
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, svc, tc.expected, cmp.Comparer(NetipAddrCompare))
+	}
+
+	v4 := Service{Address: netip.MustParseAddr("192.0.2.1"), Port: 80, Family: INET, Protocol: TCP}
+	v6 := Service{Address: netip.MustParseAddr("2001:db8::1"), Port: 53, Family: INET6, Protocol: UDP}
+
+	testCases := []testCase{
+		{name: "url", in: "tcp://192.0.2.1:80", expected: v4},
+		{name: "key", in: "tcp:192.0.2.1:80", expected: v4},
+		{name: "upper case", in: "TCP://192.0.2.1:80", expected: v4},
+		{name: "ipv6", in: "udp://[2001:db8::1]:53", expected: v6},
+		{name: "family", in: "udp://[2001:db8::1]:53/inet6", expected: v6},
+		{name: "tcp option", in: "-t 192.0.2.1:80", expected: v4},
+		{name: "tcp long option", in: "--tcp-service=192.0.2.1:80", expected: v4},
+		{name: "udp option", in: "-u [2001:db8::1]:53", expected: v6},
+		{name: "udp long option", in: "--udp-service [2001:db8::1]:53", expected: v6},
+		{
+			name: "sctp option",
+			in:   "--sctp-service 192.0.2.1:3868",
+			expected: Service{
+				Address:  netip.MustParseAddr("192.0.2.1"),
+				Port:     3868,
+				Family:   INET,
+				Protocol: SCTP,
+			},
+		},
+		{
+			name: "mapped",
+			in:   "tcp://[::ffff:192.0.2.1]:80",
+			expected: Service{
+				Address:  netip.MustParseAddr("::ffff:192.0.2.1"),
+				Port:     80,
+				Family:   INET6,
+				Protocol: TCP,
+			},
+		},
+		{
+			name: "no port",
+			in:   "tcp://2001:db8::1",
+			expected: Service{
+				Address:  netip.MustParseAddr("2001:db8::1"),
+				Family:   INET6,
+				Protocol: TCP,
+			},
+		},
+		{
+			name: "dotted netmask",
+			in:   "tcp://192.0.2.1:80/255.255.255.0",
+			expected: Service{
+				Address:  netip.MustParseAddr("192.0.2.1"),
+				Port:     80,
+				Family:   INET,
+				Protocol: TCP,
+				Netmask:  netmask.MaskFrom(24, 32),
+			},
+		},
+		{
+			name: "ipv6 netmask",
+			in:   "udp://[2001:db8::1]:53/64",
+			expected: Service{
+				Address:  netip.MustParseAddr("2001:db8::1"),
+				Port:     53,
+				Family:   INET6,
+				Protocol: UDP,
+				Netmask:  netmask.MaskFrom(64, 128),
+			},
+		},
+		{name: "fwmark", in: "fwmark:100", expected: Service{FWMark: 100, Family: INET}},
+		{name: "fwm", in: "fwm:100", expected: Service{FWMark: 100, Family: INET}},
+		{name: "fwmark hex", in: "fwmark:0x64", expected: Service{FWMark: 100, Family: INET}},
+		{name: "fwmark option", in: "-f 100", expected: Service{FWMark: 100, Family: INET}},
+		{name: "fwmark long option", in: "--fwmark-service=100", expected: Service{FWMark: 100, Family: INET}},
+		{name: "fwmark ipv6", in: "fwmark:100/inet6", expected: Service{FWMark: 100, Family: INET6}},
+		{
+			name:     "fwmark netmask",
+			in:       "fwmark:100/inet6/64",
+			expected: Service{FWMark: 100, Family: INET6, Netmask: netmask.MaskFrom(64, 128)},
+		},
+		{name: "missing protocol", in: "192.0.2.1", err: "missing protocol"},
+		{name: "unknown protocol", in: "icmp://192.0.2.1:80", err: `unknown protocol "icmp"`},
+		{name: "invalid address", in: "tcp://example.com:80", err: "invalid service"},
+		{name: "zone", in: "tcp://fe80::1%eth0", err: "has a zone"},
+		{name: "zero fwmark", in: "fwmark:0", err: "invalid firewall mark"},
+		{name: "family mismatch", in: "tcp://192.0.2.1:80/inet6", err: "is not INET6"},
+		{name: "dotted ipv6 netmask", in: "udp://[2001:db8::1]:53/255.255.255.0", err: "is not INET6"},
+		{name: "long netmask", in: "tcp://192.0.2.1:80/33", err: "invalid netmask"},
+		{name: "two netmasks", in: "tcp://192.0.2.1:80/24/24", err: "unexpected option"},
+		{name: "unknown option", in: "-r 192.0.2.1:80", err: `unknown option "-r"`},
+		{name: "missing value", in: "-t", err: "expected an option and its value"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestParseService_Key(t *testing.T) {
+	for _, k := range []ServiceKey{
+		ServiceKeyFrom(TCP, netip.MustParseAddrPort("192.0.2.1:80")),
+		ServiceKeyFrom(SCTP, netip.MustParseAddrPort("[2001:db8::1]:3868")),
+		FWMarkKey(42, INET),
+		FWMarkKey(42, INET6),
+	} {
+		svc, err := ParseService(k.String())
+		assert.NilError(t, err)
+		assert.Equal(t, svc.Key(), k)
+	}
+}
+
+func TestParseDestination(t *testing.T) {
+	type testCase struct {
+		name     string
+		in       string
+		expected Destination
+		err      string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		dest, err := ParseDestination(tc.in)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err)
+			return
+		}
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, dest, tc.expected, cmp.Comparer(NetipAddrCompare))
+	}
+
+	v4 := Destination{Address: netip.MustParseAddr("192.0.2.10"), Port: 8080, Family: INET}
+	v6 := Destination{Address: netip.MustParseAddr("2001:db8::10"), Port: 8080, Family: INET6}
+
+	testCases := []testCase{
+		{name: "ipv4", in: "192.0.2.10:8080", expected: v4},
+		{name: "ipv6", in: "[2001:db8::10]:8080", expected: v6},
+		{name: "option", in: "-r 192.0.2.10:8080", expected: v4},
+		{name: "long option", in: "--real-server [2001:db8::10]:8080", expected: v6},
+		{name: "long option equals", in: "--real-server=192.0.2.10:8080", expected: v4},
+		{
+			name:     "no port",
+			in:       "192.0.2.10",
+			expected: Destination{Address: netip.MustParseAddr("192.0.2.10"), Family: INET},
+		},
+		{
+			name:     "bracketed",
+			in:       "[2001:db8::10]",
+			expected: Destination{Address: netip.MustParseAddr("2001:db8::10"), Family: INET6},
+		},
+		{name: "invalid", in: "example.com:8080", err: "invalid destination"},
+		{name: "unknown option", in: "-t 192.0.2.10:8080", err: `unknown option "-t"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package ipvs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Paths of the host-wide IPVS statistics, which are only available from procfs.
+const (
+	StatsPath       = "/proc/net/ip_vs_stats"
+	StatsPerCPUPath = "/proc/net/ip_vs_stats_percpu"
+)
+
+// ReadStats reads the host-wide statistics from path, such as StatsPath.
+func ReadStats(path string) (Stats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer f.Close()
+
+	return ParseStats(f)
+}
+
+// ParseStats parses host-wide statistics in the format of /proc/net/ip_vs_stats.
+func ParseStats(r io.Reader) (Stats, error) {
+	var rows [][]uint64
+	err := scanStats(r, func(fields []string) error {
+		values, ok := parseHexFields(fields)
+		if ok && len(values) == 5 {
+			rows = append(rows, values)
+		}
+		return nil
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+
+	if len(rows) != 2 {
+		return Stats{}, fmt.Errorf("ipvs: expected 2 rows of statistics, got %d", len(rows))
+	}
+
+	var stats Stats
+	stats.Connections = rows[0][0]
+	stats.IncomingPackets = rows[0][1]
+	stats.OutgoingPackets = rows[0][2]
+	stats.IncomingBytes = rows[0][3]
+	stats.OutgoingBytes = rows[0][4]
+
+	stats.ConnectionRate = rows[1][0]
+	stats.IncomingPacketRate = rows[1][1]
+	stats.OutgoingPacketRate = rows[1][2]
+	stats.IncomingByteRate = rows[1][3]
+	stats.OutgoingByteRate = rows[1][4]
+
+	return stats, nil
+}
+
+// ReadStatsPerCPU reads the per-CPU statistics from path, such as StatsPerCPUPath.
+func ReadStatsPerCPU(path string) ([]Stats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseStatsPerCPU(f)
+}
+
+// maxCPU bounds the CPU numbers accepted by ParseStatsPerCPU, well above
+// the kernel's NR_CPUS.
+const maxCPU = 1 << 16
+
+// ParseStatsPerCPU parses statistics in the format of
+// /proc/net/ip_vs_stats_percpu, returning the statistics of each CPU
+// indexed by CPU number. The kernel lists every possible CPU, online or
+// not, so CPUs missing from r are left zero.
+//
+// The kernel only reports the rates for the host as a whole, so the rates
+// of each CPU are zero. Use ParseStats for the host-wide statistics.
+func ParseStatsPerCPU(r io.Reader) ([]Stats, error) {
+	var (
+		cpus []Stats
+		seen []bool
+	)
+	err := scanStats(r, func(fields []string) error {
+		if len(fields) != 6 || fields[0] == "~" {
+			return nil
+		}
+
+		values, ok := parseHexFields(fields)
+		if !ok {
+			return nil
+		}
+
+		if values[0] >= maxCPU {
+			return fmt.Errorf("ipvs: statistics for CPU %d out of range", values[0])
+		}
+		cpu := int(values[0])
+		if cpu < len(seen) && seen[cpu] {
+			return fmt.Errorf("ipvs: duplicate statistics for CPU %d", cpu)
+		}
+		if cpu >= len(cpus) {
+			cpus = append(cpus, make([]Stats, cpu+1-len(cpus))...)
+			seen = append(seen, make([]bool, cpu+1-len(seen))...)
+		}
+
+		seen[cpu] = true
+		cpus[cpu] = Stats{
+			Connections:     values[1],
+			IncomingPackets: values[2],
+			OutgoingPackets: values[3],
+			IncomingBytes:   values[4],
+			OutgoingBytes:   values[5],
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("ipvs: no per-CPU statistics found")
+	}
+
+	return cpus, nil
+}
+
+// scanStats calls fn with the fields of each line of r.
+func scanStats(r io.Reader, fn func([]string) error) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if err := fn(strings.Fields(s.Text())); err != nil {
+			return err
+		}
+	}
+
+	return s.Err()
+}
+
+// parseHexFields parses fields as hexadecimal numbers. It reports false
+// if any field is not a number, such as in a header line.
+func parseHexFields(fields []string) ([]uint64, bool) {
+	values := make([]uint64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 16, 64)
+		if err != nil {
+			return nil, false
+		}
+		values[i] = v
+	}
+
+	return values, true
+}
@@ -0,0 +1,64 @@
+package ipvs
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestReadStats(t *testing.T) {
+	stats, err := ReadStats("testdata/ip_vs_stats")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stats, Stats{
+		Connections:        2_000_000,
+		IncomingPackets:    50_000_000,
+		IncomingBytes:      8_000_000_000,
+		ConnectionRate:     100,
+		IncomingPacketRate: 1000,
+		IncomingByteRate:   120_000,
+	})
+}
+
+func TestReadStatsPerCPU(t *testing.T) {
+	cpus, err := ReadStatsPerCPU("testdata/ip_vs_stats_percpu")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cpus, []Stats{
+		{
+			Connections:     1_000_000,
+			IncomingPackets: 25_000_000,
+			IncomingBytes:   4_000_000_000,
+		},
+		{
+			Connections:     1_000_000,
+			IncomingPackets: 25_000_000,
+			IncomingBytes:   4_000_000_000,
+		},
+	})
+}
+
+func TestParseStatsPerCPU_Missing(t *testing.T) {
+	cpus, err := ParseStatsPerCPU(strings.NewReader(`       Total Incoming Outgoing         Incoming         Outgoing
+CPU    Conns  Packets  Packets            Bytes            Bytes
+  0        1        2        0                3                0
+  3        4        5        0                6                0
+  ~        5        7        0                9                0
+`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cpus, []Stats{
+		0: {Connections: 1, IncomingPackets: 2, IncomingBytes: 3},
+		3: {Connections: 4, IncomingPackets: 5, IncomingBytes: 6},
+	})
+}
+
+func TestParseStats_Invalid(t *testing.T) {
+	_, err := ParseStats(strings.NewReader("   Total Incoming Outgoing         Incoming         Outgoing\n"))
+	assert.ErrorContains(t, err, "expected 2 rows of statistics, got 0")
+
+	_, err = ParseStatsPerCPU(strings.NewReader("  1        0        0        0                0                0\n" +
+		"  1        0        0        0                0                0\n"))
+	assert.ErrorContains(t, err, "duplicate statistics for CPU 1")
+
+	_, err = ParseStatsPerCPU(strings.NewReader("  ffffffff        0        0        0                0                0\n"))
+	assert.ErrorContains(t, err, "statistics for CPU 4294967295 out of range")
+}
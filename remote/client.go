@@ -0,0 +1,212 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// DefaultTimeout limits each request of a Client without an HTTPClient.
+const DefaultTimeout = 30 * time.Second
+
+// Options configures a Client.
+type Options struct {
+	// Token is sent as a bearer token with every request, if set.
+	Token string
+
+	// HTTPClient makes the requests. Client certificates are configured
+	// on its transport, such as with ClientTLSConfig. Defaults to a client
+	// with a timeout of DefaultTimeout.
+	HTTPClient *http.Client
+}
+
+// Client is an ipvs.Client which calls the methods of a Server.
+type Client struct {
+	base *url.URL
+	opts Options
+}
+
+var (
+	_ ipvs.Client             = (*Client)(nil)
+	_ ipvs.CapabilitiesClient = (*Client)(nil)
+	_ ipvs.DaemonClient       = (*Client)(nil)
+	_ ipvs.SysctlClient       = (*Client)(nil)
+)
+
+// NewClient returns a Client for the Server at addr, such as
+// "https://lb1.example.com:9443". A path may be included if the Server
+// is not served at the root.
+func NewClient(addr string, opts Options) (*Client, error) {
+	base, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("remote: invalid address %q: %w", addr, err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("remote: invalid address %q: scheme must be http or https", addr)
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: DefaultTimeout}
+	}
+
+	return &Client{base: base, opts: opts}, nil
+}
+
+// Info implements ipvs.Client.
+func (c *Client) Info() (info ipvs.Info, err error) {
+	err = c.call("Info", call{}, &info)
+	return info, err
+}
+
+// Capabilities implements ipvs.Client, returning the capabilities of the
+// Server's host.
+func (c *Client) Capabilities() (caps ipvs.Capabilities, err error) {
+	err = c.call("Capabilities", call{}, &caps)
+	return caps, err
+}
+
+// Config implements ipvs.Client.
+func (c *Client) Config() (config ipvs.Config, err error) {
+	err = c.call("Config", call{}, &config)
+	return config, err
+}
+
+// SetConfig implements ipvs.Client.
+func (c *Client) SetConfig(config ipvs.Config) error {
+	return c.call("SetConfig", call{Config: &config}, nil)
+}
+
+// Services implements ipvs.Client.
+func (c *Client) Services() (services []ipvs.ServiceExtended, err error) {
+	err = c.call("Services", call{}, &services)
+	return services, err
+}
+
+// Service implements ipvs.Client.
+func (c *Client) Service(svc ipvs.Service) (se ipvs.ServiceExtended, err error) {
+	err = c.call("Service", call{Service: &svc}, &se)
+	return se, err
+}
+
+// CreateService implements ipvs.Client.
+func (c *Client) CreateService(svc ipvs.Service) error {
+	return c.call("CreateService", call{Service: &svc}, nil)
+}
+
+// UpdateService implements ipvs.Client.
+func (c *Client) UpdateService(svc ipvs.Service) error {
+	return c.call("UpdateService", call{Service: &svc}, nil)
+}
+
+// RemoveService implements ipvs.Client.
+func (c *Client) RemoveService(svc ipvs.Service) error {
+	return c.call("RemoveService", call{Service: &svc}, nil)
+}
+
+// Destinations implements ipvs.Client.
+func (c *Client) Destinations(svc ipvs.Service) (dests []ipvs.DestinationExtended, err error) {
+	err = c.call("Destinations", call{Service: &svc}, &dests)
+	return dests, err
+}
+
+// CreateDestination implements ipvs.Client.
+func (c *Client) CreateDestination(svc ipvs.Service, dest ipvs.Destination) error {
+	return c.call("CreateDestination", call{Service: &svc, Destination: &dest}, nil)
+}
+
+// UpdateDestination implements ipvs.Client.
+func (c *Client) UpdateDestination(svc ipvs.Service, dest ipvs.Destination) error {
+	return c.call("UpdateDestination", call{Service: &svc, Destination: &dest}, nil)
+}
+
+// RemoveDestination implements ipvs.Client.
+func (c *Client) RemoveDestination(svc ipvs.Service, dest ipvs.Destination) error {
+	return c.call("RemoveDestination", call{Service: &svc, Destination: &dest}, nil)
+}
+
+// Daemons implements ipvs.DaemonClient. If the Server's Client does not
+// implement it, the error matches errors.ErrUnsupported.
+func (c *Client) Daemons() (daemons []ipvs.Daemon, err error) {
+	err = c.call("Daemons", call{}, &daemons)
+	return daemons, err
+}
+
+// CreateDaemon implements ipvs.DaemonClient.
+func (c *Client) CreateDaemon(d ipvs.Daemon) error {
+	return c.call("CreateDaemon", call{Daemon: &d}, nil)
+}
+
+// RemoveDaemon implements ipvs.DaemonClient.
+func (c *Client) RemoveDaemon(d ipvs.Daemon) error {
+	return c.call("RemoveDaemon", call{Daemon: &d}, nil)
+}
+
+// Sysctls implements ipvs.SysctlClient. If the Server's Client does not
+// implement it, the error matches errors.ErrUnsupported.
+func (c *Client) Sysctls() (s ipvs.Sysctls, err error) {
+	err = c.call("Sysctls", call{}, &s)
+	return s, err
+}
+
+// SetSysctls implements ipvs.SysctlClient.
+func (c *Client) SetSysctls(s ipvs.Sysctls) error {
+	return c.call("SetSysctls", call{Sysctls: &s}, nil)
+}
+
+// call calls method with args, decoding its result into result, if not nil.
+func (c *Client) call(method string, args call, result any) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	u := c.base.JoinPath(Version, method)
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("remote: %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote: %s: unexpected response %s: %s", method, resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error wireError `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error.Code == "" {
+			return fmt.Errorf("remote: %s: unexpected response %s", method, resp.Status)
+		}
+
+		return body.Error.decode()
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("remote: %s: invalid response: %w", method, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+// Package remote serves an ipvs.Client over HTTP, and connects to it from
+// other machines, so IPVS can be managed without a shell on the host.
+//
+// Each method of ipvs.Client is a POST to "/v1/<Method>", such as
+// "/v1/CreateService", whose body is a JSON object with the method's
+// "service", "destination", "daemon", "sysctls", or "config" arguments.
+// The methods of ipvs.CapabilitiesClient, ipvs.DaemonClient and
+// ipvs.SysctlClient are served likewise, failing with
+// errors.ErrUnsupported if the served Client lacks them. Results are returned as
+// JSON. Failures carry an error code, from which Client reconstructs errors
+// that match os.ErrNotExist, os.ErrExist, errors.ErrUnsupported, and
+// *ipvs.FieldError, as returned by the local Client.
+//
+// Servers should be run over TLS with client certificates, as configured
+// by ServerTLSConfig, and may additionally require bearer tokens. Servers
+// requiring neither refuse every request, unless they are explicitly
+// configured as Insecure.
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/fs"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// Version is the version of the protocol, which prefixes every path.
+const Version = "v1"
+
+// call holds the arguments of a method.
+type call struct {
+	Service     *ipvs.Service     `json:"service,omitempty"`
+	Destination *ipvs.Destination `json:"destination,omitempty"`
+	Config      *ipvs.Config      `json:"config,omitempty"`
+	Daemon      *ipvs.Daemon      `json:"daemon,omitempty"`
+	Sysctls     *ipvs.Sysctls     `json:"sysctls,omitempty"`
+}
+
+// Code classifies the errors returned by a Server.
+type Code string
+
+// Error codes.
+const (
+	CodeNotExist        Code = "not_exist"
+	CodeExist           Code = "exist"
+	CodeInvalid         Code = "invalid"
+	CodeUnsupported     Code = "unsupported"
+	CodePermission      Code = "permission_denied"
+	CodeUnauthenticated Code = "unauthenticated"
+	CodeBadRequest      Code = "bad_request"
+	CodeUnimplemented   Code = "unimplemented"
+	CodeInternal        Code = "internal"
+)
+
+// ErrUnauthenticated is matched by errors from Servers which refused the
+// credentials of a request.
+var ErrUnauthenticated = errors.New("remote: unauthenticated")
+
+// sentinels are the errors matched by each Code.
+var sentinels = map[Code]error{
+	CodeNotExist:        fs.ErrNotExist,
+	CodeExist:           fs.ErrExist,
+	CodeUnsupported:     errors.ErrUnsupported,
+	CodePermission:      fs.ErrPermission,
+	CodeUnauthenticated: ErrUnauthenticated,
+}
+
+// Error is an error returned by a Server.
+//
+// Errors match the sentinel error of their Code with errors.Is, and their
+// Fields with errors.As, as the original error did.
+type Error struct {
+	Code    Code
+	Message string
+
+	// Fields are the invalid fields of a configuration, for CodeInvalid.
+	Fields []*ipvs.FieldError
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() []error {
+	var errs []error
+	if err, ok := sentinels[e.Code]; ok {
+		errs = append(errs, err)
+	}
+	for _, f := range e.Fields {
+		errs = append(errs, f)
+	}
+
+	return errs
+}
+
+// wireError is the encoding of an Error.
+type wireError struct {
+	Code    Code        `json:"code"`
+	Message string      `json:"message"`
+	Fields  []wireField `json:"fields,omitempty"`
+}
+
+// wireField is the encoding of an ipvs.FieldError.
+type wireField struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// encodeError classifies err for a response.
+func encodeError(err error) wireError {
+	we := wireError{Code: CodeInternal, Message: err.Error()}
+
+	var e *Error
+	if errors.As(err, &e) {
+		we.Code = e.Code
+	}
+
+	for _, f := range ipvs.FieldErrors(err) {
+		we.Fields = append(we.Fields, wireField{Field: f.Field, Message: f.Err.Error()})
+	}
+
+	switch {
+	case len(we.Fields) > 0:
+		we.Code = CodeInvalid
+	case we.Code != CodeInternal:
+	case errors.Is(err, fs.ErrNotExist):
+		we.Code = CodeNotExist
+	case errors.Is(err, fs.ErrExist):
+		we.Code = CodeExist
+	case errors.Is(err, errors.ErrUnsupported):
+		we.Code = CodeUnsupported
+	case errors.Is(err, fs.ErrPermission):
+		we.Code = CodePermission
+	}
+
+	return we
+}
+
+// decode reconstructs the Error encoded as we.
+func (we wireError) decode() *Error {
+	e := &Error{Code: we.Code, Message: we.Message}
+	for _, f := range we.Fields {
+		e.Fields = append(e.Fields, &ipvs.FieldError{Field: f.Field, Err: errors.New(f.Message)})
+	}
+
+	return e
+}
+
+// ServerTLSConfig returns a TLS configuration for serving with cert, which
+// requires clients to present a certificate issued by one of clientCAs.
+func ServerTLSConfig(cert tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+}
+
+// ClientTLSConfig returns a TLS configuration for connecting to servers
+// with certificates issued by one of rootCAs, presenting cert.
+func ClientTLSConfig(cert tls.Certificate, rootCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      rootCAs,
+	}
+}
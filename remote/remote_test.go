@@ -0,0 +1,340 @@
+package remote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/ipvstest"
+	"github.com/cloudflare/ipvs/snapshot"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+)
+
+var (
+	svc = ipvs.Service{
+		Address:   netip.MustParseAddr("192.0.2.1"),
+		Port:      80,
+		Family:    ipvs.INET,
+		Protocol:  ipvs.TCP,
+		Scheduler: ipvs.WeightedRoundRobin,
+	}
+	dest = ipvs.Destination{
+		Address:   netip.MustParseAddr("198.51.100.1"),
+		Port:      8080,
+		Family:    ipvs.INET,
+		Weight:    10,
+		FwdMethod: ipvs.Masquerade,
+	}
+)
+
+var cmpAddr = cmp.Comparer(func(x, y netip.Addr) bool { return x == y })
+
+// newClient returns a Client for a Server of c, served over loopback.
+func newClient(t *testing.T, c ipvs.Client) *Client {
+	srv := httptest.NewServer(NewServer(c, ServerOptions{Insecure: true}))
+	t.Cleanup(srv.Close)
+
+	rc, err := NewClient(srv.URL, Options{})
+	assert.NilError(t, err)
+
+	return rc
+}
+
+func TestClient(t *testing.T) {
+	local := ipvstest.New()
+	c := newClient(t, local)
+
+	info, err := c.Info()
+	assert.NilError(t, err)
+	assert.Equal(t, info.Version, [3]int{1, 2, 1})
+
+	caps, err := c.Capabilities()
+	assert.NilError(t, err)
+	assert.Assert(t, caps.SupportsTunnel(ipvs.GUE))
+
+	config := ipvs.Config{TCPTimeout: 900, TCPFinTimeout: 120, UDPTimeout: 300}
+	assert.NilError(t, c.SetConfig(config))
+	got, err := c.Config()
+	assert.NilError(t, err)
+	assert.Equal(t, got, config)
+
+	assert.NilError(t, c.CreateService(svc))
+	assert.NilError(t, c.CreateDestination(svc, dest))
+
+	services, err := c.Services()
+	assert.NilError(t, err)
+	assert.Equal(t, len(services), 1)
+	assert.Equal(t, services[0].Service, svc)
+
+	updated := dest
+	updated.Weight = 20
+	assert.NilError(t, c.UpdateDestination(svc, updated))
+
+	dests, err := c.Destinations(svc)
+	assert.NilError(t, err)
+	assert.Equal(t, len(dests), 1)
+	assert.Equal(t, dests[0].Destination, updated)
+
+	assert.NilError(t, c.RemoveDestination(svc, dest))
+	assert.NilError(t, c.RemoveService(svc))
+
+	_, err = local.Service(svc)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestClient_Daemons(t *testing.T) {
+	local := ipvstest.New()
+	c := newClient(t, local)
+
+	master := ipvs.Daemon{State: ipvs.DaemonMaster, Interface: "eth0", SyncID: 7}
+	assert.NilError(t, c.CreateDaemon(master))
+	assert.ErrorIs(t, c.CreateDaemon(master), fs.ErrExist)
+
+	daemons, err := c.Daemons()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, daemons, []ipvs.Daemon{master}, cmpAddr)
+
+	// Snapshots taken through the Client include the daemons.
+	s, err := snapshot.Take(c)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, s.Daemons, []ipvs.Daemon{master}, cmpAddr)
+
+	assert.NilError(t, c.RemoveDaemon(master))
+	daemons, err = local.Daemons()
+	assert.NilError(t, err)
+	assert.Equal(t, len(daemons), 0)
+
+	// Servers of Clients without daemons report them as unsupported.
+	c = newClient(t, struct{ ipvs.Client }{local})
+	_, err = c.Daemons()
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+
+	s, err = snapshot.Take(c)
+	assert.NilError(t, err)
+	assert.Assert(t, s.Daemons == nil)
+}
+
+func TestClient_Sysctls(t *testing.T) {
+	local := ipvstest.New()
+	c := newClient(t, local)
+
+	on, threshold := true, [2]int{3, 50}
+	assert.NilError(t, c.SetSysctls(ipvs.Sysctls{Conntrack: &on, SyncThreshold: &threshold}))
+
+	s, err := c.Sysctls()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, s, ipvs.Sysctls{Conntrack: &on, SyncThreshold: &threshold})
+
+	c = newClient(t, struct{ ipvs.Client }{local})
+	_, err = c.Sysctls()
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+// unsupportedClient refuses to create Services.
+type unsupportedClient struct {
+	ipvs.Client
+}
+
+func (unsupportedClient) CreateService(svc ipvs.Service) error {
+	return fmt.Errorf("ipvs: scheduler %s: %w", svc.Scheduler, errors.ErrUnsupported)
+}
+
+func TestClient_Errors(t *testing.T) {
+	local := ipvstest.New()
+	assert.NilError(t, local.CreateService(svc))
+	c := newClient(t, ipvs.Validating(local))
+
+	err := c.CreateService(svc)
+	assert.ErrorIs(t, err, fs.ErrExist)
+
+	_, err = c.Service(ipvs.Service{FWMark: 1, Family: ipvs.INET})
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	var re *Error
+	assert.Assert(t, errors.As(err, &re))
+	assert.Equal(t, re.Code, CodeNotExist)
+
+	err = c.CreateService(ipvs.Service{Address: svc.Address, Protocol: ipvs.TCP, Scheduler: "not a scheduler"})
+	var fe *ipvs.FieldError
+	assert.Assert(t, errors.As(err, &fe))
+	assert.Equal(t, fe.Field, "Port")
+	assert.Assert(t, errors.As(err, &re))
+	assert.Equal(t, re.Code, CodeInvalid)
+	assert.Equal(t, len(re.Fields), 2)
+	assert.Equal(t, re.Fields[1].Field, "Scheduler")
+	assert.ErrorContains(t, err, "ipvs: Port: ")
+
+	c = newClient(t, unsupportedClient{local})
+	err = c.CreateService(svc)
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+	assert.Error(t, err, "ipvs: scheduler wrr: unsupported operation")
+}
+
+func TestServer_Token(t *testing.T) {
+	srv := httptest.NewServer(NewServer(ipvstest.New(), ServerOptions{Tokens: []string{"old", "new"}}))
+	defer srv.Close()
+
+	for _, token := range []string{"", "wrong"} {
+		c, err := NewClient(srv.URL, Options{Token: token})
+		assert.NilError(t, err)
+
+		_, err = c.Info()
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	}
+
+	for _, token := range []string{"old", "new"} {
+		c, err := NewClient(srv.URL, Options{Token: token})
+		assert.NilError(t, err)
+
+		_, err = c.Info()
+		assert.NilError(t, err)
+	}
+}
+
+func TestServer_NoCredentials(t *testing.T) {
+	srv := httptest.NewServer(NewServer(ipvstest.New(), ServerOptions{}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, Options{})
+	assert.NilError(t, err)
+
+	err = c.CreateService(svc)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	assert.Error(t, err, "remote: server has no credentials configured")
+}
+
+func TestServer_Requests(t *testing.T) {
+	srv := httptest.NewServer(NewServer(ipvstest.New(), ServerOptions{Insecure: true}))
+	defer srv.Close()
+
+	type testCase struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		req, err := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+		assert.NilError(t, err)
+
+		resp, err := srv.Client().Do(req)
+		assert.NilError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, tc.status)
+		assert.Equal(t, resp.Header.Get("Content-Type"), "application/json")
+	}
+
+	testCases := []testCase{
+		{name: "ok", method: http.MethodPost, path: "/v1/Info", body: "{}", status: http.StatusOK},
+		{name: "unknown method", method: http.MethodPost, path: "/v1/Reboot", body: "{}", status: http.StatusNotFound},
+		{name: "unknown version", method: http.MethodPost, path: "/v0/Info", body: "{}", status: http.StatusNotFound},
+		{name: "get", method: http.MethodGet, path: "/v1/Info", status: http.StatusMethodNotAllowed},
+		{name: "invalid body", method: http.MethodPost, path: "/v1/CreateService", body: "{", status: http.StatusBadRequest},
+		{
+			name:   "invalid field",
+			method: http.MethodPost,
+			path:   "/v1/CreateService",
+			body:   `{"service": {"protocol": "icmp"}}`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	ca, caCert := newCertificate(t, nil, tls.Certificate{}, "ca")
+	_, serverCert := newCertificate(t, ca, caCert, "server")
+	_, clientCert := newCertificate(t, ca, caCert, "client")
+	other, otherCert := newCertificate(t, nil, tls.Certificate{}, "other")
+	_, strangerCert := newCertificate(t, other, otherCert, "client")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	srv := httptest.NewUnstartedServer(NewServer(ipvstest.New(), ServerOptions{RequireClientCert: true}))
+	srv.TLS = ServerTLSConfig(serverCert, pool)
+	srv.StartTLS()
+	defer srv.Close()
+
+	dial := func(cert tls.Certificate) error {
+		c, err := NewClient(srv.URL, Options{
+			HTTPClient: &http.Client{
+				Transport: &http.Transport{TLSClientConfig: ClientTLSConfig(cert, pool)},
+			},
+		})
+		assert.NilError(t, err)
+
+		_, err = c.Info()
+		return err
+	}
+
+	assert.NilError(t, dial(clientCert))
+	assert.Assert(t, dial(strangerCert) != nil)
+	assert.Assert(t, dial(tls.Certificate{}) != nil)
+
+	// Without TLS, requests are refused by the Server itself.
+	plain := httptest.NewServer(NewServer(ipvstest.New(), ServerOptions{RequireClientCert: true}))
+	defer plain.Close()
+
+	c, err := NewClient(plain.URL, Options{})
+	assert.NilError(t, err)
+	_, err = c.Info()
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
+
+// newCertificate returns a certificate for name, issued by parent, or
+// self-signed as a CA when parent is nil.
+func newCertificate(t *testing.T, parent *x509.Certificate, issuer tls.Certificate, name string) (*x509.Certificate, tls.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	var signer any = issuer.PrivateKey
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, signer = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	assert.NilError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NilError(t, err)
+
+	return cert, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
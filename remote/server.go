@@ -0,0 +1,247 @@
+package remote
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// maxRequestSize limits the body of a request.
+const maxRequestSize = 1 << 20
+
+// ServerOptions configures a Server.
+type ServerOptions struct {
+	// Tokens are the bearer tokens accepted in the Authorization header.
+	// If empty, requests are not required to carry a token.
+	Tokens []string
+
+	// RequireClientCert refuses requests which were not made over TLS with
+	// a verified client certificate. This guards against serving without
+	// the TLS configuration returned by ServerTLSConfig.
+	RequireClientCert bool
+
+	// Insecure serves requests without any credentials. Unless it is set,
+	// a Server with neither Tokens nor RequireClientCert refuses every
+	// request, rather than letting anyone change the IPVS configuration.
+	Insecure bool
+}
+
+// Server is an http.Handler serving the methods of an ipvs.Client.
+type Server struct {
+	client ipvs.Client
+	opts   ServerOptions
+}
+
+// NewServer returns a Server for c.
+func NewServer(c ipvs.Client, opts ServerOptions) *Server {
+	return &Server{client: c, opts: opts}
+}
+
+// method calls a method of an ipvs.Client, returning its result, if any.
+type method func(c ipvs.Client, args call) (any, error)
+
+// methods are the methods of ipvs.Client, by name.
+var methods = map[string]method{
+	"Info": func(c ipvs.Client, _ call) (any, error) {
+		return c.Info()
+	},
+	"Capabilities": func(c ipvs.Client, _ call) (any, error) {
+		cc, ok := c.(ipvs.CapabilitiesClient)
+		if !ok {
+			return nil, errors.ErrUnsupported
+		}
+
+		return cc.Capabilities()
+	},
+	"Config": func(c ipvs.Client, _ call) (any, error) {
+		return c.Config()
+	},
+	"SetConfig": func(c ipvs.Client, args call) (any, error) {
+		return nil, c.SetConfig(deref(args.Config))
+	},
+	"Services": func(c ipvs.Client, _ call) (any, error) {
+		return c.Services()
+	},
+	"Service": func(c ipvs.Client, args call) (any, error) {
+		return c.Service(deref(args.Service))
+	},
+	"CreateService": func(c ipvs.Client, args call) (any, error) {
+		return nil, c.CreateService(deref(args.Service))
+	},
+	"UpdateService": func(c ipvs.Client, args call) (any, error) {
+		return nil, c.UpdateService(deref(args.Service))
+	},
+	"RemoveService": func(c ipvs.Client, args call) (any, error) {
+		return nil, c.RemoveService(deref(args.Service))
+	},
+	"Destinations": func(c ipvs.Client, args call) (any, error) {
+		return c.Destinations(deref(args.Service))
+	},
+	"CreateDestination": func(c ipvs.Client, args call) (any, error) {
+		return nil, c.CreateDestination(deref(args.Service), deref(args.Destination))
+	},
+	"UpdateDestination": func(c ipvs.Client, args call) (any, error) {
+		return nil, c.UpdateDestination(deref(args.Service), deref(args.Destination))
+	},
+	"RemoveDestination": func(c ipvs.Client, args call) (any, error) {
+		return nil, c.RemoveDestination(deref(args.Service), deref(args.Destination))
+	},
+	"Daemons": func(c ipvs.Client, _ call) (any, error) {
+		dc, ok := c.(ipvs.DaemonClient)
+		if !ok {
+			return nil, errors.ErrUnsupported
+		}
+
+		return dc.Daemons()
+	},
+	"CreateDaemon": func(c ipvs.Client, args call) (any, error) {
+		dc, ok := c.(ipvs.DaemonClient)
+		if !ok {
+			return nil, errors.ErrUnsupported
+		}
+
+		return nil, dc.CreateDaemon(deref(args.Daemon))
+	},
+	"RemoveDaemon": func(c ipvs.Client, args call) (any, error) {
+		dc, ok := c.(ipvs.DaemonClient)
+		if !ok {
+			return nil, errors.ErrUnsupported
+		}
+
+		return nil, dc.RemoveDaemon(deref(args.Daemon))
+	},
+	"Sysctls": func(c ipvs.Client, _ call) (any, error) {
+		sc, ok := c.(ipvs.SysctlClient)
+		if !ok {
+			return nil, errors.ErrUnsupported
+		}
+
+		return sc.Sysctls()
+	},
+	"SetSysctls": func(c ipvs.Client, args call) (any, error) {
+		sc, ok := c.(ipvs.SysctlClient)
+		if !ok {
+			return nil, errors.ErrUnsupported
+		}
+
+		return nil, sc.SetSysctls(deref(args.Sysctls))
+	},
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.authenticate(r); err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ipvs"`)
+		writeError(w, http.StatusUnauthorized, &Error{Code: CodeUnauthenticated, Message: err.Error()})
+		return
+	}
+
+	name, ok := strings.CutPrefix(r.URL.Path, "/"+Version+"/")
+	m, known := methods[name]
+	if !ok || !known {
+		writeError(w, http.StatusNotFound, &Error{Code: CodeUnimplemented, Message: "remote: unknown method " + r.URL.Path})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, &Error{Code: CodeBadRequest, Message: "remote: method must be POST"})
+		return
+	}
+
+	var args call
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&args); err != nil {
+		writeError(w, http.StatusBadRequest, &Error{Code: CodeBadRequest, Message: "remote: invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := m(s.client, args)
+	if err != nil {
+		writeError(w, 0, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// authenticate checks the credentials of r.
+func (s *Server) authenticate(r *http.Request) error {
+	if s.opts.RequireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		return errors.New("remote: client certificate required")
+	}
+
+	if len(s.opts.Tokens) == 0 {
+		if !s.opts.RequireClientCert && !s.opts.Insecure {
+			return errors.New("remote: server has no credentials configured")
+		}
+		return nil
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return errors.New("remote: bearer token required")
+	}
+
+	valid := 0
+	for _, t := range s.opts.Tokens {
+		valid |= subtle.ConstantTimeCompare([]byte(token), []byte(t))
+	}
+	if valid == 0 {
+		return errors.New("remote: invalid bearer token")
+	}
+
+	return nil
+}
+
+// statuses are the HTTP statuses of each Code.
+var statuses = map[Code]int{
+	CodeNotExist:        http.StatusNotFound,
+	CodeExist:           http.StatusConflict,
+	CodeInvalid:         http.StatusUnprocessableEntity,
+	CodeUnsupported:     http.StatusNotImplemented,
+	CodePermission:      http.StatusForbidden,
+	CodeUnauthenticated: http.StatusUnauthorized,
+	CodeBadRequest:      http.StatusBadRequest,
+	CodeUnimplemented:   http.StatusNotFound,
+	CodeInternal:        http.StatusInternalServerError,
+}
+
+// HTTPStatus returns the HTTP status of the response to a request which
+// failed with err: 404 for errors matching os.ErrNotExist, 422 for
+// *ipvs.FieldError, and so on, or 500 for unclassified errors.
+func HTTPStatus(err error) int {
+	return statuses[encodeError(err).Code]
+}
+
+// writeError writes err with status, or the status of its Code if zero.
+func writeError(w http.ResponseWriter, status int, err error) {
+	we := encodeError(err)
+	if status == 0 {
+		status = statuses[we.Code]
+	}
+
+	writeJSON(w, status, struct {
+		Error wireError `json:"error"`
+	}{we})
+}
+
+// writeJSON writes v as the body of the response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// deref returns *p, or the zero value if p is nil.
+func deref[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+
+	return *p
+}
@@ -0,0 +1,117 @@
+package rollout
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// DefaultPollInterval is how often connection counts and
+// gates are polled when no interval is configured.
+const DefaultPollInterval = time.Second
+
+// DrainOptions configures Drain.
+type DrainOptions struct {
+	// Interval between polls of the connection counts.
+	// Defaults to DefaultPollInterval.
+	Interval time.Duration
+
+	// The Destination is removed once each of its connection counts
+	// is at or below the corresponding threshold.
+	MaxActive     uint32
+	MaxInactive   uint32
+	MaxPersistent uint32
+
+	// Timeout, if non-zero, removes the Destination once it has elapsed,
+	// even if connections remain.
+	Timeout time.Duration
+
+	// Progress, if set, is called after each poll.
+	Progress func(DrainProgress)
+}
+
+// DrainProgress reports the state of a Destination being drained.
+type DrainProgress struct {
+	Destination ipvs.DestinationExtended
+
+	// Elapsed is the time since draining started.
+	Elapsed time.Duration
+
+	// Done is set on the final poll, before the Destination is removed.
+	Done bool
+
+	// TimedOut is set when the Destination is removed because
+	// the Timeout elapsed, rather than its connections draining.
+	TimedOut bool
+}
+
+// Drain stops scheduling new connections to the Destination of a Service
+// by setting its weight to zero, waits for its existing connections to
+// fall below the thresholds in opts, then removes it.
+//
+// If ctx is done first, Drain returns ctx.Err() and the Destination is
+// left in place with a weight of zero. A Destination which does not exist
+// is considered drained.
+func Drain(ctx context.Context, c ipvs.Client, svc ipvs.Service, dest ipvs.Destination, opts DrainOptions) error {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultPollInterval
+	}
+
+	current, err := lookup(c, svc, dest)
+	if isNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := setWeight(c, svc, current.Destination, 0); err != nil {
+		if isNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		current, err := lookup(c, svc, dest)
+		if isNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		p := DrainProgress{
+			Destination: current,
+			Elapsed:     time.Since(start),
+		}
+		p.TimedOut = opts.Timeout > 0 && p.Elapsed >= opts.Timeout
+		p.Done = p.TimedOut ||
+			current.ActiveConnections <= opts.MaxActive &&
+				current.InactiveConnections <= opts.MaxInactive &&
+				current.PersistentConnections <= opts.MaxPersistent
+
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+
+		if p.Done {
+			err := c.RemoveDestination(svc, current.Destination)
+			if isNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package rollout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"gotest.tools/v3/assert"
+)
+
+func TestDrain(t *testing.T) {
+	dest := testDestination(1, 10)
+	other := testDestination(2, 10)
+	c := testClient(t, dest, other)
+
+	assert.NilError(t, c.ModifyDestination(testService, dest, func(d *ipvs.DestinationExtended) {
+		d.ActiveConnections = 3
+		d.PersistentConnections = 1
+	}))
+
+	var polls []DrainProgress
+	err := Drain(context.Background(), c, testService, ipvs.Destination{Address: dest.Address, Port: dest.Port}, DrainOptions{
+		Interval:      time.Millisecond,
+		MaxPersistent: 1,
+		Progress: func(p DrainProgress) {
+			polls = append(polls, p)
+			assert.Equal(t, p.Destination.Weight, uint32(0))
+			assert.Equal(t, p.Destination.FwdMethod, ipvs.DirectRoute)
+
+			assert.NilError(t, c.ModifyDestination(testService, dest, func(d *ipvs.DestinationExtended) {
+				if d.ActiveConnections > 0 {
+					d.ActiveConnections--
+				}
+			}))
+		},
+	})
+	assert.NilError(t, err)
+
+	assert.Equal(t, len(polls), 4)
+	assert.Assert(t, polls[3].Done)
+	assert.Assert(t, !polls[3].TimedOut)
+	assert.DeepEqual(t, weights(t, c), map[byte]uint32{2: 10})
+}
+
+func TestDrain_Timeout(t *testing.T) {
+	dest := testDestination(1, 10)
+	c := testClient(t, dest)
+
+	assert.NilError(t, c.ModifyDestination(testService, dest, func(d *ipvs.DestinationExtended) {
+		d.ActiveConnections = 3
+	}))
+
+	var last DrainProgress
+	err := Drain(context.Background(), c, testService, dest, DrainOptions{
+		Interval: time.Millisecond,
+		Timeout:  10 * time.Millisecond,
+		Progress: func(p DrainProgress) {
+			last = p
+		},
+	})
+	assert.NilError(t, err)
+
+	assert.Assert(t, last.Done)
+	assert.Assert(t, last.TimedOut)
+	assert.DeepEqual(t, weights(t, c), map[byte]uint32{})
+}
+
+func TestDrain_Canceled(t *testing.T) {
+	dest := testDestination(1, 10)
+	c := testClient(t, dest)
+
+	assert.NilError(t, c.ModifyDestination(testService, dest, func(d *ipvs.DestinationExtended) {
+		d.InactiveConnections = 3
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := Drain(ctx, c, testService, dest, DrainOptions{Interval: time.Millisecond})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.DeepEqual(t, weights(t, c), map[byte]uint32{1: 0})
+}
+
+func TestDrain_NotExist(t *testing.T) {
+	c := testClient(t, testDestination(1, 10))
+
+	err := Drain(context.Background(), c, testService, testDestination(2, 10), DrainOptions{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, weights(t, c), map[byte]uint32{1: 10})
+}
@@ -0,0 +1,195 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// Curve determines how the weight of a Destination grows during a Ramp.
+type Curve int
+
+const (
+	// Linear increases the weight by the same amount at each step.
+	Linear Curve = iota
+
+	// Exponential multiplies the weight by the same factor at each step,
+	// keeping the increase small while the Destination is cold.
+	Exponential
+)
+
+// weight returns the weight at progress p, in [0, 1], of a ramp from start to target.
+func (c Curve) weight(start, target uint32, p float64) uint32 {
+	s, t := float64(start), float64(target)
+
+	var w float64
+	switch c {
+	case Exponential:
+		w = s * math.Pow(t/s, p)
+	default:
+		w = s + (t-s)*p
+	}
+
+	return uint32(math.Min(math.Round(w), t))
+}
+
+// progress returns the progress, in [0, 1], at which a ramp from start
+// to target reaches weight w. It is the inverse of weight.
+func (c Curve) progress(start, target, w uint32) float64 {
+	if w <= start {
+		return 0
+	}
+	if w >= target {
+		return 1
+	}
+
+	s, t, x := float64(start), float64(target), float64(w)
+	switch c {
+	case Exponential:
+		return math.Log(x/s) / math.Log(t/s)
+	default:
+		return (x - s) / (t - s)
+	}
+}
+
+// OnUnhealthy determines what a Ramp does when its health signal is bad.
+type OnUnhealthy int
+
+const (
+	// Pause holds the weight until the Destination is healthy again,
+	// then resumes the ramp where it left off.
+	Pause OnUnhealthy = iota
+
+	// Abort stops the ramp, returning ErrUnhealthy.
+	Abort
+)
+
+// ErrUnhealthy is returned by Ramp when it is aborted by the health signal.
+var ErrUnhealthy = errors.New("rollout: destination is unhealthy")
+
+// RampOptions configures Ramp.
+type RampOptions struct {
+	// Duration of the ramp, excluding any time spent paused.
+	Duration time.Duration
+
+	// Interval between weight changes. Defaults to DefaultPollInterval.
+	Interval time.Duration
+
+	// Curve of the ramp. Defaults to Linear.
+	Curve Curve
+
+	// Start is the weight the Destination is added with. Defaults to 1.
+	Start uint32
+
+	// Healthy, if set, is consulted before each step. When it reports
+	// false, the ramp is paused or aborted according to OnUnhealthy.
+	Healthy func() bool
+
+	OnUnhealthy OnUnhealthy
+
+	// Progress, if set, is called after each step.
+	Progress func(RampProgress)
+}
+
+// RampProgress reports the state of a Destination being ramped.
+type RampProgress struct {
+	// Weight is the current weight of the Destination.
+	Weight uint32
+
+	// Progress is the fraction of the ramp completed, in [0, 1].
+	Progress float64
+
+	// Paused is set while the health signal is bad.
+	Paused bool
+}
+
+// Ramp adds the Destination of a Service at a low weight, and raises it
+// to dest.Weight over the course of opts.Duration.
+//
+// If the Destination already exists, for example because a previous Ramp
+// was interrupted, the progress of the ramp is inferred from its current
+// weight and the ramp resumes from there. A Destination already at or
+// above its target weight is set to the target immediately.
+//
+// If ctx is done first, Ramp returns ctx.Err() and the Destination is
+// left at its current weight.
+func Ramp(ctx context.Context, c ipvs.Client, svc ipvs.Service, dest ipvs.Destination, opts RampOptions) error {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultPollInterval
+	}
+	if opts.Start == 0 {
+		opts.Start = 1
+	}
+
+	target := dest.Weight
+	if target <= opts.Start || opts.Duration <= 0 {
+		return ipvs.ApplyDestination(c, svc, dest)
+	}
+
+	weight := opts.Start
+	current, err := lookup(c, svc, dest)
+	switch {
+	case isNotExist(err):
+		d := dest
+		d.Weight = weight
+		if err := c.CreateDestination(svc, d); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case current.Weight >= target:
+		return setWeight(c, svc, dest, target)
+	default:
+		weight = current.Weight
+	}
+
+	elapsed := time.Duration(opts.Curve.progress(opts.Start, target, weight) * float64(opts.Duration))
+	last := time.Now()
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		step := now.Sub(last)
+		last = now
+
+		healthy := opts.Healthy == nil || opts.Healthy()
+		if !healthy && opts.OnUnhealthy == Abort {
+			return ErrUnhealthy
+		}
+
+		if healthy {
+			elapsed += step
+		}
+
+		p := math.Min(float64(elapsed)/float64(opts.Duration), 1)
+		if w := opts.Curve.weight(opts.Start, target, p); w != weight && healthy {
+			if err := setWeight(c, svc, dest, w); err != nil {
+				return err
+			}
+			weight = w
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(RampProgress{
+				Weight:   weight,
+				Progress: p,
+				Paused:   !healthy,
+			})
+		}
+
+		if p >= 1 {
+			return nil
+		}
+	}
+}
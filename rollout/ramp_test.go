@@ -0,0 +1,145 @@
+package rollout
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"gotest.tools/v3/assert"
+	"pgregory.net/rapid"
+)
+
+func TestCurve_Inverse(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		curve := rapid.SampledFrom([]Curve{Linear, Exponential}).Draw(t, "curve")
+		start := rapid.Uint32Range(1, 100).Draw(t, "start")
+		target := rapid.Uint32Range(start+1, 10000).Draw(t, "target")
+		w := rapid.Uint32Range(start, target).Draw(t, "weight")
+
+		p := curve.progress(start, target, w)
+		assert.Assert(t, p >= 0 && p <= 1)
+		assert.Equal(t, curve.weight(start, target, p), w)
+	})
+}
+
+func TestRamp(t *testing.T) {
+	type testCase struct {
+		name    string
+		curve   Curve
+		initial *ipvs.Destination
+		first   uint32
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		var c ipvs.Client
+		if tc.initial != nil {
+			c = testClient(t, *tc.initial)
+		} else {
+			c = testClient(t)
+		}
+
+		var steps []RampProgress
+		err := Ramp(context.Background(), c, testService, testDestination(1, 100), RampOptions{
+			Duration: 20 * time.Millisecond,
+			Interval: time.Millisecond,
+			Curve:    tc.curve,
+			Progress: func(p RampProgress) {
+				steps = append(steps, p)
+			},
+		})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, weights(t, c), map[byte]uint32{1: 100})
+
+		assert.Assert(t, len(steps) > 0)
+		assert.Assert(t, steps[0].Weight >= tc.first, "first step %d", steps[0].Weight)
+		for i := 1; i < len(steps); i++ {
+			assert.Assert(t, steps[i].Weight >= steps[i-1].Weight)
+			assert.Assert(t, steps[i].Progress >= steps[i-1].Progress)
+		}
+		assert.Equal(t, steps[len(steps)-1].Progress, 1.0)
+	}
+
+	resumed := testDestination(1, 60)
+
+	testCases := []testCase{
+		{
+			name:  "linear",
+			curve: Linear,
+			first: 1,
+		},
+		{
+			name:  "exponential",
+			curve: Exponential,
+			first: 1,
+		},
+		{
+			name:    "resume",
+			curve:   Linear,
+			initial: &resumed,
+			first:   60,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestRamp_AboveTarget(t *testing.T) {
+	c := testClient(t, testDestination(1, 200))
+
+	err := Ramp(context.Background(), c, testService, testDestination(1, 100), RampOptions{
+		Duration: time.Hour,
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, weights(t, c), map[byte]uint32{1: 100})
+}
+
+func TestRamp_Unhealthy(t *testing.T) {
+	t.Run("pause", func(t *testing.T) {
+		c := testClient(t)
+
+		var healthy atomic.Bool
+		healthy.Store(true)
+
+		var paused int
+		err := Ramp(context.Background(), c, testService, testDestination(1, 100), RampOptions{
+			Duration: 20 * time.Millisecond,
+			Interval: time.Millisecond,
+			Healthy:  healthy.Load,
+			Progress: func(p RampProgress) {
+				if p.Paused {
+					paused++
+					if paused == 5 {
+						healthy.Store(true)
+					}
+					return
+				}
+
+				if p.Weight >= 50 && paused == 0 {
+					healthy.Store(false)
+				}
+			},
+		})
+		assert.NilError(t, err)
+		assert.Equal(t, paused, 5)
+		assert.DeepEqual(t, weights(t, c), map[byte]uint32{1: 100})
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		c := testClient(t)
+
+		err := Ramp(context.Background(), c, testService, testDestination(1, 100), RampOptions{
+			Duration:    20 * time.Millisecond,
+			Interval:    time.Millisecond,
+			Healthy:     func() bool { return false },
+			OnUnhealthy: Abort,
+		})
+		assert.ErrorIs(t, err, ErrUnhealthy)
+		assert.DeepEqual(t, weights(t, c), map[byte]uint32{1: 1})
+	})
+}
@@ -0,0 +1,38 @@
+// Package rollout gradually changes the weights of IPVS destinations,
+// to drain, warm up, and shift traffic between real servers during deploys.
+package rollout
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// lookup returns the current configuration of dest within svc.
+// It returns fs.ErrNotExist if the Destination is not configured.
+func lookup(c ipvs.Client, svc ipvs.Service, dest ipvs.Destination) (ipvs.DestinationExtended, error) {
+	dests, err := c.Destinations(svc)
+	if err != nil {
+		return ipvs.DestinationExtended{}, err
+	}
+
+	for _, d := range dests {
+		if d.Key() == dest.Key() {
+			return d, nil
+		}
+	}
+
+	return ipvs.DestinationExtended{}, fs.ErrNotExist
+}
+
+// setWeight changes the weight of dest, keeping the rest of its configuration.
+func setWeight(c ipvs.Client, svc ipvs.Service, dest ipvs.Destination, weight uint32) error {
+	dest.Weight = weight
+	return c.UpdateDestination(svc, dest)
+}
+
+// isNotExist reports whether err means the Destination is not configured.
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
@@ -0,0 +1,62 @@
+package rollout
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/ipvstest"
+	"gotest.tools/v3/assert"
+)
+
+var testService = ipvs.Service{
+	Address:   netip.MustParseAddr("192.0.2.1"),
+	Port:      80,
+	Family:    ipvs.INET,
+	Protocol:  ipvs.TCP,
+	Scheduler: "wlc",
+}
+
+// testDestination returns a direct-routed Destination with the given
+// last address octet and weight.
+func testDestination(n byte, weight uint32) ipvs.Destination {
+	return ipvs.Destination{
+		Address:   netip.AddrFrom4([4]byte{198, 51, 100, n}),
+		Port:      8080,
+		Family:    ipvs.INET,
+		FwdMethod: ipvs.DirectRoute,
+		Weight:    weight,
+	}
+}
+
+// testClient returns a Client with testService configured with dests.
+func testClient(t *testing.T, dests ...ipvs.Destination) *ipvstest.Client {
+	t.Helper()
+
+	c := ipvstest.New()
+	assert.NilError(t, c.CreateService(testService))
+	for _, dest := range dests {
+		assert.NilError(t, c.CreateDestination(testService, dest))
+	}
+
+	return c
+}
+
+// weights returns the weight of each Destination of testService,
+// keyed by the last octet of its address.
+func weights(t *testing.T, c ipvs.Client) map[byte]uint32 {
+	t.Helper()
+
+	dests, err := c.Destinations(testService)
+	if isNotExist(err) {
+		return map[byte]uint32{}
+	}
+	assert.NilError(t, err)
+
+	w := make(map[byte]uint32, len(dests))
+	for _, d := range dests {
+		w[d.Address.As4()[3]] = d.Weight
+	}
+
+	return w
+}
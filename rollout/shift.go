@@ -0,0 +1,373 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+)
+
+// ShiftOptions configures Shift.
+type ShiftOptions struct {
+	// From is the pool traffic is moved away from, and To is the pool traffic
+	// is moved to. The Weight of each Destination is its weight when it
+	// receives all of its pool's share of traffic.
+	From []ipvs.Destination
+	To   []ipvs.Destination
+
+	// Steps are the fractions of traffic moved to the To pool at each step,
+	// in increasing order. A final step below 1 leaves the traffic split,
+	// as for a canary. Defaults to DefaultSteps.
+	Steps []float64
+
+	// Gates are checked in order after each step. The next step is only
+	// taken once every Gate has passed.
+	Gates []Gate
+
+	// Rollback restores the original weights if the Shift fails.
+	Rollback bool
+
+	// Progress, if set, is called after each step has been applied.
+	Progress func(ShiftStep)
+}
+
+// DefaultSteps shifts traffic in quarters.
+var DefaultSteps = []float64{0.25, 0.5, 0.75, 1}
+
+// ShiftStep describes a step of a Shift.
+type ShiftStep struct {
+	Client  ipvs.Client
+	Service ipvs.Service
+
+	// Index of the step within Steps.
+	Index int
+
+	// Fraction of traffic moved to the To pool.
+	Fraction float64
+}
+
+// A Gate decides whether a Shift may proceed after a step.
+// It returns nil to proceed, or an error to fail the Shift.
+type Gate interface {
+	Check(ctx context.Context, step ShiftStep) error
+}
+
+// GateFunc adapts a function to the Gate interface.
+type GateFunc func(ctx context.Context, step ShiftStep) error
+
+// Check calls f(ctx, step).
+func (f GateFunc) Check(ctx context.Context, step ShiftStep) error {
+	return f(ctx, step)
+}
+
+// Wait returns a Gate which passes once d has elapsed.
+func Wait(d time.Duration) Gate {
+	return GateFunc(func(ctx context.Context, _ ShiftStep) error {
+		t := time.NewTimer(d)
+		defer t.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			return nil
+		}
+	})
+}
+
+// DefaultRateWindow is the period over which Thresholds measures rates
+// when no window is configured.
+const DefaultRateWindow = time.Second
+
+// Thresholds is a Gate which fails when a Destination exceeds a connection
+// count, or a rate of connections, packets or bytes. A zero threshold is
+// not checked.
+type Thresholds struct {
+	// Destinations to check. If empty, every Destination of the Service is checked.
+	Destinations []ipvs.Destination
+
+	MaxActive     uint32
+	MaxInactive   uint32
+	MaxPersistent uint32
+
+	// Rates are per second, computed from the difference between the
+	// Stats of each Destination at the start and end of Window. The rates
+	// estimated by the kernel lag behind changes of weight, so they are
+	// not used.
+	MaxConnectionRate     uint64
+	MaxIncomingPacketRate uint64
+	MaxOutgoingPacketRate uint64
+	MaxIncomingByteRate   uint64
+	MaxOutgoingByteRate   uint64
+
+	// Window over which rates are measured. Defaults to DefaultRateWindow.
+	Window time.Duration
+}
+
+// Check implements Gate. If a rate threshold is set, Check takes Window
+// to return.
+func (t Thresholds) Check(ctx context.Context, step ShiftStep) error {
+	dests, err := step.Client.Destinations(step.Service)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range dests {
+		if !t.includes(d.Destination) {
+			continue
+		}
+
+		switch {
+		case t.MaxActive > 0 && d.ActiveConnections > t.MaxActive:
+			return fmt.Errorf("rollout: destination %s has %d active connections, above threshold of %d", d.Address, d.ActiveConnections, t.MaxActive)
+		case t.MaxInactive > 0 && d.InactiveConnections > t.MaxInactive:
+			return fmt.Errorf("rollout: destination %s has %d inactive connections, above threshold of %d", d.Address, d.InactiveConnections, t.MaxInactive)
+		case t.MaxPersistent > 0 && d.PersistentConnections > t.MaxPersistent:
+			return fmt.Errorf("rollout: destination %s has %d persistent connections, above threshold of %d", d.Address, d.PersistentConnections, t.MaxPersistent)
+		}
+	}
+
+	if !t.hasRates() {
+		return nil
+	}
+
+	return t.checkRates(ctx, step, dests)
+}
+
+// hasRates reports whether any rate threshold is set.
+func (t Thresholds) hasRates() bool {
+	return t.MaxConnectionRate > 0 || t.MaxIncomingPacketRate > 0 || t.MaxOutgoingPacketRate > 0 ||
+		t.MaxIncomingByteRate > 0 || t.MaxOutgoingByteRate > 0
+}
+
+// checkRates samples the Stats of the Destinations again after Window,
+// and compares their rates since before with the thresholds.
+func (t Thresholds) checkRates(ctx context.Context, step ShiftStep, before []ipvs.DestinationExtended) error {
+	window := t.Window
+	if window <= 0 {
+		window = DefaultRateWindow
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+
+	after, err := step.Client.Destinations(step.Service)
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start).Seconds()
+
+	for _, d := range after {
+		if !t.includes(d.Destination) {
+			continue
+		}
+
+		i := slices.IndexFunc(before, func(b ipvs.DestinationExtended) bool { return b.Key() == d.Key() })
+		if i < 0 {
+			continue
+		}
+		was, _ := before[i].Counters()
+		now, _ := d.Counters()
+
+		rates := []struct {
+			name      string
+			was, now  uint64
+			threshold uint64
+		}{
+			{"connections", was.Connections, now.Connections, t.MaxConnectionRate},
+			{"incoming packets", was.IncomingPackets, now.IncomingPackets, t.MaxIncomingPacketRate},
+			{"outgoing packets", was.OutgoingPackets, now.OutgoingPackets, t.MaxOutgoingPacketRate},
+			{"incoming bytes", was.IncomingBytes, now.IncomingBytes, t.MaxIncomingByteRate},
+			{"outgoing bytes", was.OutgoingBytes, now.OutgoingBytes, t.MaxOutgoingByteRate},
+		}
+		for _, r := range rates {
+			// Counters which went backwards were reset, or wrapped.
+			if r.threshold == 0 || r.now < r.was {
+				continue
+			}
+
+			rate := uint64(float64(r.now-r.was) / elapsed)
+			if rate > r.threshold {
+				return fmt.Errorf("rollout: destination %s has %d %s per second, above threshold of %d", d.Address, rate, r.name, r.threshold)
+			}
+		}
+	}
+
+	return nil
+}
+
+// includes reports whether dest is checked by t.
+func (t Thresholds) includes(dest ipvs.Destination) bool {
+	if len(t.Destinations) == 0 {
+		return true
+	}
+
+	for _, d := range t.Destinations {
+		if d.Key() == dest.Key() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Shift moves traffic on a Service from one pool of Destinations to another,
+// by adjusting their weights in steps. Destinations of the To pool which do
+// not exist are created with a weight of zero before the first step.
+// Destinations of the From pool are left in place, with their weight at zero
+// once all traffic has been moved.
+//
+// If a step cannot be applied, a Gate fails, or ctx is done, Shift returns
+// the error, first restoring the original weights and removing any created
+// Destinations if opts.Rollback is set.
+func Shift(ctx context.Context, c ipvs.Client, svc ipvs.Service, opts ShiftOptions) error {
+	steps := opts.Steps
+	if len(steps) == 0 {
+		steps = DefaultSteps
+	}
+	for i, f := range steps {
+		if f < 0 || f > 1 || i > 0 && f < steps[i-1] {
+			return fmt.Errorf("rollout: invalid shift steps %v", steps)
+		}
+	}
+
+	s := shift{c: c, svc: svc}
+	if err := s.prepare(opts.To); err != nil {
+		return s.fail(err, opts.Rollback)
+	}
+
+	for i, f := range steps {
+		if err := ctx.Err(); err != nil {
+			return s.fail(err, opts.Rollback)
+		}
+
+		if err := s.apply(opts.From, 1-f); err != nil {
+			return s.fail(err, opts.Rollback)
+		}
+		if err := s.apply(opts.To, f); err != nil {
+			return s.fail(err, opts.Rollback)
+		}
+
+		step := ShiftStep{
+			Client:   c,
+			Service:  svc,
+			Index:    i,
+			Fraction: f,
+		}
+		if opts.Progress != nil {
+			opts.Progress(step)
+		}
+
+		for _, g := range opts.Gates {
+			if err := g.Check(ctx, step); err != nil {
+				return s.fail(err, opts.Rollback)
+			}
+		}
+	}
+
+	return nil
+}
+
+// shift tracks the changes made by a Shift, so they can be rolled back.
+type shift struct {
+	c   ipvs.Client
+	svc ipvs.Service
+
+	// original configuration of changed Destinations.
+	original []ipvs.Destination
+
+	// created Destinations, which did not exist before the Shift.
+	created []ipvs.Destination
+}
+
+// prepare creates any Destination of pool which does not exist, with a weight of zero.
+func (s *shift) prepare(pool []ipvs.Destination) error {
+	for _, dest := range pool {
+		_, err := lookup(s.c, s.svc, dest)
+		if err == nil {
+			continue
+		}
+		if !isNotExist(err) {
+			return err
+		}
+
+		d := dest
+		d.Weight = 0
+		if err := s.c.CreateDestination(s.svc, d); err != nil {
+			return err
+		}
+		s.created = append(s.created, d)
+	}
+
+	return nil
+}
+
+// apply sets each Destination of pool to fraction of its weight.
+func (s *shift) apply(pool []ipvs.Destination, fraction float64) error {
+	for _, dest := range pool {
+		current, err := lookup(s.c, s.svc, dest)
+		if err != nil {
+			return err
+		}
+
+		w := uint32(math.Round(float64(dest.Weight) * fraction))
+		if current.Weight == w {
+			continue
+		}
+
+		s.remember(current.Destination)
+		if err := setWeight(s.c, s.svc, current.Destination, w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remember records the original configuration of dest, if it
+// existed before the Shift and has not already been recorded.
+func (s *shift) remember(dest ipvs.Destination) {
+	for _, d := range append(s.original, s.created...) {
+		if d.Key() == dest.Key() {
+			return
+		}
+	}
+
+	s.original = append(s.original, dest)
+}
+
+// fail returns err, after undoing the changes of the Shift if rollback is set.
+func (s *shift) fail(err error, rollback bool) error {
+	if !rollback {
+		return err
+	}
+
+	var errs []error
+	for _, d := range s.original {
+		if err := s.c.UpdateDestination(s.svc, d); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, d := range s.created {
+		if err := s.c.RemoveDestination(s.svc, d); err != nil && !isNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w (rollback failed: %w)", err, errors.Join(errs...))
+	}
+
+	return err
+}
@@ -0,0 +1,201 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"gotest.tools/v3/assert"
+)
+
+func TestShift(t *testing.T) {
+	type testCase struct {
+		name     string
+		steps    []float64
+		expected []map[byte]uint32
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		blue := []ipvs.Destination{testDestination(1, 10), testDestination(2, 10)}
+		green := []ipvs.Destination{testDestination(3, 20), testDestination(4, 20)}
+		c := testClient(t, blue...)
+
+		var got []map[byte]uint32
+		err := Shift(context.Background(), c, testService, ShiftOptions{
+			From:  blue,
+			To:    green,
+			Steps: tc.steps,
+			Gates: []Gate{Wait(time.Millisecond)},
+			Progress: func(step ShiftStep) {
+				got = append(got, weights(t, step.Client))
+			},
+		})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, got, tc.expected)
+	}
+
+	testCases := []testCase{
+		{
+			name: "blue/green",
+			expected: []map[byte]uint32{
+				{1: 8, 2: 8, 3: 5, 4: 5},
+				{1: 5, 2: 5, 3: 10, 4: 10},
+				{1: 3, 2: 3, 3: 15, 4: 15},
+				{1: 0, 2: 0, 3: 20, 4: 20},
+			},
+		},
+		{
+			name:  "canary",
+			steps: []float64{0.1},
+			expected: []map[byte]uint32{
+				{1: 9, 2: 9, 3: 2, 4: 2},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestShift_Rollback(t *testing.T) {
+	type testCase struct {
+		name     string
+		rollback bool
+		expected map[byte]uint32
+	}
+
+	errGate := errors.New("gate failed")
+
+	run := func(t *testing.T, tc testCase) {
+		blue := []ipvs.Destination{testDestination(1, 10)}
+		green := []ipvs.Destination{testDestination(2, 10)}
+		c := testClient(t, blue...)
+
+		err := Shift(context.Background(), c, testService, ShiftOptions{
+			From: blue,
+			To:   green,
+			Gates: []Gate{GateFunc(func(_ context.Context, step ShiftStep) error {
+				if step.Index == 2 {
+					return errGate
+				}
+				return nil
+			})},
+			Rollback: tc.rollback,
+		})
+		assert.ErrorIs(t, err, errGate)
+		assert.DeepEqual(t, weights(t, c), tc.expected)
+	}
+
+	testCases := []testCase{
+		{
+			name:     "rollback",
+			rollback: true,
+			expected: map[byte]uint32{1: 10},
+		},
+		{
+			name:     "no rollback",
+			expected: map[byte]uint32{1: 3, 2: 8},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestShift_Thresholds(t *testing.T) {
+	blue := []ipvs.Destination{testDestination(1, 10)}
+	green := []ipvs.Destination{testDestination(2, 10)}
+	c := testClient(t, blue...)
+
+	gate := GateFunc(func(ctx context.Context, step ShiftStep) error {
+		if step.Index == 1 {
+			err := c.ModifyDestination(testService, green[0], func(d *ipvs.DestinationExtended) {
+				d.ActiveConnections = 100
+			})
+			assert.NilError(t, err)
+		}
+		return nil
+	})
+
+	err := Shift(context.Background(), c, testService, ShiftOptions{
+		From:     blue,
+		To:       green,
+		Gates:    []Gate{gate, Thresholds{Destinations: green, MaxActive: 50}},
+		Rollback: true,
+	})
+	assert.ErrorContains(t, err, "100 active connections")
+	assert.DeepEqual(t, weights(t, c), map[byte]uint32{1: 10})
+}
+
+func TestShift_InvalidSteps(t *testing.T) {
+	c := testClient(t)
+
+	err := Shift(context.Background(), c, testService, ShiftOptions{
+		Steps: []float64{0.5, 0.25},
+	})
+	assert.ErrorContains(t, err, "invalid shift steps")
+}
+
+func TestThresholds_Rates(t *testing.T) {
+	type testCase struct {
+		name     string
+		gate     Thresholds
+		expected string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		dest := testDestination(1, 10)
+		c := testClient(t, dest)
+		assert.NilError(t, c.ModifyDestination(testService, dest, func(d *ipvs.DestinationExtended) {
+			d.Stats64 = ipvs.Stats{Connections: 1000, IncomingBytes: 1 << 20}
+		}))
+
+		// Traffic arrives while the gate measures its rates.
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			assert.Check(t, c.ModifyDestination(testService, dest, func(d *ipvs.DestinationExtended) {
+				d.Stats64.Connections += 100
+				d.Stats64.IncomingBytes += 1 << 20
+			}))
+		}()
+
+		tc.gate.Window = 100 * time.Millisecond
+		err := tc.gate.Check(context.Background(), ShiftStep{Client: c, Service: testService})
+		if tc.expected == "" {
+			assert.NilError(t, err)
+			return
+		}
+		assert.ErrorContains(t, err, tc.expected)
+	}
+
+	testCases := []testCase{
+		{
+			name: "below",
+			gate: Thresholds{MaxConnectionRate: 10000, MaxIncomingByteRate: 1 << 30},
+		},
+		{
+			name:     "connections",
+			gate:     Thresholds{MaxConnectionRate: 100},
+			expected: "connections per second, above threshold of 100",
+		},
+		{
+			name:     "bytes",
+			gate:     Thresholds{MaxIncomingByteRate: 1 << 10},
+			expected: "incoming bytes per second, above threshold of 1024",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
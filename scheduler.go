@@ -0,0 +1,222 @@
+package ipvs
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/ipvs/internal/cipvs"
+)
+
+// Scheduler is the name of the algorithm used to schedule connections of a
+// Service to its Destinations. The Scheduler field of a Service is a plain
+// string; convert it to use the methods of Scheduler.
+type Scheduler string
+
+// Schedulers included with Linux. They are untyped, so they can be used
+// both as a Scheduler and for the Scheduler of a Service.
+const (
+	RoundRobin              = "rr"
+	WeightedRoundRobin      = "wrr"
+	LeastConnection         = "lc"
+	WeightedLeastConnection = "wlc"
+	LocalityLeastConnection = "lblc"
+	LocalityReplication     = "lblcr"
+	DestinationHashing      = "dh"
+	SourceHashing           = "sh"
+	ShortestExpectedDelay   = "sed"
+	NeverQueue              = "nq"
+	WeightedFailover        = "fo"
+	WeightedOverflow        = "ovf"
+	MaglevHashing           = "mh"
+	TwoRandomChoices        = "twos"
+)
+
+// Schedulers lists the schedulers included with Linux.
+var Schedulers = []string{
+	RoundRobin,
+	WeightedRoundRobin,
+	LeastConnection,
+	WeightedLeastConnection,
+	LocalityLeastConnection,
+	LocalityReplication,
+	DestinationHashing,
+	SourceHashing,
+	ShortestExpectedDelay,
+	NeverQueue,
+	WeightedFailover,
+	WeightedOverflow,
+	MaglevHashing,
+	TwoRandomChoices,
+}
+
+// Flags specific to the source hashing and maglev hashing schedulers, which
+// share the scheduler-specific flags of a Service.
+const (
+	// SourceHashFallback reschedules connections from unavailable
+	// Destinations to another Destination.
+	SourceHashFallback = ServiceSchedulerOpt1
+
+	// SourceHashPort includes the source port in the hash.
+	SourceHashPort = ServiceSchedulerOpt2
+
+	// MaglevFallback reschedules connections from unavailable
+	// Destinations to another Destination.
+	MaglevFallback = ServiceSchedulerOpt1
+
+	// MaglevPort includes the source port in the hash.
+	MaglevPort = ServiceSchedulerOpt2
+)
+
+// schedulerFlag is a scheduler-specific flag and its ipvsadm name.
+type schedulerFlag struct {
+	name string
+	flag Flags
+}
+
+// schedulerFlags maps the ipvsadm names of scheduler-specific flags
+// to their values, for each scheduler which accepts them.
+var schedulerFlags = map[Scheduler][]schedulerFlag{
+	SourceHashing: {
+		{"sh-fallback", SourceHashFallback},
+		{"sh-port", SourceHashPort},
+	},
+	MaglevHashing: {
+		{"mh-fallback", MaglevFallback},
+		{"mh-port", MaglevPort},
+	},
+}
+
+// Known reports whether s is one of the schedulers included with Linux.
+func (s Scheduler) Known() bool {
+	return slices.Contains(Schedulers, string(s))
+}
+
+// Validate reports whether s can be passed to IPVS as a scheduler name.
+// Unknown schedulers are valid, as they may be provided by other modules.
+// The error is a *FieldError for the Scheduler field of a Service.
+func (s Scheduler) Validate() error {
+	var err error
+	switch {
+	case s == "":
+		err = errors.New("name is empty")
+	case len(s) >= cipvs.SchednameMaxlen:
+		err = fmt.Errorf("name %q is longer than %d bytes", string(s), cipvs.SchednameMaxlen-1)
+	case strings.ContainsFunc(string(s), func(r rune) bool { return r <= ' ' || r > '~' }):
+		err = fmt.Errorf("name %q contains invalid characters", string(s))
+	default:
+		return nil
+	}
+
+	return &FieldError{Field: "Scheduler", Err: err}
+}
+
+// ParseFlags parses a comma-separated list of scheduler-specific flags,
+// as accepted by the --sched-flags option of ipvsadm, for the scheduler s.
+// Flags belonging to other schedulers are rejected.
+func (s Scheduler) ParseFlags(list string) (Flags, error) {
+	var flags Flags
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		switch name {
+		case "flag-1":
+			flags |= ServiceSchedulerOpt1
+			continue
+		case "flag-2":
+			flags |= ServiceSchedulerOpt2
+			continue
+		case "flag-3":
+			flags |= ServiceSchedulerOpt3
+			continue
+		}
+
+		i := slices.IndexFunc(schedulerFlags[s], func(f schedulerFlag) bool {
+			return f.name == name
+		})
+		if i < 0 {
+			return 0, fmt.Errorf("ipvs: unknown flag %q for scheduler %q", name, string(s))
+		}
+
+		flags |= schedulerFlags[s][i].flag
+	}
+
+	return flags, nil
+}
+
+// FlagNames returns the names of the scheduler-specific flags set in f, as
+// used by ipvsadm, for the scheduler s.
+func (s Scheduler) FlagNames(f Flags) []string {
+	var names []string
+	for i, opt := range []Flags{ServiceSchedulerOpt1, ServiceSchedulerOpt2, ServiceSchedulerOpt3} {
+		if f&opt == 0 {
+			continue
+		}
+
+		name := fmt.Sprintf("flag-%d", i+1)
+		for _, sf := range schedulerFlags[s] {
+			if sf.flag == opt {
+				name = sf.name
+			}
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// schedulerFlagByName returns the scheduler-specific flag with the given
+// ipvsadm name, for any scheduler.
+func schedulerFlagByName(name string) (Flags, bool) {
+	for _, flags := range schedulerFlags {
+		for _, sf := range flags {
+			if sf.name == name {
+				return sf.flag, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// schedulerFlagAliases returns the ipvsadm names of the scheduler-specific
+// flag opt, for every scheduler which accepts it.
+func schedulerFlagAliases(opt Flags) []string {
+	var names []string
+	for _, s := range Schedulers {
+		for _, sf := range schedulerFlags[Scheduler(s)] {
+			if sf.flag == opt {
+				names = append(names, sf.name)
+			}
+		}
+	}
+
+	return names
+}
+
+// ModulesPath is the list of loaded kernel modules.
+const ModulesPath = "/proc/modules"
+
+// LoadedSchedulers returns the known schedulers whose ip_vs_* kernel module
+// is loaded, according to the module list at path, such as ModulesPath.
+//
+// Schedulers built into the kernel, rather than as modules, are not listed.
+func LoadedSchedulers(path string) ([]string, error) {
+	modules, err := readModules(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var schedulers []string
+	for _, m := range modules {
+		if Scheduler(m).Known() {
+			schedulers = append(schedulers, m)
+		}
+	}
+
+	return schedulers, nil
+}
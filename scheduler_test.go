@@ -0,0 +1,96 @@
+package ipvs
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestScheduler_Validate(t *testing.T) {
+	type testCase struct {
+		name      string
+		scheduler Scheduler
+		err       string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		err := tc.scheduler.Validate()
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err)
+			return
+		}
+		assert.NilError(t, err)
+	}
+
+	testCases := []testCase{
+		{name: "known", scheduler: MaglevHashing},
+		{name: "unknown", scheduler: "custom"},
+		{name: "longest", scheduler: Scheduler(strings.Repeat("x", 15))},
+		{name: "empty", err: "empty"},
+		{name: "too long", scheduler: Scheduler(strings.Repeat("x", 16)), err: "longer than 15 bytes"},
+		{name: "space", scheduler: "r r", err: "invalid characters"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestScheduler_ParseFlags(t *testing.T) {
+	type testCase struct {
+		name      string
+		scheduler Scheduler
+		list      string
+		expected  Flags
+		err       string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		flags, err := tc.scheduler.ParseFlags(tc.list)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err)
+			return
+		}
+		assert.NilError(t, err)
+		assert.Equal(t, flags, tc.expected)
+		assert.Equal(t, strings.Join(tc.scheduler.FlagNames(flags), ","), tc.list)
+	}
+
+	testCases := []testCase{
+		{name: "empty", scheduler: SourceHashing},
+		{name: "sh", scheduler: SourceHashing, list: "sh-fallback,sh-port", expected: SourceHashFallback | SourceHashPort},
+		{name: "mh", scheduler: MaglevHashing, list: "mh-port", expected: MaglevPort},
+		{name: "generic", scheduler: RoundRobin, list: "flag-1,flag-3", expected: ServiceSchedulerOpt1 | ServiceSchedulerOpt3},
+		{name: "other scheduler", scheduler: MaglevHashing, list: "sh-port", err: `unknown flag "sh-port" for scheduler "mh"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestScheduler_Known(t *testing.T) {
+	assert.Assert(t, Scheduler(MaglevHashing).Known())
+	assert.Assert(t, !Scheduler("custom").Known())
+}
+
+func TestFlagsString(t *testing.T) {
+	flags := ServicePersistent | SourceHashFallback | MaglevPort | ServiceSchedulerOpt3 | 0x100
+	assert.Equal(t, flags.String(), "ServicePersistent | ServiceSchedulerOpt1 (sh-fallback/mh-fallback) | "+
+		"ServiceSchedulerOpt2 (sh-port/mh-port) | ServiceSchedulerOpt3 | 0x100")
+
+	var got Flags
+	assert.NilError(t, got.UnmarshalText([]byte("ServicePersistent, sh-fallback, mh-port")))
+	assert.Equal(t, got, ServicePersistent|SourceHashFallback|MaglevPort)
+}
+
+func TestLoadedSchedulers(t *testing.T) {
+	schedulers, err := LoadedSchedulers("testdata/modules")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, schedulers, []string{MaglevHashing, WeightedRoundRobin, SourceHashing})
+}
@@ -0,0 +1,622 @@
+// Package snapshot captures the configuration of IPVS as a versioned
+// document, and restores it through an ipvs.Client.
+//
+// Snapshots hold configuration, and metadata describing where and when
+// they were taken. Statistics are only included when asked for, and are
+// ignored by Digest and Restore along with the metadata, so snapshots of
+// an unchanged table have equal digests.
+//
+// Documents are JSON, or YAML with the same field names. Documents written
+// by older versions of this package are migrated forward by Decode.
+package snapshot
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"time"
+
+	"github.com/cloudflare/ipvs"
+	"sigs.k8s.io/yaml"
+)
+
+// Version is the version of the document format written by Encode.
+//
+// Version 2 added the metadata, daemons and statistics.
+const Version = 2
+
+// Snapshot is the configuration of IPVS.
+type Snapshot struct {
+	Version int `json:"version"`
+
+	Metadata Metadata `json:"metadata,omitzero"`
+
+	// Config holds the timeouts, which are left unchanged by Restore if nil.
+	Config *ipvs.Config `json:"config,omitempty"`
+
+	// Daemons are the synchronization daemons, ordered by state. Daemons
+	// is nil if the Client does not implement ipvs.DaemonClient, and
+	// daemons are left unchanged by Restore if nil.
+	Daemons []ipvs.Daemon `json:"daemons,omitzero"`
+
+	// Services are ordered by their keys.
+	Services []Service `json:"services"`
+
+	// Stats are the statistics of the Services, if taken.
+	Stats []ServiceStats `json:"stats,omitempty"`
+}
+
+// Metadata describes where and when a snapshot was taken.
+type Metadata struct {
+	// Host is the name of the host, if given to TakeWith.
+	Host string `json:"host,omitempty"`
+
+	// Info describes the IPVS implementation of the host, including
+	// its version.
+	Info *ipvs.Info `json:"info,omitempty"`
+
+	Taken time.Time `json:"taken,omitzero"`
+}
+
+// Service is a Service and its Destinations, ordered by their keys.
+type Service struct {
+	ipvs.Service
+	Destinations []ipvs.Destination `json:"destinations,omitempty"`
+}
+
+// ServiceStats are the statistics of a Service and its Destinations.
+type ServiceStats struct {
+	Service      ipvs.ServiceKey    `json:"service"`
+	Stats        ipvs.Stats         `json:"stats"`
+	Truncated    bool               `json:"truncated,omitempty"`
+	Destinations []DestinationStats `json:"destinations"`
+}
+
+// DestinationStats are the statistics of a Destination.
+type DestinationStats struct {
+	Destination           ipvs.DestinationKey `json:"destination"`
+	ActiveConnections     uint32              `json:"activeConnections"`
+	InactiveConnections   uint32              `json:"inactiveConnections"`
+	PersistentConnections uint32              `json:"persistentConnections"`
+	Stats                 ipvs.Stats          `json:"stats"`
+	Truncated             bool                `json:"truncated,omitempty"`
+}
+
+// Options change how a snapshot is taken.
+type Options struct {
+	// Host is recorded in the metadata.
+	Host string
+
+	// Stats includes the statistics of the Services.
+	Stats bool
+}
+
+// Take returns a snapshot of the configuration of c.
+func Take(c ipvs.Client) (*Snapshot, error) {
+	return TakeWith(c, Options{})
+}
+
+// TakeWith returns a snapshot of c, as changed by opts.
+func TakeWith(c ipvs.Client, opts Options) (*Snapshot, error) {
+	info, err := c.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := c.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := c.Services()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	s := &Snapshot{
+		Version: Version,
+		Metadata: Metadata{
+			Host:  opts.Host,
+			Info:  &info,
+			Taken: time.Now().UTC(),
+		},
+		Config:   &config,
+		Services: make([]Service, 0, len(services)),
+	}
+
+	if dc, ok := c.(ipvs.DaemonClient); ok {
+		daemons, err := dc.Daemons()
+		switch {
+		case err == nil:
+			s.Daemons = append([]ipvs.Daemon{}, daemons...)
+		case !errors.Is(err, errors.ErrUnsupported):
+			return nil, fmt.Errorf("snapshot: daemons: %w", err)
+		}
+	}
+
+	for _, se := range services {
+		svc := Service{Service: se.Service}
+		// ServiceHashed reports the kernel's state, not configuration.
+		svc.Flags &^= ipvs.ServiceHashed
+
+		dests, err := c.Destinations(se.Service)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("snapshot: service %s: %w", se.Key(), err)
+		}
+		for _, de := range dests {
+			svc.Destinations = append(svc.Destinations, de.Destination)
+		}
+
+		s.Services = append(s.Services, svc)
+
+		if opts.Stats {
+			s.Stats = append(s.Stats, serviceStats(se, dests))
+		}
+	}
+
+	s.sort()
+	return s, nil
+}
+
+// TakeStats returns the statistics of the Services of c, ordered by
+// their keys.
+func TakeStats(c ipvs.Client) ([]ServiceStats, error) {
+	services, err := c.Services()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	stats := make([]ServiceStats, 0, len(services))
+	for _, se := range services {
+		dests, err := c.Destinations(se.Service)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("snapshot: service %s: %w", se.Key(), err)
+		}
+
+		stats = append(stats, serviceStats(se, dests))
+	}
+
+	sortStats(stats)
+	return stats, nil
+}
+
+// serviceStats returns the statistics of se and its Destinations.
+func serviceStats(se ipvs.ServiceExtended, dests []ipvs.DestinationExtended) ServiceStats {
+	ss := ServiceStats{Service: se.Key(), Destinations: []DestinationStats{}}
+	ss.Stats, ss.Truncated = se.Counters()
+
+	for _, de := range dests {
+		ds := DestinationStats{
+			Destination:           de.Key(),
+			ActiveConnections:     de.ActiveConnections,
+			InactiveConnections:   de.InactiveConnections,
+			PersistentConnections: de.PersistentConnections,
+		}
+		ds.Stats, ds.Truncated = de.Counters()
+		ss.Destinations = append(ss.Destinations, ds)
+	}
+
+	return ss
+}
+
+// sortStats orders stats, and their Destinations, by their keys.
+func sortStats(stats []ServiceStats) {
+	slices.SortFunc(stats, func(a, b ServiceStats) int {
+		return a.Service.Compare(b.Service)
+	})
+	for _, ss := range stats {
+		slices.SortFunc(ss.Destinations, func(a, b DestinationStats) int {
+			return a.Destination.Compare(b.Destination)
+		})
+	}
+}
+
+// sort orders the Daemons of s by state, and its Services, Destinations
+// and Stats by their keys.
+func (s *Snapshot) sort() {
+	slices.SortFunc(s.Daemons, func(a, b ipvs.Daemon) int {
+		return cmp.Compare(a.State, b.State)
+	})
+	slices.SortFunc(s.Services, func(a, b Service) int {
+		return a.Key().Compare(b.Key())
+	})
+	for _, svc := range s.Services {
+		slices.SortFunc(svc.Destinations, func(a, b ipvs.Destination) int {
+			return a.Key().Compare(b.Key())
+		})
+	}
+	sortStats(s.Stats)
+}
+
+// Service returns the Service identified by key, if present.
+func (s *Snapshot) Service(key ipvs.ServiceKey) (Service, bool) {
+	i := slices.IndexFunc(s.Services, func(svc Service) bool {
+		return svc.Key() == key
+	})
+	if i < 0 {
+		return Service{}, false
+	}
+
+	return s.Services[i], true
+}
+
+// Digest returns a hash of the configuration in s, which is equal for
+// snapshots of equal configurations, such as "3f5a...". The metadata and
+// statistics are left out.
+func (s *Snapshot) Digest() string {
+	c := *s
+	c.Metadata = Metadata{}
+	c.Stats = nil
+	// No daemons digest the same whether or not the Client manages them.
+	c.Daemons = slices.Clone(s.Daemons)
+	if len(c.Daemons) == 0 {
+		c.Daemons = nil
+	}
+	c.Services = slices.Clone(s.Services)
+	for i := range c.Services {
+		c.Services[i].Destinations = slices.Clone(c.Services[i].Destinations)
+	}
+	c.sort()
+
+	b, _ := json.Marshal(c)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Op is an operation in a Change.
+type Op string
+
+// Operations.
+const (
+	Create Op = "create"
+	Update Op = "update"
+	Remove Op = "remove"
+)
+
+// Change is a change made, or planned, by RestoreWith. Exactly one of
+// Config, Daemon, Service is set; Destination is set for changes to a
+// Destination of Service.
+type Change struct {
+	Op          Op                  `json:"op"`
+	Config      bool                `json:"config,omitempty"`
+	Daemon      ipvs.DaemonState    `json:"daemon,omitzero"`
+	Service     ipvs.ServiceKey     `json:"service,omitzero"`
+	Destination ipvs.DestinationKey `json:"destination,omitzero"`
+}
+
+// String describes the change, such as "update destination
+// tcp:192.0.2.1:80 198.51.100.1:8080".
+func (ch Change) String() string {
+	switch {
+	case ch.Config:
+		return string(ch.Op) + " config"
+	case ch.Daemon != 0:
+		return string(ch.Op) + " daemon " + ch.Daemon.String()
+	case ch.Destination.IsValid():
+		return string(ch.Op) + " destination " + ch.Service.String() + " " + ch.Destination.String()
+	}
+
+	return string(ch.Op) + " service " + ch.Service.String()
+}
+
+// RestoreOptions change how a snapshot is restored.
+type RestoreOptions struct {
+	// DryRun plans the changes without making them.
+	DryRun bool
+
+	// Filter, if set, selects the Services to restore. Services it does
+	// not select are left unchanged, whether or not they are in the
+	// snapshot.
+	Filter func(ipvs.ServiceKey) bool
+
+	// ServicesOnly leaves the config and daemons unchanged.
+	ServicesOnly bool
+}
+
+// Restore changes the configuration of c to match s: Services and
+// Destinations missing from c are created, those which differ are updated,
+// and those not in s are removed.
+func Restore(c ipvs.Client, s *Snapshot) error {
+	_, err := RestoreWith(c, s, RestoreOptions{})
+	return err
+}
+
+// RestoreWith changes the configuration of c to match s, like Restore, as
+// changed by opts. It returns the changes made, or planned if opts.DryRun
+// is set, in order. If a change fails, the changes made before it are
+// returned with the error.
+func RestoreWith(c ipvs.Client, s *Snapshot, opts RestoreOptions) ([]Change, error) {
+	current, err := Take(c)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &restorer{c: c, dryRun: opts.DryRun}
+	selected := func(key ipvs.ServiceKey) bool {
+		return opts.Filter == nil || opts.Filter(key)
+	}
+
+	if !opts.ServicesOnly {
+		if s.Config != nil && *s.Config != *current.Config {
+			err := r.do(Change{Op: Update, Config: true}, func() error {
+				return c.SetConfig(*s.Config)
+			})
+			if err != nil {
+				return r.changes, fmt.Errorf("snapshot: config: %w", err)
+			}
+		}
+
+		if s.Daemons != nil {
+			if err := r.daemons(s.Daemons, current.Daemons); err != nil {
+				return r.changes, fmt.Errorf("snapshot: daemons: %w", err)
+			}
+		}
+	}
+
+	for _, svc := range current.Services {
+		if _, ok := s.Service(svc.Key()); !ok && selected(svc.Key()) {
+			err := r.do(Change{Op: Remove, Service: svc.Key()}, func() error {
+				return c.RemoveService(svc.Service)
+			})
+			if err != nil {
+				return r.changes, fmt.Errorf("snapshot: service %s: %w", svc.Key(), err)
+			}
+		}
+	}
+
+	for _, svc := range s.Services {
+		if !selected(svc.Key()) {
+			continue
+		}
+
+		have, ok := current.Service(svc.Key())
+		if err := r.service(svc, have, ok); err != nil {
+			return r.changes, fmt.Errorf("snapshot: service %s: %w", svc.Key(), err)
+		}
+	}
+
+	return r.changes, nil
+}
+
+// restorer makes, and records, the changes of RestoreWith.
+type restorer struct {
+	c       ipvs.Client
+	dryRun  bool
+	changes []Change
+}
+
+// do records ch, and makes it with fn unless planning a dry run.
+func (r *restorer) do(ch Change, fn func() error) error {
+	if !r.dryRun {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+
+	r.changes = append(r.changes, ch)
+	return nil
+}
+
+// daemons changes the running daemons from have to want. Daemons which
+// differ are restarted.
+func (r *restorer) daemons(want, have []ipvs.Daemon) error {
+	wanted := func(d ipvs.Daemon) bool {
+		return slices.ContainsFunc(want, func(w ipvs.Daemon) bool { return sameDaemon(w, d) })
+	}
+	running := func(d ipvs.Daemon) bool {
+		return slices.ContainsFunc(have, func(h ipvs.Daemon) bool { return sameDaemon(d, h) })
+	}
+
+	// have is nil if the Client does not support daemons.
+	if have == nil {
+		if len(want) == 0 {
+			return nil
+		}
+		return errors.ErrUnsupported
+	}
+	dc := r.c.(ipvs.DaemonClient)
+
+	for _, d := range have {
+		if !wanted(d) {
+			if err := r.do(Change{Op: Remove, Daemon: d.State}, func() error { return dc.RemoveDaemon(d) }); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, d := range want {
+		if !running(d) {
+			if err := r.do(Change{Op: Create, Daemon: d.State}, func() error { return dc.CreateDaemon(d) }); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sameDaemon reports whether the running daemon have is configured as
+// want. The kernel reports its defaults for the fields want leaves unset,
+// including a MaxLen which depends on the MTU of the interface.
+func sameDaemon(want, have ipvs.Daemon) bool {
+	want, have = want.WithDefaults(), have.WithDefaults()
+	if want.MaxLen == 0 {
+		have.MaxLen = 0
+	}
+
+	return want == have
+}
+
+// service changes the Service svc, and its Destinations, from have, or
+// creates it if it does not exist.
+func (r *restorer) service(svc, have Service, exists bool) error {
+	c := r.c
+	key := svc.Key()
+
+	var err error
+	switch {
+	case !exists:
+		err = r.do(Change{Op: Create, Service: key}, func() error { return c.CreateService(svc.Service) })
+	case !sameService(svc.Service, have.Service):
+		err = r.do(Change{Op: Update, Service: key}, func() error { return c.UpdateService(svc.Service) })
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, d := range have.Destinations {
+		if !slices.ContainsFunc(svc.Destinations, func(dest ipvs.Destination) bool { return dest.Key() == d.Key() }) {
+			err := r.do(Change{Op: Remove, Service: key, Destination: d.Key()}, func() error {
+				return c.RemoveDestination(svc.Service, d)
+			})
+			if err != nil {
+				return fmt.Errorf("destination %s: %w", d.Key(), err)
+			}
+		}
+	}
+
+	for _, dest := range svc.Destinations {
+		i := slices.IndexFunc(have.Destinations, func(d ipvs.Destination) bool { return d.Key() == dest.Key() })
+
+		var err error
+		switch {
+		case i < 0:
+			err = r.do(Change{Op: Create, Service: key, Destination: dest.Key()}, func() error {
+				return c.CreateDestination(svc.Service, dest)
+			})
+		case !sameDestination(dest, have.Destinations[i]):
+			err = r.do(Change{Op: Update, Service: key, Destination: dest.Key()}, func() error {
+				return c.UpdateDestination(svc.Service, dest)
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("destination %s: %w", dest.Key(), err)
+		}
+	}
+
+	return nil
+}
+
+// sameService reports whether the Service want of a spec is configured as
+// have. The kernel reports the Family, and a default Netmask, for Services
+// created without them, and marks configured Services as hashed.
+func sameService(want, have ipvs.Service) bool {
+	want, _ = want.ResolveFamily()
+	have, _ = have.ResolveFamily()
+	want, have = want.WithDefaults(), have.WithDefaults()
+	want.Flags &^= ipvs.ServiceHashed
+	have.Flags &^= ipvs.ServiceHashed
+
+	return want == have
+}
+
+// sameDestination reports whether the Destination want of a spec is
+// configured as have, whose Family is reported by the kernel.
+func sameDestination(want, have ipvs.Destination) bool {
+	want, _ = want.ResolveFamily()
+	have, _ = have.ResolveFamily()
+
+	return want == have
+}
+
+// Encode writes s to w as an indented JSON document.
+func Encode(w io.Writer, s *Snapshot) error {
+	c := *s
+	c.Version = cmp.Or(c.Version, Version)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// EncodeYAML writes s to w as a YAML document.
+func EncodeYAML(w io.Writer, s *Snapshot) error {
+	var buf bytes.Buffer
+	if err := Encode(&buf, s); err != nil {
+		return err
+	}
+
+	b, err := yaml.JSONToYAML(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// migrations change a document of the version they are indexed by into
+// one of the next version.
+var migrations = map[int]func(doc map[string]json.RawMessage) error{
+	// Version 2 only added fields, which version 1 documents leave unset.
+	1: func(map[string]json.RawMessage) error { return nil },
+}
+
+// Decode reads a document written by Encode or EncodeYAML, migrating
+// documents of older versions to the current one.
+func Decode(r io.Reader) (*Snapshot, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+
+	// JSON is decoded directly, rather than as YAML, so that counters
+	// keep their precision.
+	if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		if b, err = yaml.YAMLToJSON(b); err != nil {
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+
+	var version int
+	if v, ok := doc["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return nil, fmt.Errorf("snapshot: version: %w", err)
+		}
+	}
+
+	switch {
+	case version == 0:
+		return nil, errors.New("snapshot: missing version")
+	case version > Version:
+		return nil, fmt.Errorf("snapshot: version %d is newer than %d", version, Version)
+	}
+
+	for ; version < Version; version++ {
+		if err := migrations[version](doc); err != nil {
+			return nil, fmt.Errorf("snapshot: migrating version %d: %w", version, err)
+		}
+	}
+	doc["version"], _ = json.Marshal(version)
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+
+	var s Snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+
+	// Services are looked up by key, which needs their address family.
+	for i, svc := range s.Services {
+		if _, err := ipvs.KeyOf(svc.Service); err != nil {
+			return nil, fmt.Errorf("snapshot: service %d: %w", i, err)
+		}
+	}
+
+	return &s, nil
+}
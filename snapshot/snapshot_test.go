@@ -0,0 +1,368 @@
+package snapshot
+
+import (
+	"bytes"
+	"io/fs"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/ipvs"
+	"github.com/cloudflare/ipvs/ipvstest"
+	"github.com/cloudflare/ipvs/netmask"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+)
+
+var (
+	web = ipvs.Service{
+		Address:   netip.MustParseAddr("192.0.2.1"),
+		Port:      80,
+		Family:    ipvs.INET,
+		Protocol:  ipvs.TCP,
+		Scheduler: ipvs.WeightedRoundRobin,
+	}
+	dns = ipvs.Service{
+		Address:   netip.MustParseAddr("2001:db8::53"),
+		Port:      53,
+		Family:    ipvs.INET6,
+		Protocol:  ipvs.UDP,
+		Scheduler: ipvs.RoundRobin,
+	}
+	backend1 = ipvs.Destination{
+		Address: netip.MustParseAddr("198.51.100.1"),
+		Port:    8080,
+		Family:  ipvs.INET,
+		Weight:  10,
+	}
+	backend2 = ipvs.Destination{
+		Address: netip.MustParseAddr("198.51.100.2"),
+		Port:    8080,
+		Family:  ipvs.INET,
+		Weight:  10,
+	}
+)
+
+var cmpAddr = cmp.Comparer(func(x, y netip.Addr) bool { return x == y })
+
+func TestTake(t *testing.T) {
+	c := ipvstest.New()
+
+	s, err := Take(c)
+	assert.NilError(t, err)
+	assert.Equal(t, len(s.Services), 0)
+	assert.Equal(t, s.Version, Version)
+
+	assert.NilError(t, c.CreateService(dns))
+	assert.NilError(t, c.CreateService(web))
+	assert.NilError(t, c.CreateDestination(web, backend2))
+	assert.NilError(t, c.CreateDestination(web, backend1))
+	assert.NilError(t, c.ModifyDestination(web, backend1, func(d *ipvs.DestinationExtended) {
+		d.ActiveConnections = 10
+	}))
+
+	s, err = Take(c)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, s.Services, []Service{
+		{Service: web, Destinations: []ipvs.Destination{backend1, backend2}},
+		{Service: dns},
+	}, cmpAddr)
+
+	before := s.Digest()
+	assert.NilError(t, c.ModifyDestination(web, backend1, func(d *ipvs.DestinationExtended) {
+		d.ActiveConnections = 20
+	}))
+	s, err = Take(c)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Digest(), before)
+
+	drained := backend1
+	drained.Weight = 0
+	assert.NilError(t, c.UpdateDestination(web, drained))
+	s, err = Take(c)
+	assert.NilError(t, err)
+	assert.Assert(t, s.Digest() != before)
+}
+
+func TestRestore(t *testing.T) {
+	c := ipvstest.New()
+	assert.NilError(t, c.CreateService(web))
+	assert.NilError(t, c.CreateDestination(web, backend1))
+	assert.NilError(t, c.CreateDestination(web, backend2))
+
+	stale := ipvs.Service{FWMark: 1, Family: ipvs.INET, Scheduler: ipvs.RoundRobin}
+	assert.NilError(t, c.CreateService(stale))
+
+	updated := backend2
+	updated.Weight = 50
+	webUpdated := web
+	webUpdated.Scheduler = ipvs.LeastConnection
+
+	want := &Snapshot{
+		Version: Version,
+		Config:  &ipvs.Config{TCPTimeout: 900, TCPFinTimeout: 120, UDPTimeout: 300},
+		Services: []Service{
+			{Service: webUpdated, Destinations: []ipvs.Destination{updated}},
+			{Service: dns, Destinations: []ipvs.Destination{backend1}},
+		},
+	}
+	assert.NilError(t, Restore(c, want))
+
+	got, err := Take(c)
+	assert.NilError(t, err)
+	assert.Equal(t, got.Digest(), want.Digest())
+
+	_, err = c.Service(stale)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestEncode(t *testing.T) {
+	s := &Snapshot{
+		Config:   &ipvs.Config{TCPTimeout: 900},
+		Services: []Service{{Service: web, Destinations: []ipvs.Destination{backend1}}},
+	}
+
+	var b bytes.Buffer
+	assert.NilError(t, Encode(&b, s))
+	assert.Assert(t, strings.Contains(b.String(), `"scheduler": "wrr"`))
+
+	decoded, err := Decode(&b)
+	assert.NilError(t, err)
+	assert.Equal(t, decoded.Version, Version)
+	assert.Equal(t, decoded.Digest(), (&Snapshot{Version: Version, Config: s.Config, Services: s.Services}).Digest())
+}
+
+func TestEncodeYAML(t *testing.T) {
+	s := &Snapshot{
+		Config:   &ipvs.Config{TCPTimeout: 900},
+		Daemons:  []ipvs.Daemon{{State: ipvs.DaemonMaster, Interface: "eth0"}},
+		Services: []Service{{Service: web, Destinations: []ipvs.Destination{backend1}}},
+	}
+
+	var b bytes.Buffer
+	assert.NilError(t, EncodeYAML(&b, s))
+	assert.Assert(t, strings.Contains(b.String(), "scheduler: wrr\n"), b.String())
+	assert.Assert(t, strings.Contains(b.String(), "version: 2\n"), b.String())
+
+	decoded, err := Decode(&b)
+	assert.NilError(t, err)
+	assert.Equal(t, decoded.Version, Version)
+	assert.Equal(t, decoded.Digest(), (&Snapshot{Version: Version, Config: s.Config, Daemons: s.Daemons, Services: s.Services}).Digest())
+
+	// Documents written by hand are migrated like JSON ones.
+	decoded, err = Decode(strings.NewReader(`
+version: 1
+services:
+- address: 192.0.2.1
+  port: 80
+  protocol: TCP
+  scheduler: wrr
+`))
+	assert.NilError(t, err)
+	assert.Equal(t, decoded.Version, Version)
+	assert.Equal(t, decoded.Services[0].Key().String(), "tcp:192.0.2.1:80")
+}
+
+func TestDecode_Version(t *testing.T) {
+	_, err := Decode(strings.NewReader(`{"services": []}`))
+	assert.ErrorContains(t, err, "missing version")
+
+	_, err = Decode(strings.NewReader(`{"version": 99, "services": []}`))
+	assert.ErrorContains(t, err, "version 99 is newer")
+}
+
+func TestDecode_Family(t *testing.T) {
+	_, err := Decode(strings.NewReader(`{"version": ` + strconv.Itoa(Version) + `, "services": [{"fwmark": 42}]}`))
+	assert.ErrorContains(t, err, "snapshot: service 0: ipvs: Family: is required for firewall-mark services")
+}
+
+func TestTakeWith(t *testing.T) {
+	c := ipvstest.New()
+	assert.NilError(t, c.CreateService(web))
+	assert.NilError(t, c.CreateDestination(web, backend1))
+	assert.NilError(t, c.ModifyDestination(web, backend1, func(d *ipvs.DestinationExtended) {
+		d.ActiveConnections = 10
+	}))
+	master := ipvs.Daemon{State: ipvs.DaemonMaster, Interface: "eth0", SyncID: 7}
+	assert.NilError(t, c.CreateDaemon(master))
+
+	s, err := TakeWith(c, Options{Host: "lb1", Stats: true})
+	assert.NilError(t, err)
+	assert.Equal(t, s.Metadata.Host, "lb1")
+	assert.Equal(t, s.Metadata.Info.Version, [3]int{1, 2, 1})
+	assert.Assert(t, !s.Metadata.Taken.IsZero())
+	assert.DeepEqual(t, s.Daemons, []ipvs.Daemon{master}, cmpAddr)
+	assert.Equal(t, len(s.Stats), 1)
+	assert.Equal(t, s.Stats[0].Destinations[0].ActiveConnections, uint32(10))
+
+	plain, err := Take(c)
+	assert.NilError(t, err)
+	assert.Equal(t, len(plain.Stats), 0)
+	assert.Equal(t, plain.Digest(), s.Digest())
+
+	// Daemons are left out if the Client cannot manage them.
+	s, err = Take(ipvs.Validating(struct{ ipvs.Client }{c}))
+	assert.NilError(t, err)
+	assert.Assert(t, s.Daemons == nil)
+}
+
+func TestRestoreWith(t *testing.T) {
+	c := ipvstest.New()
+	assert.NilError(t, c.CreateService(web))
+	assert.NilError(t, c.CreateDestination(web, backend1))
+	assert.NilError(t, c.CreateService(dns))
+	assert.NilError(t, c.CreateDaemon(ipvs.Daemon{State: ipvs.DaemonBackup, Interface: "eth0"}))
+
+	drained := backend1
+	drained.Weight = 0
+	want := &Snapshot{
+		Version: Version,
+		Daemons: []ipvs.Daemon{{State: ipvs.DaemonMaster, Interface: "eth1"}},
+		Services: []Service{
+			{Service: web, Destinations: []ipvs.Destination{drained, backend2}},
+		},
+	}
+	before, err := Take(c)
+	assert.NilError(t, err)
+
+	changes, err := RestoreWith(c, want, RestoreOptions{DryRun: true})
+	assert.NilError(t, err)
+	var got []string
+	for _, ch := range changes {
+		got = append(got, ch.String())
+	}
+	assert.DeepEqual(t, got, []string{
+		"remove daemon backup",
+		"create daemon master",
+		"remove service udp:[2001:db8::53]:53",
+		"update destination tcp:192.0.2.1:80 198.51.100.1:8080",
+		"create destination tcp:192.0.2.1:80 198.51.100.2:8080",
+	})
+
+	after, err := Take(c)
+	assert.NilError(t, err)
+	assert.Equal(t, after.Digest(), before.Digest())
+
+	// The filter leaves the unselected dns service in place.
+	changes, err = RestoreWith(c, want, RestoreOptions{
+		Filter:       func(key ipvs.ServiceKey) bool { return key == web.Key() },
+		ServicesOnly: true,
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, len(changes), 2)
+
+	_, err = c.Service(dns)
+	assert.NilError(t, err)
+	daemons, err := c.Daemons()
+	assert.NilError(t, err)
+	assert.Equal(t, daemons[0].State, ipvs.DaemonBackup)
+	dests, err := c.Destinations(web)
+	assert.NilError(t, err)
+	assert.Equal(t, len(dests), 2)
+}
+
+func TestRestoreWith_Defaults(t *testing.T) {
+	c := ipvstest.New()
+
+	// The kernel reports its defaults for the fields a spec leaves unset.
+	master := ipvs.Daemon{State: ipvs.DaemonMaster, Interface: "eth0", SyncID: 1}
+	running := master.WithDefaults()
+	running.MaxLen = 1472
+	assert.NilError(t, c.CreateDaemon(running))
+	reported := web
+	reported.Netmask = netmask.MaskFrom(32, 32)
+	assert.NilError(t, c.CreateService(reported))
+
+	want := &Snapshot{
+		Version:  Version,
+		Daemons:  []ipvs.Daemon{master},
+		Services: []Service{{Service: web}},
+	}
+	changes, err := RestoreWith(c, want, RestoreOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(changes), 0)
+
+	// Fields set by the spec are still restored.
+	master.MaxLen = 1400
+	want.Daemons = []ipvs.Daemon{master}
+	want.Services[0].Netmask = netmask.MaskFrom(24, 32)
+	changes, err = RestoreWith(c, want, RestoreOptions{DryRun: true})
+	assert.NilError(t, err)
+	var got []string
+	for _, ch := range changes {
+		got = append(got, ch.String())
+	}
+	assert.DeepEqual(t, got, []string{
+		"remove daemon master",
+		"create daemon master",
+		"update service tcp:192.0.2.1:80",
+	})
+}
+
+func TestRestoreWith_Family(t *testing.T) {
+	c := ipvstest.New()
+
+	// A spec leaving the families unset is restored over its own snapshot
+	// without changes, though the kernel reports them.
+	want := &Snapshot{
+		Version: Version,
+		Services: []Service{{
+			Service: ipvs.Service{
+				Address:   netip.MustParseAddr("192.0.2.1"),
+				Port:      80,
+				Protocol:  ipvs.TCP,
+				Scheduler: ipvs.RoundRobin,
+			},
+			Destinations: []ipvs.Destination{{
+				Address:   netip.MustParseAddr("198.51.100.1"),
+				Port:      8080,
+				FwdMethod: ipvs.DirectRoute,
+				Weight:    1,
+			}},
+		}},
+	}
+	changes, err := RestoreWith(c, want, RestoreOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(changes), 2)
+
+	reported := ipvs.ServiceExtended{Service: want.Services[0].Service}
+	reported.Family = ipvs.INET
+	reported.Flags = ipvs.ServiceHashed
+	have, err := Take(&hashedClient{Client: c, reported: reported})
+	assert.NilError(t, err)
+	assert.Equal(t, have.Services[0].Family, ipvs.INET)
+	assert.Equal(t, have.Services[0].Destinations[0].Family, ipvs.INET)
+
+	changes, err = RestoreWith(&hashedClient{Client: c, reported: reported}, want, RestoreOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(changes), 0)
+}
+
+// hashedClient reports its only Service as the kernel does, with its
+// Family and ServiceHashed set.
+type hashedClient struct {
+	ipvs.Client
+	reported ipvs.ServiceExtended
+}
+
+func (c *hashedClient) Services() ([]ipvs.ServiceExtended, error) {
+	return []ipvs.ServiceExtended{c.reported}, nil
+}
+
+func TestDecode_Migrate(t *testing.T) {
+	s, err := Decode(strings.NewReader(`{
+		"version": 1,
+		"config": {"tcpTimeout": 900},
+		"services": [{"address": "192.0.2.1", "port": 80, "protocol": "TCP", "scheduler": "wrr"}]
+	}`))
+	assert.NilError(t, err)
+	assert.Equal(t, s.Version, Version)
+	assert.Assert(t, s.Daemons == nil)
+	assert.Equal(t, s.Config.TCPTimeout, uint32(900))
+	assert.Equal(t, s.Services[0].Key().String(), "tcp:192.0.2.1:80")
+
+	var b bytes.Buffer
+	assert.NilError(t, Encode(&b, s))
+	assert.Assert(t, strings.Contains(b.String(), `"version": 2`))
+}
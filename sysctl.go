@@ -0,0 +1,237 @@
+package ipvs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultSysctlRoot is the directory containing the IPVS sysctls.
+const DefaultSysctlRoot = "/proc/sys/net/ipv4/vs"
+
+// Sysctls represents the IPVS tunables which are only available as sysctls,
+// rather than over netlink. See the kernel's ipvs-sysctl documentation for
+// the meaning of each value.
+//
+// Each field is optional: GetSysctls leaves the sysctls which are not
+// available on the running kernel nil, and SetSysctls only writes the
+// fields which are set.
+//
+// Like the netlink Client, the values under /proc/sys/net belong to the
+// network namespace of the calling thread.
+type Sysctls struct {
+	AmDropRate              *int    `json:"amDropRate,omitempty"`
+	AmemThresh              *int    `json:"amemThresh,omitempty"`
+	BackupOnly              *bool   `json:"backupOnly,omitempty"`
+	CacheBypass             *bool   `json:"cacheBypass,omitempty"`
+	ConnReuseMode           *int    `json:"connReuseMode,omitempty"`
+	Conntrack               *bool   `json:"conntrack,omitempty"`
+	DropEntry               *int    `json:"dropEntry,omitempty"`
+	DropPacket              *int    `json:"dropPacket,omitempty"`
+	EstCPUList              *string `json:"estCpuList,omitempty"`
+	EstNice                 *int    `json:"estNice,omitempty"`
+	ExpireNodestConn        *bool   `json:"expireNodestConn,omitempty"`
+	ExpireQuiescentTemplate *bool   `json:"expireQuiescentTemplate,omitempty"`
+	IgnoreTunneled          *bool   `json:"ignoreTunneled,omitempty"`
+	NatICMPSend             *bool   `json:"natIcmpSend,omitempty"`
+	PMTUDisc                *bool   `json:"pmtuDisc,omitempty"`
+	RunEstimation           *bool   `json:"runEstimation,omitempty"`
+	ScheduleICMP            *bool   `json:"scheduleIcmp,omitempty"`
+	SecureTCP               *int    `json:"secureTcp,omitempty"`
+	SloppySCTP              *bool   `json:"sloppySctp,omitempty"`
+	SloppyTCP               *bool   `json:"sloppyTcp,omitempty"`
+	SNATReroute             *bool   `json:"snatReroute,omitempty"`
+	SyncPersistMode         *int    `json:"syncPersistMode,omitempty"`
+	SyncPorts               *int    `json:"syncPorts,omitempty"`
+	SyncQlenMax             *int    `json:"syncQlenMax,omitempty"`
+	SyncRefreshPeriod       *int    `json:"syncRefreshPeriod,omitempty"`
+	SyncRetries             *int    `json:"syncRetries,omitempty"`
+	SyncSockSize            *int    `json:"syncSockSize,omitempty"`
+	SyncThreshold           *[2]int `json:"syncThreshold,omitempty"`
+	SyncVersion             *int    `json:"syncVersion,omitempty"`
+}
+
+// SysctlClient is implemented by Clients which can manage the IPVS
+// sysctls. Use a type assertion to check for support.
+type SysctlClient interface {
+	Sysctls() (Sysctls, error)
+	// SetSysctls changes the sysctls which are set in the given Sysctls.
+	SetSysctls(Sysctls) error
+}
+
+// sysctls maps the name of each sysctl to its field.
+var sysctls = []struct {
+	name  string
+	field func(*Sysctls) any
+}{
+	{"am_droprate", func(s *Sysctls) any { return &s.AmDropRate }},
+	{"amemthresh", func(s *Sysctls) any { return &s.AmemThresh }},
+	{"backup_only", func(s *Sysctls) any { return &s.BackupOnly }},
+	{"cache_bypass", func(s *Sysctls) any { return &s.CacheBypass }},
+	{"conn_reuse_mode", func(s *Sysctls) any { return &s.ConnReuseMode }},
+	{"conntrack", func(s *Sysctls) any { return &s.Conntrack }},
+	{"drop_entry", func(s *Sysctls) any { return &s.DropEntry }},
+	{"drop_packet", func(s *Sysctls) any { return &s.DropPacket }},
+	{"est_cpulist", func(s *Sysctls) any { return &s.EstCPUList }},
+	{"est_nice", func(s *Sysctls) any { return &s.EstNice }},
+	{"expire_nodest_conn", func(s *Sysctls) any { return &s.ExpireNodestConn }},
+	{"expire_quiescent_template", func(s *Sysctls) any { return &s.ExpireQuiescentTemplate }},
+	{"ignore_tunneled", func(s *Sysctls) any { return &s.IgnoreTunneled }},
+	{"nat_icmp_send", func(s *Sysctls) any { return &s.NatICMPSend }},
+	{"pmtu_disc", func(s *Sysctls) any { return &s.PMTUDisc }},
+	{"run_estimation", func(s *Sysctls) any { return &s.RunEstimation }},
+	{"schedule_icmp", func(s *Sysctls) any { return &s.ScheduleICMP }},
+	{"secure_tcp", func(s *Sysctls) any { return &s.SecureTCP }},
+	{"sloppy_sctp", func(s *Sysctls) any { return &s.SloppySCTP }},
+	{"sloppy_tcp", func(s *Sysctls) any { return &s.SloppyTCP }},
+	{"snat_reroute", func(s *Sysctls) any { return &s.SNATReroute }},
+	{"sync_persist_mode", func(s *Sysctls) any { return &s.SyncPersistMode }},
+	{"sync_ports", func(s *Sysctls) any { return &s.SyncPorts }},
+	{"sync_qlen_max", func(s *Sysctls) any { return &s.SyncQlenMax }},
+	{"sync_refresh_period", func(s *Sysctls) any { return &s.SyncRefreshPeriod }},
+	{"sync_retries", func(s *Sysctls) any { return &s.SyncRetries }},
+	{"sync_sock_size", func(s *Sysctls) any { return &s.SyncSockSize }},
+	{"sync_threshold", func(s *Sysctls) any { return &s.SyncThreshold }},
+	{"sync_version", func(s *Sysctls) any { return &s.SyncVersion }},
+}
+
+// GetSysctls reads the IPVS sysctls from the directory root, which defaults
+// to DefaultSysctlRoot if empty. Sysctls which are not available on
+// the running kernel are left nil.
+func GetSysctls(root string) (Sysctls, error) {
+	if root == "" {
+		root = DefaultSysctlRoot
+	}
+
+	var s Sysctls
+	for _, sysctl := range sysctls {
+		b, err := os.ReadFile(filepath.Join(root, sysctl.name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return Sysctls{}, err
+		}
+
+		if err := parseSysctl(strings.TrimSpace(string(b)), sysctl.field(&s)); err != nil {
+			return Sysctls{}, fmt.Errorf("ipvs: sysctl %s: %w", sysctl.name, err)
+		}
+	}
+
+	return s, nil
+}
+
+// SetSysctls writes the IPVS sysctls which are set in s to the directory
+// root, which defaults to DefaultSysctlRoot if empty. Only sysctls whose
+// value differs from the current value are written.
+func SetSysctls(root string, s Sysctls) error {
+	if root == "" {
+		root = DefaultSysctlRoot
+	}
+
+	current, err := GetSysctls(root)
+	if err != nil {
+		return err
+	}
+
+	for _, sysctl := range sysctls {
+		value, ok := formatSysctl(sysctl.field(&s))
+		if !ok {
+			continue
+		}
+		if have, ok := formatSysctl(sysctl.field(&current)); ok && have == value {
+			continue
+		}
+
+		// Files are opened without O_CREATE, so sysctls which are not
+		// available on the running kernel are reported as fs.ErrNotExist.
+		f, err := os.OpenFile(filepath.Join(root, sysctl.name), os.O_WRONLY|os.O_TRUNC, 0)
+		if err != nil {
+			return fmt.Errorf("ipvs: sysctl %s: %w", sysctl.name, err)
+		}
+
+		_, err = f.WriteString(value + "\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("ipvs: sysctl %s: %w", sysctl.name, err)
+		}
+	}
+
+	return nil
+}
+
+// parseSysctl parses the contents of a sysctl into the field pointed to by
+// v, allocating its value.
+func parseSysctl(s string, v any) error {
+	switch v := v.(type) {
+	case **bool:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		b := n != 0
+		*v = &b
+	case **int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*v = &n
+	case **string:
+		*v = &s
+	case **[2]int:
+		fields := strings.Fields(s)
+		var values [2]int
+		if len(fields) != len(values) {
+			return fmt.Errorf("expected %d values, got %q", len(values), s)
+		}
+		for i, f := range fields {
+			n, err := strconv.Atoi(f)
+			if err != nil {
+				return err
+			}
+			values[i] = n
+		}
+		*v = &values
+	}
+
+	return nil
+}
+
+// formatSysctl formats the field pointed to by v as the contents of a
+// sysctl, reporting whether it is set.
+func formatSysctl(v any) (string, bool) {
+	switch v := v.(type) {
+	case **bool:
+		if *v == nil {
+			return "", false
+		}
+		if **v {
+			return "1", true
+		}
+		return "0", true
+	case **int:
+		if *v == nil {
+			return "", false
+		}
+		return strconv.Itoa(**v), true
+	case **string:
+		if *v == nil {
+			return "", false
+		}
+		return **v, true
+	case **[2]int:
+		if *v == nil {
+			return "", false
+		}
+		return strconv.Itoa((*v)[0]) + " " + strconv.Itoa((*v)[1]), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,94 @@
+package ipvs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestSysctls(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"am_droprate":               "10\n",
+		"conntrack":                 "0\n",
+		"conn_reuse_mode":           "1\n",
+		"est_cpulist":               "0-3\n",
+		"expire_nodest_conn":        "1\n",
+		"expire_quiescent_template": "0\n",
+		"sync_threshold":            "3\t50\n",
+	}
+	for name, content := range files {
+		assert.NilError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0o644))
+	}
+
+	s, err := GetSysctls(root)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, s, Sysctls{
+		AmDropRate:              ptr(10),
+		Conntrack:               ptr(false),
+		ConnReuseMode:           ptr(1),
+		EstCPUList:              ptr("0-3"),
+		ExpireNodestConn:        ptr(true),
+		ExpireQuiescentTemplate: ptr(false),
+		SyncThreshold:           &[2]int{3, 50},
+	})
+
+	s.Conntrack = ptr(true)
+	s.ExpireNodestConn = ptr(false)
+	s.SyncThreshold = &[2]int{4, 100}
+	assert.NilError(t, SetSysctls(root, s))
+
+	for name, expected := range map[string]string{
+		"am_droprate":        "10\n",
+		"conntrack":          "1\n",
+		"expire_nodest_conn": "0\n",
+		"sync_threshold":     "4 100\n",
+	} {
+		b, err := os.ReadFile(filepath.Join(root, name))
+		assert.NilError(t, err)
+		assert.Equal(t, string(b), expected, name)
+	}
+
+	got, err := GetSysctls(root)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, s)
+
+	s.SloppyTCP = ptr(true)
+	assert.ErrorIs(t, SetSysctls(root, s), fs.ErrNotExist)
+	_, err = os.Stat(filepath.Join(root, "sloppy_tcp"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestSysctls_Invalid(t *testing.T) {
+	root := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "sync_threshold"), []byte("3\n"), 0o644))
+
+	_, err := GetSysctls(root)
+	assert.ErrorContains(t, err, "sync_threshold")
+}
+
+func TestSetSysctls_Partial(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"conntrack":      "1\n",
+		"sync_threshold": "3 50\n",
+	} {
+		assert.NilError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0o644))
+	}
+
+	assert.NilError(t, SetSysctls(root, Sysctls{SyncThreshold: &[2]int{4, 100}}))
+
+	s, err := GetSysctls(root)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, s, Sysctls{
+		Conntrack:     ptr(true),
+		SyncThreshold: &[2]int{4, 100},
+	})
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
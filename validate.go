@@ -0,0 +1,319 @@
+package ipvs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FieldError reports an invalid field of a Service or Destination.
+type FieldError struct {
+	// Field is the path to the field, such as "Netmask" or "TunnelPort".
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return "ipvs: " + e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrors returns every *FieldError in the tree of err, such as those
+// joined by Service.Validate.
+func FieldErrors(err error) []*FieldError {
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case *FieldError:
+		return []*FieldError{err}
+	case interface{ Unwrap() []error }:
+		var fields []*FieldError
+		for _, err := range err.Unwrap() {
+			fields = append(fields, FieldErrors(err)...)
+		}
+		return fields
+	}
+
+	return FieldErrors(errors.Unwrap(err))
+}
+
+// fieldErrors accumulates the FieldErrors of a configuration.
+type fieldErrors []error
+
+// add records an error for field, formatted according to format.
+func (errs *fieldErrors) add(field, format string, args ...any) {
+	*errs = append(*errs, &FieldError{Field: field, Err: fmt.Errorf(format, args...)})
+}
+
+// err returns the accumulated errors, or nil if there were none.
+func (errs fieldErrors) err() error {
+	return errors.Join(errs...)
+}
+
+// Validate checks the configuration of a Service before it is created
+// or updated, catching combinations the kernel would refuse with a bare
+// EINVAL, or accept without being able to forward traffic.
+//
+// Every invalid field is reported as a *FieldError, joined with errors.Join.
+func (svc Service) Validate() error {
+	var errs fieldErrors
+
+	switch svc.Family {
+	case 0, INET, INET6:
+		var err error
+		if svc, err = svc.ResolveFamily(); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		errs.add("Family", "unknown address family %s", svc.Family)
+	}
+
+	if svc.FWMark != 0 {
+		if svc.Address.IsValid() && !svc.Address.IsUnspecified() {
+			errs.add("Address", "must not be set for firewall-mark services")
+		}
+		if svc.Port != 0 {
+			errs.add("Port", "must be zero for firewall-mark services")
+		}
+	} else {
+		if !svc.Address.IsValid() {
+			errs.add("Address", "is required")
+		}
+
+		switch svc.Protocol {
+		case TCP, UDP, SCTP:
+		default:
+			errs.add("Protocol", "unsupported protocol %s", svc.Protocol)
+		}
+
+		if svc.Port == 0 && svc.Flags&ServicePersistent == 0 {
+			errs.add("Port", "zero port requires a persistent service")
+		}
+	}
+
+	switch {
+	case !svc.Netmask.IsValid(), svc.Family != INET && svc.Family != INET6:
+	case !familyMatches(svc.Family, svc.Netmask.Is4()):
+		errs.add("Netmask", "%s is not an %s netmask", svc.Netmask, svc.Family)
+	case svc.Netmask.Is6() && svc.Netmask.Bits() < 0:
+		errs.add("Netmask", "%s is not a prefix length", svc.Netmask)
+	}
+
+	if err := Scheduler(svc.Scheduler).Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs.err()
+}
+
+// Validate checks the configuration of a Destination of svc before it is
+// created or updated, catching combinations the kernel would refuse with
+// a bare EINVAL, or accept without being able to forward traffic.
+//
+// Every invalid field is reported as a *FieldError, joined with errors.Join.
+func (dest Destination) Validate(svc Service) error {
+	var errs fieldErrors
+
+	if !dest.Address.IsValid() {
+		errs.add("Address", "is required")
+	}
+
+	switch dest.Family {
+	case 0, INET, INET6:
+		var err error
+		if dest, err = dest.ResolveFamily(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		// The Service is validated on its own, so its errors are not reported.
+		svc, err = svc.ResolveFamily()
+		if err == nil && dest.Family != 0 && dest.Family != svc.Family && dest.FwdMethod != Tunnel {
+			errs.add("Family", "%s destinations of %s services require the Tunnel forwarding method, not %s", dest.Family, svc.Family, dest.FwdMethod)
+		}
+	default:
+		errs.add("Family", "unknown address family %s", dest.Family)
+	}
+
+	if dest.UpperThreshold != 0 && dest.LowerThreshold > dest.UpperThreshold {
+		errs.add("LowerThreshold", "%d is above the upper threshold %d", dest.LowerThreshold, dest.UpperThreshold)
+	}
+
+	switch dest.FwdMethod {
+	case Masquerade, Local, DirectRoute, Bypass:
+		if dest.TunnelType != IPIP {
+			errs.add("TunnelType", "is only used by the Tunnel forwarding method")
+		}
+		if dest.TunnelPort != 0 {
+			errs.add("TunnelPort", "is only used by the Tunnel forwarding method")
+		}
+		if dest.TunnelFlags != 0 {
+			errs.add("TunnelFlags", "are only used by the Tunnel forwarding method")
+		}
+	case Tunnel:
+		switch dest.TunnelType {
+		case IPIP:
+			if dest.TunnelPort != 0 {
+				errs.add("TunnelPort", "is not used by IPIP tunnels")
+			}
+			if dest.TunnelFlags != 0 {
+				errs.add("TunnelFlags", "are not used by IPIP tunnels")
+			}
+		case GUE:
+			if dest.TunnelPort == 0 {
+				errs.add("TunnelPort", "is required by GUE tunnels")
+			}
+		case GRE:
+			if dest.TunnelPort != 0 {
+				errs.add("TunnelPort", "is not used by GRE tunnels")
+			}
+		default:
+			errs.add("TunnelType", "unknown tunnel type %s", dest.TunnelType)
+		}
+	default:
+		errs.add("FwdMethod", "unknown forwarding method %s", dest.FwdMethod)
+	}
+
+	return errs.err()
+}
+
+// familyMatches reports whether an address, or netmask, belongs to family.
+func familyMatches(family AddressFamily, is4 bool) bool {
+	return is4 == (family == INET)
+}
+
+// Validating returns a Client which validates Services and Destinations
+// with their Validate methods before creating or updating them with c.
+// Removals are not validated, so that invalid entries can still be removed.
+func Validating(c Client) Client {
+	return &validatingClient{c}
+}
+
+// validatingClient implements Client by validating configurations
+// before passing them on.
+type validatingClient struct {
+	Client
+}
+
+func (c *validatingClient) CreateService(svc Service) error {
+	if err := svc.Validate(); err != nil {
+		return err
+	}
+
+	return c.Client.CreateService(svc)
+}
+
+func (c *validatingClient) UpdateService(svc Service) error {
+	if err := svc.Validate(); err != nil {
+		return err
+	}
+
+	return c.Client.UpdateService(svc)
+}
+
+func (c *validatingClient) CreateDestination(svc Service, dest Destination) error {
+	if err := dest.Validate(svc); err != nil {
+		return err
+	}
+
+	return c.Client.CreateDestination(svc, dest)
+}
+
+func (c *validatingClient) UpdateDestination(svc Service, dest Destination) error {
+	if err := dest.Validate(svc); err != nil {
+		return err
+	}
+
+	return c.Client.UpdateDestination(svc, dest)
+}
+
+// Close closes the underlying Client, if it implements io.Closer.
+func (c *validatingClient) Close() error {
+	if closer, ok := c.Client.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// Capabilities returns the capabilities of the underlying Client. If it
+// does not implement CapabilitiesClient, the error wraps
+// errors.ErrUnsupported.
+func (c *validatingClient) Capabilities() (Capabilities, error) {
+	cc, ok := c.Client.(CapabilitiesClient)
+	if !ok {
+		return Capabilities{}, fmt.Errorf("ipvs: capabilities: %w", errors.ErrUnsupported)
+	}
+
+	return cc.Capabilities()
+}
+
+// Daemons returns the daemons of the underlying Client. If it does not
+// implement DaemonClient, the error wraps errors.ErrUnsupported.
+func (c *validatingClient) Daemons() ([]Daemon, error) {
+	dc, err := c.daemonClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return dc.Daemons()
+}
+
+func (c *validatingClient) CreateDaemon(d Daemon) error {
+	dc, err := c.daemonClient()
+	if err != nil {
+		return err
+	}
+
+	return dc.CreateDaemon(d)
+}
+
+func (c *validatingClient) RemoveDaemon(d Daemon) error {
+	dc, err := c.daemonClient()
+	if err != nil {
+		return err
+	}
+
+	return dc.RemoveDaemon(d)
+}
+
+// Sysctls returns the sysctls of the underlying Client. If it does not
+// implement SysctlClient, the error wraps errors.ErrUnsupported.
+func (c *validatingClient) Sysctls() (Sysctls, error) {
+	sc, err := c.sysctlClient()
+	if err != nil {
+		return Sysctls{}, err
+	}
+
+	return sc.Sysctls()
+}
+
+func (c *validatingClient) SetSysctls(s Sysctls) error {
+	sc, err := c.sysctlClient()
+	if err != nil {
+		return err
+	}
+
+	return sc.SetSysctls(s)
+}
+
+func (c *validatingClient) sysctlClient() (SysctlClient, error) {
+	sc, ok := c.Client.(SysctlClient)
+	if !ok {
+		return nil, fmt.Errorf("ipvs: sysctls: %w", errors.ErrUnsupported)
+	}
+
+	return sc, nil
+}
+
+func (c *validatingClient) daemonClient() (DaemonClient, error) {
+	dc, ok := c.Client.(DaemonClient)
+	if !ok {
+		return nil, fmt.Errorf("ipvs: daemons: %w", errors.ErrUnsupported)
+	}
+
+	return dc, nil
+}
@@ -0,0 +1,234 @@
+package ipvs
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/ipvs/netmask"
+	"gotest.tools/v3/assert"
+)
+
+// fieldsOf returns the fields reported by the FieldErrors in err.
+func fieldsOf(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+
+	var fields []string
+	for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var fe *FieldError
+		assert.Assert(t, errors.As(err, &fe), "%v is not a FieldError", err)
+		fields = append(fields, fe.Field)
+	}
+
+	return fields
+}
+
+var validService = Service{
+	Address:   netip.MustParseAddr("192.0.2.1"),
+	Netmask:   netmask.MaskFrom(32, 32),
+	Scheduler: WeightedRoundRobin,
+	Port:      80,
+	Family:    INET,
+	Protocol:  TCP,
+}
+
+func TestServiceValidate(t *testing.T) {
+	type testCase struct {
+		name   string
+		modify func(*Service)
+		fields []string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		svc := validService
+		tc.modify(&svc)
+		assert.DeepEqual(t, fieldsOf(t, svc.Validate()), tc.fields)
+	}
+
+	testCases := []testCase{
+		{name: "valid", modify: func(*Service) {}},
+		{
+			name: "fwmark",
+			modify: func(svc *Service) {
+				*svc = Service{FWMark: 42, Scheduler: MaglevHashing, Family: INET6}
+			},
+		},
+		{
+			name:   "fwmark with port",
+			modify: func(svc *Service) { svc.FWMark = 42 },
+			fields: []string{"Address", "Port"},
+		},
+		{
+			name:   "family mismatch",
+			modify: func(svc *Service) { svc.Address = netip.MustParseAddr("2001:db8::1") },
+			fields: []string{"Family"},
+		},
+		{
+			name: "family inferred",
+			modify: func(svc *Service) {
+				svc.Address = netip.MustParseAddr("::ffff:192.0.2.1")
+				svc.Family = 0
+			},
+		},
+		{
+			name: "fwmark without family",
+			modify: func(svc *Service) {
+				*svc = Service{FWMark: 42, Scheduler: MaglevHashing}
+			},
+			fields: []string{"Family"},
+		},
+		{
+			name:   "netmask family",
+			modify: func(svc *Service) { svc.Netmask = netmask.MaskFrom(64, 128) },
+			fields: []string{"Netmask"},
+		},
+		{
+			name:   "zero port",
+			modify: func(svc *Service) { svc.Port = 0 },
+			fields: []string{"Port"},
+		},
+		{
+			name: "zero port persistent",
+			modify: func(svc *Service) {
+				svc.Port = 0
+				svc.Flags = ServicePersistent
+			},
+		},
+		{
+			name: "everything",
+			modify: func(svc *Service) {
+				svc.Family = 7
+				svc.Protocol = 0
+				svc.Scheduler = strings.Repeat("x", 16)
+			},
+			fields: []string{"Family", "Protocol", "Scheduler"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+func TestDestinationValidate(t *testing.T) {
+	type testCase struct {
+		name   string
+		dest   Destination
+		fields []string
+	}
+
+	run := func(t *testing.T, tc testCase) {
+		assert.DeepEqual(t, fieldsOf(t, tc.dest.Validate(validService)), tc.fields)
+	}
+
+	testCases := []testCase{
+		{
+			name: "valid",
+			dest: Destination{Address: netip.MustParseAddr("192.0.2.10"), Port: 80, Weight: 1},
+		},
+		{
+			name: "gue",
+			dest: Destination{
+				Address:     netip.MustParseAddr("2001:db8::10"),
+				Family:      INET6,
+				FwdMethod:   Tunnel,
+				TunnelType:  GUE,
+				TunnelPort:  6080,
+				TunnelFlags: TunnelEncapChecksum,
+			},
+		},
+		{
+			name: "gue without port",
+			dest: Destination{
+				Address:    netip.MustParseAddr("192.0.2.10"),
+				FwdMethod:  Tunnel,
+				TunnelType: GUE,
+			},
+			fields: []string{"TunnelPort"},
+		},
+		{
+			name: "tunnel fields with direct route",
+			dest: Destination{
+				Address:     netip.MustParseAddr("192.0.2.10"),
+				FwdMethod:   DirectRoute,
+				TunnelType:  GRE,
+				TunnelPort:  6080,
+				TunnelFlags: TunnelEncapChecksum,
+			},
+			fields: []string{"TunnelType", "TunnelPort", "TunnelFlags"},
+		},
+		{
+			name: "masquerade with different family",
+			dest: Destination{
+				Address:   netip.MustParseAddr("2001:db8::10"),
+				Family:    INET6,
+				FwdMethod: Masquerade,
+			},
+			fields: []string{"Family"},
+		},
+		{
+			name: "family inferred",
+			dest: Destination{
+				Address: netip.MustParseAddr("2001:db8::10"),
+			},
+			fields: []string{"Family"},
+		},
+		{
+			name: "family mismatch",
+			dest: Destination{
+				Address:   netip.MustParseAddr("2001:db8::10"),
+				Family:    INET,
+				FwdMethod: Tunnel,
+			},
+			fields: []string{"Family"},
+		},
+		{
+			name: "thresholds",
+			dest: Destination{
+				Address:        netip.MustParseAddr("192.0.2.10"),
+				UpperThreshold: 10,
+				LowerThreshold: 20,
+			},
+			fields: []string{"LowerThreshold"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			run(t, tc)
+		})
+	}
+}
+
+// recordingClient records the Services created through it.
+type recordingClient struct {
+	Client
+	created []Service
+}
+
+func (c *recordingClient) CreateService(svc Service) error {
+	c.created = append(c.created, svc)
+	return nil
+}
+
+func TestValidating(t *testing.T) {
+	rc := &recordingClient{}
+	c := Validating(rc)
+
+	assert.NilError(t, c.CreateService(validService))
+
+	invalid := validService
+	invalid.Scheduler = ""
+	err := c.CreateService(invalid)
+	assert.ErrorContains(t, err, "ipvs: Scheduler: name is empty")
+
+	assert.Equal(t, len(rc.created), 1)
+	assert.Assert(t, rc.created[0] == validService)
+}